@@ -6,6 +6,7 @@ require (
 	github.com/aler9/gomavlib v1.3.0
 	github.com/asticode/go-astiav v0.33.1
 	github.com/harshabose/tools/buffer v0.0.0
+	github.com/pion/rtcp v1.2.15
 	github.com/pion/rtp v1.8.11
 )
 
@@ -0,0 +1,128 @@
+package transcode
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultLadderHysteresis = 0.2
+	defaultLadderHoldTime   = 5 * time.Second
+	ladderApplyTimeout      = time.Second
+)
+
+// AdaptationRung is one step of an AdaptationLadder: the video output used while the bitrate stays at or above
+// MinBitrate. A zero Width/Height keeps the source size and a zero FPS keeps the source frame rate.
+type AdaptationRung struct {
+	MinBitrate int64
+	Width      uint16
+	Height     uint16
+	FPS        uint8
+}
+
+func (r AdaptationRung) output() VideoOutput {
+	return VideoOutput{Width: r.Width, Height: r.Height, FPS: r.FPS}
+}
+
+// AdaptationLadder maps bitrates to video outputs. Rungs are ordered from the highest MinBitrate to the lowest; the
+// last rung is used for everything below it. The ladder steps down as soon as the bitrate drops below the current
+// rung, but only steps up once the bitrate has been Hysteresis above the next rung for HoldTime, so an estimate
+// hovering around a threshold does not keep rebuilding the encoder.
+type AdaptationLadder struct {
+	Rungs      []AdaptationRung
+	Hysteresis float64
+	HoldTime   time.Duration
+}
+
+func NewAdaptationLadder(rungs ...AdaptationRung) *AdaptationLadder {
+	return &AdaptationLadder{
+		Rungs:      rungs,
+		Hysteresis: defaultLadderHysteresis,
+		HoldTime:   defaultLadderHoldTime,
+	}
+}
+
+func (l *AdaptationLadder) validate() error {
+	if len(l.Rungs) == 0 {
+		return fmt.Errorf("adaptation ladder needs at least one rung")
+	}
+
+	for i := 1; i < len(l.Rungs); i++ {
+		if l.Rungs[i].MinBitrate >= l.Rungs[i-1].MinBitrate {
+			return fmt.Errorf("adaptation ladder rungs need to be ordered by decreasing bitrate")
+		}
+	}
+
+	if l.Hysteresis < 0 || l.HoldTime < 0 {
+		return fmt.Errorf("adaptation ladder hysteresis and hold time cannot be negative")
+	}
+
+	return nil
+}
+
+type ladderState struct {
+	ladder   *AdaptationLadder
+	current  int
+	previous int
+	since    time.Time
+}
+
+// newLadderState starts at the top rung, which is expected to match what the filter produces before it is adapted;
+// the first bitrate update moves it down if needed.
+func newLadderState(ladder *AdaptationLadder) *ladderState {
+	return &ladderState{ladder: ladder}
+}
+
+func (s *ladderState) rung() AdaptationRung {
+	return s.ladder.Rungs[s.current]
+}
+
+func (s *ladderState) rungFor(bps int64) int {
+	for i, rung := range s.ladder.Rungs {
+		if bps >= rung.MinBitrate {
+			return i
+		}
+	}
+
+	return len(s.ladder.Rungs) - 1
+}
+
+// revert undoes the last change of rung, for when applying it failed.
+func (s *ladderState) revert() AdaptationRung {
+	s.current = s.previous
+	s.since = time.Time{}
+
+	return s.rung()
+}
+
+// next returns the rung for the bitrate and whether it differs from the current one.
+func (s *ladderState) next(bps int64, now time.Time) (AdaptationRung, bool) {
+	target := s.rungFor(bps)
+
+	switch {
+	case target > s.current:
+		s.previous, s.current = s.current, target
+		s.since = time.Time{}
+		return s.rung(), true
+	case target < s.current:
+		// NOTE: ONLY EVER STEP UP ONE RUNG AT A TIME
+		threshold := float64(s.ladder.Rungs[s.current-1].MinBitrate) * (1 + s.ladder.Hysteresis)
+		if float64(bps) < threshold {
+			s.since = time.Time{}
+			return s.rung(), false
+		}
+		if s.since.IsZero() {
+			s.since = now
+		}
+		if now.Sub(s.since) < s.ladder.HoldTime {
+			return s.rung(), false
+		}
+
+		s.previous, s.current = s.current, s.current-1
+		s.since = time.Time{}
+		return s.rung(), true
+	default:
+		s.since = time.Time{}
+		return s.rung(), false
+	}
+}
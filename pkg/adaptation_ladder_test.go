@@ -0,0 +1,144 @@
+package transcode
+
+import (
+	"testing"
+	"time"
+)
+
+func testLadder() *AdaptationLadder {
+	return NewAdaptationLadder(
+		AdaptationRung{MinBitrate: 2_000_000, Width: 1920, Height: 1080, FPS: 30},
+		AdaptationRung{MinBitrate: 1_000_000, Width: 1280, Height: 720, FPS: 30},
+		AdaptationRung{MinBitrate: 0, Width: 640, Height: 480, FPS: 15},
+	)
+}
+
+type ladderUpdate struct {
+	bps     int64
+	at      time.Duration
+	rung    int
+	changed bool
+}
+
+func TestLadderState(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []ladderUpdate
+	}{
+		{
+			name: "stays on the top rung",
+			updates: []ladderUpdate{
+				{bps: 2_500_000, rung: 0},
+				{bps: 2_000_000, at: time.Second, rung: 0},
+			},
+		},
+		{
+			name: "steps down at once",
+			updates: []ladderUpdate{
+				{bps: 1_500_000, rung: 1, changed: true},
+				{bps: 500_000, at: 10 * time.Millisecond, rung: 2, changed: true},
+			},
+		},
+		{
+			name: "steps down several rungs at once",
+			updates: []ladderUpdate{
+				{bps: 300_000, rung: 2, changed: true},
+			},
+		},
+		{
+			name: "does not step up within the hysteresis",
+			updates: []ladderUpdate{
+				{bps: 500_000, rung: 2, changed: true},
+				{bps: 1_100_000, at: time.Second, rung: 2},
+				{bps: 1_150_000, at: 10 * time.Second, rung: 2},
+			},
+		},
+		{
+			name: "steps up after the hold time",
+			updates: []ladderUpdate{
+				{bps: 500_000, rung: 2, changed: true},
+				{bps: 1_300_000, at: time.Second, rung: 2},
+				{bps: 1_300_000, at: 5 * time.Second, rung: 2},
+				{bps: 1_300_000, at: 6 * time.Second, rung: 1, changed: true},
+			},
+		},
+		{
+			name: "a dip restarts the hold time",
+			updates: []ladderUpdate{
+				{bps: 500_000, rung: 2, changed: true},
+				{bps: 1_300_000, at: time.Second, rung: 2},
+				{bps: 1_100_000, at: 4 * time.Second, rung: 2},
+				{bps: 1_300_000, at: 5 * time.Second, rung: 2},
+				{bps: 1_300_000, at: 9 * time.Second, rung: 2},
+				{bps: 1_300_000, at: 10 * time.Second, rung: 1, changed: true},
+			},
+		},
+		{
+			name: "steps up one rung at a time",
+			updates: []ladderUpdate{
+				{bps: 500_000, rung: 2, changed: true},
+				{bps: 5_000_000, at: time.Second, rung: 2},
+				{bps: 5_000_000, at: 6 * time.Second, rung: 1, changed: true},
+				{bps: 5_000_000, at: 7 * time.Second, rung: 1},
+				{bps: 5_000_000, at: 12 * time.Second, rung: 0, changed: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newLadderState(testLadder())
+			start := time.Now()
+
+			for i, update := range test.updates {
+				rung, changed := state.next(update.bps, start.Add(update.at))
+				if state.current != update.rung || changed != update.changed {
+					t.Fatalf("update %d (%d bps at %s): got rung %d changed %v, want rung %d changed %v",
+						i, update.bps, update.at, state.current, changed, update.rung, update.changed)
+				}
+				if rung != state.ladder.Rungs[update.rung] {
+					t.Fatalf("update %d: got %+v, want %+v", i, rung, state.ladder.Rungs[update.rung])
+				}
+			}
+		})
+	}
+}
+
+func TestLadderStateRevert(t *testing.T) {
+	state := newLadderState(testLadder())
+	start := time.Now()
+
+	state.next(500_000, start)
+	if rung := state.revert(); rung != state.ladder.Rungs[0] || state.current != 0 {
+		t.Fatalf("reverted to %+v, want the top rung", rung)
+	}
+
+	// NOTE: THE NEXT UPDATE STEPS DOWN AGAIN; STEPPING UP STILL WAITS FOR THE HOLD TIME
+	state.next(500_000, start)
+	state.next(1_300_000, start.Add(time.Second))
+	if _, changed := state.next(1_300_000, start.Add(3*time.Second)); changed {
+		t.Fatalf("stepped up before the hold time")
+	}
+}
+
+func TestAdaptationLadderValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		ladder *AdaptationLadder
+		valid  bool
+	}{
+		{name: "valid", ladder: testLadder(), valid: true},
+		{name: "no rungs", ladder: NewAdaptationLadder()},
+		{name: "increasing bitrates", ladder: NewAdaptationLadder(AdaptationRung{MinBitrate: 100}, AdaptationRung{MinBitrate: 200})},
+		{name: "equal bitrates", ladder: NewAdaptationLadder(AdaptationRung{MinBitrate: 100}, AdaptationRung{MinBitrate: 100})},
+		{name: "negative hold time", ladder: &AdaptationLadder{Rungs: []AdaptationRung{{}}, HoldTime: -time.Second}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.ladder.validate(); (err == nil) != test.valid {
+				t.Fatalf("got %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package transcode
+
+import (
+	"strconv"
+)
+
+func audioBitrate(kbps string) (int64, error) {
+	bitrate, err := strconv.ParseInt(kbps, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return bitrate * 1000, nil
+}
+
+// OpusSettings are the libopus options. FEC, DTX and the expected packet loss only take effect with the voip or
+// lowdelay applications.
+type OpusSettings struct {
+	Bitrate       string `codec:"b,suffix=k,omitempty"`
+	Application   string `codec:"application,omitempty"`       // voip, audio or lowdelay
+	FEC           string `codec:"fec,omitempty"`               // in-band forward error correction
+	DTX           string `codec:"dtx,omitempty"`               // discontinuous transmission on silence
+	PacketLoss    string `codec:"packet_loss,omitempty"`       // expected packet loss in percent; sizes the FEC
+	Complexity    string `codec:"compression_level,omitempty"` // 0 to 10
+	FrameDuration string `codec:"frame_duration,omitempty"`    // in milliseconds; sets the encoder frame size
+	VBR           string `codec:"vbr,omitempty"`               // off, on or constrained
+}
+
+func (s *OpusSettings) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(s, f)
+}
+
+func (s *OpusSettings) UpdateBitrate(bps int64) error {
+	// NOTE: libopus SUPPORTS 6 TO 510 KBPS
+	s.Bitrate = strconv.FormatInt(min(max(bps/1000, 6), 510), 10)
+	return nil
+}
+
+func (s *OpusSettings) GetCurrentBitrate() (int64, error) {
+	return audioBitrate(s.Bitrate)
+}
+
+// AACSettings are the options of the native FFmpeg AAC encoder. AAC has no FEC or DTX; loss has to be handled by the
+// transport.
+type AACSettings struct {
+	Bitrate string `codec:"b,suffix=k,omitempty"`
+	Profile string `codec:"profile,omitempty"`   // aac_low, mpeg2_aac_low, aac_ltp or aac_main
+	Coder   string `codec:"aac_coder,omitempty"` // twoloop, anmr or fast
+	Cutoff  string `codec:"cutoff,omitempty"`    // low pass cutoff in Hz; 0 lets the encoder decide
+}
+
+func (s *AACSettings) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(s, f)
+}
+
+func (s *AACSettings) UpdateBitrate(bps int64) error {
+	s.Bitrate = strconv.FormatInt(max(bps/1000, 16), 10)
+	return nil
+}
+
+func (s *AACSettings) GetCurrentBitrate() (int64, error) {
+	return audioBitrate(s.Bitrate)
+}
+
+var VoIPOpusSettings = OpusSettings{
+	Bitrate:       "32",
+	Application:   "voip",
+	FEC:           "1",
+	DTX:           "1",
+	PacketLoss:    "10",
+	Complexity:    "10",
+	FrameDuration: "20",
+	VBR:           "constrained",
+}
+
+var MusicOpusSettings = OpusSettings{
+	Bitrate:       "128",
+	Application:   "audio",
+	FEC:           "0",
+	DTX:           "0",
+	PacketLoss:    "0",
+	Complexity:    "10",
+	FrameDuration: "20",
+	VBR:           "on",
+}
+
+var DefaultAACSettings = AACSettings{
+	Bitrate: "128",
+	Profile: "aac_low",
+	Coder:   "twoloop",
+	Cutoff:  "0",
+}
@@ -0,0 +1,119 @@
+package transcode
+
+import (
+	"github.com/asticode/go-astiav"
+)
+
+const (
+	// av1CodecName is the libavcodec name of AV1; go-astiav has no constant for its codec ID.
+	av1CodecName = "av1"
+
+	av1OBUSequenceHeader    = 1
+	av1OBUTemporalDelimiter = 2
+)
+
+// AV1CodecID returns the codec ID of AV1 from whichever AV1 encoder libavcodec was built with.
+func AV1CodecID() (astiav.CodecID, error) {
+	for _, name := range []string{"libsvtav1", "libaom-av1", "librav1e"} {
+		if codec := astiav.FindEncoderByName(name); codec != nil {
+			return codec.ID(), nil
+		}
+	}
+
+	return astiav.CodecIDNone, ErrorNoCodecFound
+}
+
+func isAV1(codecID astiav.CodecID) bool {
+	return codecID.Name() == av1CodecName
+}
+
+type av1OBU struct {
+	obuType uint8
+	data    []byte // NOTE: THE WHOLE OBU, HEADER AND SIZE FIELD INCLUDED
+}
+
+// splitAV1OBUs splits a low overhead bitstream (AV1 spec 5.2) into its OBUs. An OBU without a size field extends to the
+// end of the data.
+func splitAV1OBUs(data []byte) ([]av1OBU, error) {
+	var obus []av1OBU
+
+	for len(data) > 0 {
+		header := data[0]
+		obuType := (header >> 3) & 0x0F
+		headerSize := 1
+		if header&0x04 != 0 {
+			headerSize++ // NOTE: EXTENSION HEADER
+		}
+		if len(data) < headerSize {
+			return nil, errorBitstreamTooShort
+		}
+
+		size := len(data) - headerSize
+		if header&0x02 != 0 {
+			value, n := readLEB128(data[headerSize:])
+			if n == 0 {
+				return nil, errorBitstreamTooShort
+			}
+			headerSize += n
+			size = int(value)
+		}
+		if size < 0 || len(data) < headerSize+size {
+			return nil, errorBitstreamTooShort
+		}
+
+		obus = append(obus, av1OBU{obuType: obuType, data: data[:headerSize+size]})
+		data = data[headerSize+size:]
+	}
+
+	return obus, nil
+}
+
+// readLEB128 returns the value and the number of bytes read, or 0 bytes if data ends early.
+func readLEB128(data []byte) (uint64, int) {
+	var value uint64
+
+	for i := 0; i < 8 && i < len(data); i++ {
+		value |= uint64(data[i]&0x7F) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+
+	return 0, 0
+}
+
+// parseAV1SequenceHeader returns the sequence header OBU of AV1 extradata, which is either raw OBUs (what libaom and
+// SVT-AV1 return as global headers) or an AV1CodecConfigurationRecord (av1C; what MP4 and Matroska demuxers produce),
+// whose config OBUs follow a 4 byte header.
+func parseAV1SequenceHeader(extraData []byte) ([]byte, error) {
+	if len(extraData) >= 4 && extraData[0] == 0x81 {
+		// NOTE: MARKER BIT AND VERSION 1 OF av1C; AN OBU HEADER HAS ITS FORBIDDEN BIT CLEARED
+		extraData = extraData[4:]
+	}
+
+	obus, err := splitAV1OBUs(extraData)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obu := range obus {
+		if obu.obuType == av1OBUSequenceHeader {
+			return obu.data, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// withAV1SequenceHeader inserts the sequence header into a temporal unit, after its temporal delimiter which has to
+// stay the first OBU.
+func withAV1SequenceHeader(sequenceHeader, temporalUnit []byte) []byte {
+	data := make([]byte, 0, len(sequenceHeader)+len(temporalUnit))
+
+	if obus, err := splitAV1OBUs(temporalUnit); err == nil && len(obus) > 0 && obus[0].obuType == av1OBUTemporalDelimiter {
+		data = append(data, obus[0].data...)
+		temporalUnit = temporalUnit[len(obus[0].data):]
+	}
+
+	return append(append(data, sequenceHeader...), temporalUnit...)
+}
@@ -0,0 +1,149 @@
+package transcode
+
+import (
+	"strconv"
+)
+
+// AV1RateControl is the rate control shared by SVTAV1Settings and LibAOMSettings. Both wrappers switch to CBR when
+// the maximum (and for libaom the minimum) rate equals the bitrate. The rates are in kbps like the x264 settings.
+type AV1RateControl struct {
+	Bitrate string `codec:"b,suffix=k,omitempty"`
+	MinRate string `codec:"minrate,suffix=k,omitempty"`
+	MaxRate string `codec:"maxrate,suffix=k,omitempty"`
+	BufSize string `codec:"bufsize,suffix=k,omitempty"`
+}
+
+func (o *AV1RateControl) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(o, f)
+}
+
+func (o *AV1RateControl) UpdateBitrate(bps int64) error {
+	kbps := bps / 1000
+
+	o.Bitrate = strconv.FormatInt(kbps, 10)
+	o.MinRate = strconv.FormatInt(kbps, 10)
+	o.MaxRate = strconv.FormatInt(kbps, 10)
+	o.BufSize = strconv.FormatInt(max(kbps/2, 100), 10) // 0.5 seconds, at least 100 kb
+
+	return nil
+}
+
+func (o *AV1RateControl) GetCurrentBitrate() (int64, error) {
+	kbps, err := strconv.ParseInt(o.Bitrate, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return kbps * 1000, nil
+}
+
+func (o *AV1RateControl) rateControl() (RateControlConfig, error) {
+	if o == nil {
+		return RateControlConfig{}, ErrorCodecNoSetting
+	}
+	return rateControlFromKbps(o.Bitrate, o.MaxRate, o.BufSize)
+}
+
+type SVTAV1Settings struct {
+	*AV1RateControl
+	// WRAPPER OPTIONS
+	Preset    string `codec:"preset,omitempty"` // 0 to 13; 10 and above are realtime speeds
+	KeyIntMax string `codec:"g,omitempty"`
+	QMin      string `codec:"qmin,omitempty"`
+	QMax      string `codec:"qmax,omitempty"`
+
+	// SVT-AV1 PARAMETERS
+	PredStruct        string `codec:"pred-struct,opts=svtav1-params,omitempty"`   // 1 is low delay
+	LookAhead         string `codec:"lookahead,opts=svtav1-params,omitempty"`     // 0 for no lookahead
+	SceneDetection    string `codec:"scd,opts=svtav1-params,omitempty"`           // 0 or 1
+	IntraRefreshType  string `codec:"irefresh-type,opts=svtav1-params,omitempty"` // 1 is open GOP, 2 is closed GOP
+	FastDecode        string `codec:"fast-decode,opts=svtav1-params,omitempty"`   // 0 to 2
+	Tune              string `codec:"tune,opts=svtav1-params,omitempty"`          // 0 is VQ, 1 is PSNR
+	LogicalProcessors string `codec:"lp,opts=svtav1-params,omitempty"`            // 0 for all
+}
+
+func (s *SVTAV1Settings) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(s, f)
+}
+
+func (s *SVTAV1Settings) UpdateBitrate(bps int64) error {
+	return s.AV1RateControl.UpdateBitrate(bps)
+}
+
+func (s *SVTAV1Settings) encoderName() string {
+	return "libsvtav1"
+}
+
+type LibAOMSettings struct {
+	*AV1RateControl
+	Usage              string `codec:"usage,omitempty"`                // good, realtime or allintra
+	CPUUsed            string `codec:"cpu-used,omitempty"`             // 0 to 10 with realtime usage; higher is faster
+	LagInFrames        string `codec:"lag-in-frames,omitempty"`        // 0 for no lookahead
+	ErrorResilience    string `codec:"error-resilience,omitempty"`     // default
+	RowMT              string `codec:"row-mt,omitempty"`               // row based multithreading
+	TileColumns        string `codec:"tile-columns,omitempty"`         // log2 of the tile columns
+	TileRows           string `codec:"tile-rows,omitempty"`            // log2 of the tile rows
+	AQMode             string `codec:"aq-mode,omitempty"`              // 3 is cyclic refresh
+	KeyIntMax          string `codec:"g,omitempty"`                    // keyframe interval
+	KeyIntMin          string `codec:"keyint_min,omitempty"`           // minimum keyframe interval
+	QMin               string `codec:"qmin,omitempty"`                 // 0 to 63
+	QMax               string `codec:"qmax,omitempty"`                 // 0 to 63
+	UndershootPct      string `codec:"undershoot-pct,omitempty"`       // rate control undershoot
+	OvershootPct       string `codec:"overshoot-pct,omitempty"`        // rate control overshoot
+	EnableCDEF         string `codec:"enable-cdef,omitempty"`          // constrained directional enhancement filter
+	EnableGlobalMotion string `codec:"enable-global-motion,omitempty"` // expensive; off for realtime
+	Threads            string `codec:"threads,omitempty"`              // 0 for auto
+}
+
+func (s *LibAOMSettings) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(s, f)
+}
+
+func (s *LibAOMSettings) UpdateBitrate(bps int64) error {
+	return s.AV1RateControl.UpdateBitrate(bps)
+}
+
+func (s *LibAOMSettings) encoderName() string {
+	return "libaom-av1"
+}
+
+var LowLatencyBitrateControlledSVTAV1 = &SVTAV1Settings{
+	AV1RateControl: &AV1RateControl{
+		Bitrate: "500",
+		MinRate: "500",
+		MaxRate: "500",
+		BufSize: "250",
+	},
+	Preset:            "12",
+	KeyIntMax:         "25",
+	PredStruct:        "1",
+	LookAhead:         "0",
+	SceneDetection:    "0",
+	IntraRefreshType:  "2",
+	FastDecode:        "1",
+	Tune:              "1",
+	LogicalProcessors: "0",
+}
+
+var LowLatencyBitrateControlledLibAOM = &LibAOMSettings{
+	AV1RateControl: &AV1RateControl{
+		Bitrate: "500",
+		MinRate: "500",
+		MaxRate: "500",
+		BufSize: "250",
+	},
+	Usage:              "realtime",
+	CPUUsed:            "10",
+	LagInFrames:        "0",
+	ErrorResilience:    "default",
+	RowMT:              "1",
+	TileColumns:        "1",
+	TileRows:           "0",
+	AQMode:             "3",
+	KeyIntMax:          "25",
+	KeyIntMin:          "13",
+	UndershootPct:      "50",
+	OvershootPct:       "50",
+	EnableCDEF:         "1",
+	EnableGlobalMotion: "0",
+	Threads:            "0",
+}
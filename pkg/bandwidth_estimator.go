@@ -0,0 +1,386 @@
+package transcode
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	defaultEstimatorInterval   = 500 * time.Millisecond
+	defaultEstimatorSmoothing  = 0.3
+	defaultREMBTimeout         = 3 * time.Second
+	defaultEstimatorMinBitrate = 100_000
+	defaultEstimatorMaxBitrate = 10_000_000
+	maxLossIncreaseInterval    = time.Second // NOTE: A LONG GAP BETWEEN REPORTS DOES NOT TURN INTO ONE BIG STEP
+	maxSentHistory             = 4096
+	lossIncreaseThreshold      = 0.02
+	lossDecreaseThreshold      = 0.10
+	rateIncreasePerSecond      = 1.08
+	overuseBackoff             = 0.85
+)
+
+type sentPacket struct {
+	size int
+	at   time.Time
+}
+
+// BandwidthEstimator is a send-side congestion controller in the spirit of Google Congestion Control. The application
+// pushes the RTCP packets it receives (receiver reports, REMB, transport-wide CC feedback) and, for transport-wide
+// CC, reports every sent packet with OnPacketSent. The estimate is the minimum of a loss based rate, a delay based
+// rate and the last REMB; it is smoothed, kept within the bounds of the estimator (see WithEstimatorBitrateBounds) and
+// handed to the UpdateBitrateCallBack of every target. A target that exposes its bounds with CanGetBitrateBounds, like
+// UpdateEncoder and MultiUpdateEncoder, gets the estimate clamped to its own UpdateConfig bounds; any other target,
+// like a Transcoder, gets it as is and clamps it itself. A REMB that is not refreshed within the REMB timeout no longer
+// limits the estimate.
+type BandwidthEstimator struct {
+	minBitrate  int64
+	maxBitrate  int64
+	initial     int64 // NOTE: 0 STARTS AT THE MAXIMUM BITRATE
+	targets     []*estimatorTarget
+	interval    time.Duration
+	smoothing   float64
+	rembTimeout time.Duration
+
+	lossRate   float64
+	lossReport time.Time
+	delayRate  float64
+	remb       float64
+	rembAt     time.Time
+	estimate   float64
+	lastTick   time.Time
+
+	sent      map[uint16]sentPacket
+	trend     *trendlineEstimator
+	usage     bandwidthUsage
+	ackedRate float64
+
+	mux    sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// estimatorTarget is a consumer of the estimate and the last bitrate it was given.
+type estimatorTarget struct {
+	callback UpdateBitrateCallBack
+	bounds   CanGetBitrateBounds // NOTE: NIL IF THE TARGET CLAMPS THE ESTIMATE ITSELF
+	lastSent int64
+}
+
+func (target *estimatorTarget) clamp(bps int64) int64 {
+	if target.bounds == nil {
+		return bps
+	}
+
+	minBitrate, maxBitrate := target.bounds.BitrateBounds()
+	return max(minBitrate, min(maxBitrate, bps))
+}
+
+func CreateBandwidthEstimator(ctx context.Context, options ...BandwidthEstimatorOption) (*BandwidthEstimator, error) {
+	ctx2, cancel := context.WithCancel(ctx)
+	estimator := &BandwidthEstimator{
+		minBitrate:  defaultEstimatorMinBitrate,
+		maxBitrate:  defaultEstimatorMaxBitrate,
+		interval:    defaultEstimatorInterval,
+		smoothing:   defaultEstimatorSmoothing,
+		rembTimeout: defaultREMBTimeout,
+		sent:        make(map[uint16]sentPacket),
+		trend:       newTrendlineEstimator(),
+		remb:        math.Inf(1),
+		ctx:         ctx2,
+		cancel:      cancel,
+	}
+
+	for _, option := range options {
+		if err := option(estimator); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	initial := estimator.maxBitrate
+	if estimator.initial > 0 {
+		initial = estimator.clamp(float64(estimator.initial))
+	}
+	estimator.setInitialBitrate(float64(initial))
+
+	return estimator, nil
+}
+
+func (estimator *BandwidthEstimator) setInitialBitrate(bps float64) {
+	estimator.lossRate = bps
+	estimator.delayRate = bps
+	estimator.estimate = bps
+}
+
+// AddTarget registers a consumer of the estimate, e.g. a Transcoder, UpdateEncoder or MultiUpdateEncoder.
+func (estimator *BandwidthEstimator) AddTarget(target CanGetUpdateBitrateCallBack) {
+	estimator.mux.Lock()
+	defer estimator.mux.Unlock()
+
+	bounds, _ := target.(CanGetBitrateBounds)
+	estimator.targets = append(estimator.targets, &estimatorTarget{callback: target.OnUpdateBitrate(), bounds: bounds})
+}
+
+func (estimator *BandwidthEstimator) Ctx() context.Context {
+	return estimator.ctx
+}
+
+func (estimator *BandwidthEstimator) Start() {
+	go estimator.loop()
+}
+
+func (estimator *BandwidthEstimator) Stop() {
+	estimator.cancel()
+}
+
+// Estimate returns the current smoothed and clamped estimate in bits per second.
+func (estimator *BandwidthEstimator) Estimate() int64 {
+	estimator.mux.Lock()
+	defer estimator.mux.Unlock()
+
+	return estimator.clamp(estimator.estimate)
+}
+
+// OnPacketSent records a sent RTP packet by its transport-wide sequence number. Without it transport-wide CC feedback
+// cannot be used and only loss and REMB drive the estimate.
+func (estimator *BandwidthEstimator) OnPacketSent(sequenceNumber uint16, size int, at time.Time) {
+	estimator.mux.Lock()
+	defer estimator.mux.Unlock()
+
+	estimator.sent[sequenceNumber] = sentPacket{size: size, at: at}
+	delete(estimator.sent, sequenceNumber-maxSentHistory)
+}
+
+// PushRTCPBytes unmarshals a (compound) RTCP packet and pushes its contents.
+func (estimator *BandwidthEstimator) PushRTCPBytes(raw []byte) error {
+	packets, err := rtcp.Unmarshal(raw)
+	if err != nil {
+		return err
+	}
+
+	estimator.PushRTCP(packets...)
+	return nil
+}
+
+// PushRTCP feeds received RTCP packets to the estimator. Packet types that carry no congestion information are ignored.
+func (estimator *BandwidthEstimator) PushRTCP(packets ...rtcp.Packet) {
+	estimator.pushRTCP(time.Now(), packets...)
+}
+
+func (estimator *BandwidthEstimator) pushRTCP(now time.Time, packets ...rtcp.Packet) {
+	estimator.mux.Lock()
+	defer estimator.mux.Unlock()
+
+	for _, packet := range packets {
+		switch p := packet.(type) {
+		case *rtcp.ReceiverReport:
+			estimator.onReceptionReports(p.Reports, now)
+		case *rtcp.SenderReport:
+			estimator.onReceptionReports(p.Reports, now)
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			estimator.remb = float64(p.Bitrate)
+			estimator.rembAt = now
+		case *rtcp.TransportLayerCC:
+			estimator.onTransportLayerCC(p, now)
+		}
+	}
+}
+
+// onReceptionReports runs the loss based controller: increase below 2% loss by 8% per second since the previous
+// report, hold between 2% and 10%, decrease in proportion to the loss above 10%. The increase follows the time between
+// reports, so it does not depend on how often the receiver sends them.
+func (estimator *BandwidthEstimator) onReceptionReports(reports []rtcp.ReceptionReport, now time.Time) {
+	if len(reports) == 0 {
+		return
+	}
+
+	var elapsed time.Duration
+	if !estimator.lossReport.IsZero() {
+		elapsed = min(now.Sub(estimator.lossReport), maxLossIncreaseInterval)
+	}
+	estimator.lossReport = now
+
+	loss := 0.0
+	for _, report := range reports {
+		loss = math.Max(loss, float64(report.FractionLost)/256)
+	}
+
+	switch {
+	case loss < lossIncreaseThreshold:
+		estimator.lossRate = math.Max(estimator.lossRate, estimator.estimate) * math.Pow(rateIncreasePerSecond, max(elapsed, 0).Seconds())
+	case loss > lossDecreaseThreshold:
+		estimator.lossRate = estimator.estimate * (1 - 0.5*loss)
+	}
+
+	estimator.lossRate = float64(estimator.clamp(estimator.lossRate))
+}
+
+// onTransportLayerCC runs the delay based controller on the arrival times reported in transport-wide CC feedback.
+func (estimator *BandwidthEstimator) onTransportLayerCC(feedback *rtcp.TransportLayerCC, now time.Time) {
+	arrival := time.Duration(feedback.ReferenceTime) * 64 * time.Millisecond
+	deltas := feedback.RecvDeltas
+	sequenceNumber := feedback.BaseSequenceNumber
+
+	var (
+		previousSent, firstArrival, lastArrival time.Duration
+		previous                                *sentPacket
+		ackedBytes                              int
+	)
+
+	for _, symbol := range transportCCSymbols(feedback) {
+		current := sequenceNumber
+		sequenceNumber++
+
+		if symbol == rtcp.TypeTCCPacketNotReceived || symbol == rtcp.TypeTCCPacketReceivedWithoutDelta {
+			continue
+		}
+		if len(deltas) == 0 {
+			break
+		}
+		arrival += time.Duration(deltas[0].Delta) * time.Microsecond
+		deltas = deltas[1:]
+
+		sent, ok := estimator.sent[current]
+		if !ok {
+			continue
+		}
+		sentAt := time.Duration(sent.at.UnixNano())
+
+		if previous == nil {
+			firstArrival = arrival
+		} else {
+			gradient := (arrival - lastArrival) - (sentAt - previousSent)
+			estimator.usage = estimator.trend.update(float64(gradient)/float64(time.Millisecond), float64(arrival)/float64(time.Millisecond))
+			ackedBytes += sent.size
+		}
+
+		previous = &sent
+		previousSent = sentAt
+		lastArrival = arrival
+	}
+
+	if span := lastArrival - firstArrival; span > 0 && ackedBytes > 0 {
+		rate := float64(ackedBytes*8) / span.Seconds()
+		if estimator.ackedRate == 0 {
+			estimator.ackedRate = rate
+		} else {
+			estimator.ackedRate = 0.95*estimator.ackedRate + 0.05*rate
+		}
+	}
+
+	estimator.updateDelayRate(now)
+}
+
+func (estimator *BandwidthEstimator) updateDelayRate(now time.Time) {
+	elapsed := time.Second
+	if !estimator.lastTick.IsZero() {
+		elapsed = now.Sub(estimator.lastTick)
+	}
+	estimator.lastTick = now
+
+	switch estimator.usage {
+	case bandwidthOverusing:
+		base := estimator.estimate
+		if estimator.ackedRate > 0 {
+			base = estimator.ackedRate
+		}
+		estimator.delayRate = math.Min(estimator.delayRate, overuseBackoff*base)
+	case bandwidthNormal:
+		estimator.delayRate *= math.Pow(rateIncreasePerSecond, elapsed.Seconds())
+		if estimator.ackedRate > 0 {
+			// NOTE: DO NOT PROBE FAR BEYOND WHAT IS ACTUALLY GETTING THROUGH
+			estimator.delayRate = math.Min(estimator.delayRate, 1.5*estimator.ackedRate+float64(estimator.minBitrate))
+		}
+	case bandwidthUnderusing:
+		// NOTE: QUEUES ARE DRAINING; HOLD UNTIL THEY ARE EMPTY
+	}
+
+	estimator.delayRate = float64(estimator.clamp(estimator.delayRate))
+}
+
+func (estimator *BandwidthEstimator) loop() {
+	ticker := time.NewTicker(estimator.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-estimator.ctx.Done():
+			return
+		case now := <-ticker.C:
+			estimator.update(now)
+		}
+	}
+}
+
+// update smooths the controllers' minimum into the estimate and notifies the targets. Decreases are applied at once,
+// increases are smoothed.
+func (estimator *BandwidthEstimator) update(now time.Time) {
+	estimator.mux.Lock()
+
+	target := math.Min(math.Min(estimator.lossRate, estimator.delayRate), estimator.rembLimit(now))
+	if target < estimator.estimate {
+		estimator.estimate = target
+	} else {
+		estimator.estimate += estimator.smoothing * (target - estimator.estimate)
+	}
+
+	bps := estimator.clamp(estimator.estimate)
+
+	type update struct {
+		callback UpdateBitrateCallBack
+		bps      int64
+	}
+	updates := make([]update, 0, len(estimator.targets))
+	for _, target := range estimator.targets {
+		targetBps := target.clamp(bps)
+		if targetBps == target.lastSent {
+			continue
+		}
+		target.lastSent = targetBps
+		updates = append(updates, update{callback: target.callback, bps: targetBps})
+	}
+	estimator.mux.Unlock()
+
+	for _, u := range updates {
+		_ = u.callback(u.bps)
+	}
+}
+
+// rembLimit is the last REMB, or no limit once the receiver stopped refreshing it for the REMB timeout.
+func (estimator *BandwidthEstimator) rembLimit(now time.Time) float64 {
+	if estimator.rembAt.IsZero() || now.Sub(estimator.rembAt) > estimator.rembTimeout {
+		return math.Inf(1)
+	}
+
+	return estimator.remb
+}
+
+func (estimator *BandwidthEstimator) clamp(bps float64) int64 {
+	return int64(math.Max(float64(estimator.minBitrate), math.Min(float64(estimator.maxBitrate), bps)))
+}
+
+// transportCCSymbols expands the packet status chunks of the feedback into one status symbol per sequence number.
+func transportCCSymbols(feedback *rtcp.TransportLayerCC) []uint16 {
+	symbols := make([]uint16, 0, feedback.PacketStatusCount)
+
+	for _, chunk := range feedback.PacketChunks {
+		switch c := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := uint16(0); i < c.RunLength; i++ {
+				symbols = append(symbols, c.PacketStatusSymbol)
+			}
+		case *rtcp.StatusVectorChunk:
+			symbols = append(symbols, c.SymbolList...)
+		}
+	}
+
+	if len(symbols) > int(feedback.PacketStatusCount) {
+		symbols = symbols[:feedback.PacketStatusCount]
+	}
+
+	return symbols
+}
@@ -0,0 +1,77 @@
+package transcode
+
+import (
+	"fmt"
+	"time"
+)
+
+type BandwidthEstimatorOption = func(*BandwidthEstimator) error
+
+// WithEstimatorInterval sets how often the targets are updated.
+func WithEstimatorInterval(interval time.Duration) BandwidthEstimatorOption {
+	return func(estimator *BandwidthEstimator) error {
+		if interval <= 0 {
+			return fmt.Errorf("estimator interval needs to be more than 0")
+		}
+		estimator.interval = interval
+		return nil
+	}
+}
+
+// WithEstimatorSmoothing sets the weight of a new target in the estimate when it increases; 1 disables smoothing.
+func WithEstimatorSmoothing(smoothing float64) BandwidthEstimatorOption {
+	return func(estimator *BandwidthEstimator) error {
+		if smoothing <= 0 || smoothing > 1 {
+			return fmt.Errorf("estimator smoothing needs to be in (0, 1]")
+		}
+		estimator.smoothing = smoothing
+		return nil
+	}
+}
+
+// WithREMBTimeout sets how long a REMB limits the estimate without being refreshed by the receiver.
+func WithREMBTimeout(timeout time.Duration) BandwidthEstimatorOption {
+	return func(estimator *BandwidthEstimator) error {
+		if timeout <= 0 {
+			return fmt.Errorf("remb timeout needs to be more than 0")
+		}
+		estimator.rembTimeout = timeout
+		return nil
+	}
+}
+
+// WithEstimatorBitrateBounds sets the range the estimate is kept in, whatever the feedback; by default 100 kbps to
+// 10 Mbps. The targets are still given the estimate within their own bounds; see BandwidthEstimator.
+func WithEstimatorBitrateBounds(minBitrate, maxBitrate int64) BandwidthEstimatorOption {
+	return func(estimator *BandwidthEstimator) error {
+		if minBitrate <= 0 {
+			return fmt.Errorf("estimator minimum bitrate needs to be more than 0")
+		}
+		if minBitrate > maxBitrate {
+			return fmt.Errorf("estimator minimum bitrate is higher than its maximum bitrate")
+		}
+		estimator.minBitrate = minBitrate
+		estimator.maxBitrate = maxBitrate
+		return nil
+	}
+}
+
+// WithInitialBitrate sets the estimate to start from, within the estimator bounds; without it the estimator starts at
+// the maximum bitrate.
+func WithInitialBitrate(bps int64) BandwidthEstimatorOption {
+	return func(estimator *BandwidthEstimator) error {
+		if bps <= 0 {
+			return fmt.Errorf("initial bitrate needs to be more than 0")
+		}
+		estimator.initial = bps
+		return nil
+	}
+}
+
+// WithEstimatorTarget registers a target at construction; see BandwidthEstimator.AddTarget.
+func WithEstimatorTarget(target CanGetUpdateBitrateCallBack) BandwidthEstimatorOption {
+	return func(estimator *BandwidthEstimator) error {
+		estimator.AddTarget(target)
+		return nil
+	}
+}
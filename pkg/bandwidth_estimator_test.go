@@ -0,0 +1,202 @@
+package transcode
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func testBandwidthEstimator(t *testing.T, options ...BandwidthEstimatorOption) *BandwidthEstimator {
+	options = append([]BandwidthEstimatorOption{WithEstimatorBitrateBounds(100_000, 10_000_000), WithInitialBitrate(1_000_000)}, options...)
+
+	estimator, err := CreateBandwidthEstimator(context.Background(), options...)
+	if err != nil {
+		t.Fatalf("Failed to create the estimator: %v", err)
+	}
+	t.Cleanup(estimator.Stop)
+
+	return estimator
+}
+
+func testReceiverReport(fractionLost uint8) *rtcp.ReceiverReport {
+	return &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{{FractionLost: fractionLost}}}
+}
+
+func TestTrendlineEstimator(t *testing.T) {
+	tests := []struct {
+		name     string
+		gradient float64
+		samples  int
+		want     bandwidthUsage
+	}{
+		{name: "steady delay", gradient: 0, samples: 40, want: bandwidthNormal},
+		{name: "small jitter", gradient: 0.1, samples: 40, want: bandwidthNormal},
+		{name: "growing delay", gradient: 2, samples: 40, want: bandwidthOverusing},
+		{name: "shrinking delay", gradient: -2, samples: 40, want: bandwidthUnderusing},
+		{name: "not enough samples", gradient: 10, samples: trendlineWindow - 1, want: bandwidthNormal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trend := newTrendlineEstimator()
+
+			var usage bandwidthUsage
+			for i := 0; i < test.samples; i++ {
+				usage = trend.update(test.gradient, float64(i*10))
+			}
+			if usage != test.want {
+				t.Fatalf("got usage %d, want %d", usage, test.want)
+			}
+		})
+	}
+}
+
+func TestTrendlineEstimatorRecovers(t *testing.T) {
+	trend := newTrendlineEstimator()
+
+	arrival := 0.0
+	for i := 0; i < 40; i++ {
+		arrival += 10
+		trend.update(2, arrival)
+	}
+
+	var usage bandwidthUsage
+	for i := 0; i < 200; i++ {
+		arrival += 10
+		usage = trend.update(0, arrival)
+	}
+	if usage != bandwidthNormal {
+		t.Fatalf("got usage %d once the delay is steady again, want %d", usage, bandwidthNormal)
+	}
+}
+
+type lossReport struct {
+	fractionLost uint8
+	at           time.Duration
+}
+
+func TestLossBasedRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []lossReport
+		want    float64
+	}{
+		{name: "a single report does not increase", reports: []lossReport{{}}, want: 1_000_000},
+		{
+			name:    "increases with the time between reports",
+			reports: []lossReport{{}, {at: time.Second}},
+			want:    1_080_000,
+		},
+		{
+			name:    "frequent reports increase as much as one per second",
+			reports: []lossReport{{}, {at: 250 * time.Millisecond}, {at: 500 * time.Millisecond}, {at: 750 * time.Millisecond}, {at: time.Second}},
+			want:    1_080_000,
+		},
+		{
+			name:    "a long gap is capped",
+			reports: []lossReport{{}, {at: 10 * time.Second}},
+			want:    1_080_000,
+		},
+		{
+			name:    "holds between the thresholds",
+			reports: []lossReport{{}, {fractionLost: 13, at: time.Second}},
+			want:    1_000_000,
+		},
+		{
+			name:    "decreases with the loss",
+			reports: []lossReport{{}, {fractionLost: 64, at: time.Second}},
+			want:    875_000,
+		},
+		{
+			name:    "repeated reports decrease from the estimate once",
+			reports: []lossReport{{fractionLost: 255}, {fractionLost: 255, at: time.Second}, {fractionLost: 255, at: 2 * time.Second}},
+			want:    1_000_000 * (1 - 0.5*255.0/256),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			estimator := testBandwidthEstimator(t)
+			start := time.Now()
+
+			for _, report := range test.reports {
+				estimator.pushRTCP(start.Add(report.at), testReceiverReport(report.fractionLost))
+			}
+
+			// NOTE: THE RATE IS CLAMPED TO WHOLE BITS PER SECOND AFTER EVERY REPORT
+			if math.Abs(estimator.lossRate-test.want) > 10 {
+				t.Fatalf("got loss based rate %.0f, want %.0f", estimator.lossRate, test.want)
+			}
+		})
+	}
+}
+
+func TestREMBTimeout(t *testing.T) {
+	estimator := testBandwidthEstimator(t, WithEstimatorSmoothing(1))
+	start := time.Now()
+
+	estimator.pushRTCP(start, &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 500_000})
+	estimator.update(start.Add(500 * time.Millisecond))
+	if got := estimator.Estimate(); got != 500_000 {
+		t.Fatalf("got %d, want the remb of 500000", got)
+	}
+
+	estimator.pushRTCP(start.Add(2*time.Second), &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 600_000})
+	estimator.update(start.Add(4 * time.Second))
+	if got := estimator.Estimate(); got != 600_000 {
+		t.Fatalf("got %d, want the refreshed remb of 600000", got)
+	}
+
+	estimator.update(start.Add(2*time.Second + defaultREMBTimeout + time.Millisecond))
+	if got := estimator.Estimate(); got != 1_000_000 {
+		t.Fatalf("got %d after the remb timed out, want 1000000", got)
+	}
+}
+
+type testBitrateTarget struct {
+	updates []int64
+}
+
+func (target *testBitrateTarget) OnUpdateBitrate() UpdateBitrateCallBack {
+	return func(bps int64) error {
+		target.updates = append(target.updates, bps)
+		return nil
+	}
+}
+
+type testBoundedBitrateTarget struct {
+	testBitrateTarget
+	minBitrate, maxBitrate int64
+}
+
+func (target *testBoundedBitrateTarget) BitrateBounds() (int64, int64) {
+	return target.minBitrate, target.maxBitrate
+}
+
+func TestEstimateClampedPerTarget(t *testing.T) {
+	unbounded := &testBitrateTarget{}
+	bounded := &testBoundedBitrateTarget{minBitrate: 200_000, maxBitrate: 600_000}
+	estimator := testBandwidthEstimator(t, WithEstimatorSmoothing(1), WithEstimatorTarget(unbounded), WithEstimatorTarget(bounded))
+	start := time.Now()
+
+	estimator.update(start)
+	estimator.update(start.Add(500 * time.Millisecond))
+	if len(unbounded.updates) != 1 || unbounded.updates[0] != 1_000_000 {
+		t.Fatalf("got %v, want the estimate of 1000000 once", unbounded.updates)
+	}
+	if len(bounded.updates) != 1 || bounded.updates[0] != 600_000 {
+		t.Fatalf("got %v, want the estimate clamped to 600000 once", bounded.updates)
+	}
+
+	estimator.pushRTCP(start.Add(time.Second), &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 50_000})
+	estimator.update(start.Add(time.Second))
+	if got := unbounded.updates[len(unbounded.updates)-1]; got != 100_000 {
+		t.Fatalf("got %d, want the estimator minimum of 100000", got)
+	}
+	if got := bounded.updates[len(bounded.updates)-1]; got != 200_000 {
+		t.Fatalf("got %d, want the target minimum of 200000", got)
+	}
+}
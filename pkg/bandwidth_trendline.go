@@ -0,0 +1,85 @@
+package transcode
+
+const (
+	trendlineWindow    = 20
+	trendlineSmoothing = 0.9
+	trendlineGain      = 4.0
+	trendlineThreshold = 12.5 // ms
+	trendlineMaxDeltas = 60
+)
+
+type bandwidthUsage int
+
+const (
+	bandwidthNormal bandwidthUsage = iota
+	bandwidthUnderusing
+	bandwidthOverusing
+)
+
+type trendlineSample struct {
+	arrival float64
+	delay   float64
+}
+
+// trendlineEstimator detects queue build-up on the path from the one-way delay gradients of consecutive packets. The
+// accumulated delay is smoothed and a least-squares slope over the last samples decides between over-use, under-use
+// and normal; a growing delay means a queue is building up somewhere.
+type trendlineEstimator struct {
+	accumulated float64
+	smoothed    float64
+	deltas      int
+	samples     []trendlineSample
+}
+
+func newTrendlineEstimator() *trendlineEstimator {
+	return &trendlineEstimator{
+		samples: make([]trendlineSample, 0, trendlineWindow),
+	}
+}
+
+// update takes the delay gradient and arrival time of a packet, both in milliseconds.
+func (t *trendlineEstimator) update(gradient, arrival float64) bandwidthUsage {
+	t.deltas = min(t.deltas+1, trendlineMaxDeltas)
+	t.accumulated += gradient
+	t.smoothed = trendlineSmoothing*t.smoothed + (1-trendlineSmoothing)*t.accumulated
+
+	if len(t.samples) == trendlineWindow {
+		t.samples = t.samples[1:]
+	}
+	t.samples = append(t.samples, trendlineSample{arrival: arrival, delay: t.smoothed})
+
+	if len(t.samples) < trendlineWindow {
+		return bandwidthNormal
+	}
+
+	trend := t.slope() * float64(t.deltas) * trendlineGain
+	switch {
+	case trend > trendlineThreshold:
+		return bandwidthOverusing
+	case trend < -trendlineThreshold:
+		return bandwidthUnderusing
+	default:
+		return bandwidthNormal
+	}
+}
+
+func (t *trendlineEstimator) slope() float64 {
+	var meanX, meanY float64
+	for _, sample := range t.samples {
+		meanX += sample.arrival
+		meanY += sample.delay
+	}
+	meanX /= float64(len(t.samples))
+	meanY /= float64(len(t.samples))
+
+	var numerator, denominator float64
+	for _, sample := range t.samples {
+		numerator += (sample.arrival - meanX) * (sample.delay - meanY)
+		denominator += (sample.arrival - meanX) * (sample.arrival - meanX)
+	}
+
+	if denominator == 0 {
+		return 0
+	}
+	return numerator / denominator
+}
@@ -0,0 +1,240 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/asticode/go-astiav"
+
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+// GeneralBitstreamFilter runs the packets of a demuxer or an encoder through a chain of libavcodec bitstream filters
+// (h264_metadata, dump_extra, extract_extradata, vp9_superframe, ...), in the order they are named. Each filter gets
+// the output codec parameters of the one before it. go-astiav cannot set the private options of bitstream filters, so
+// the filters run with their defaults.
+type GeneralBitstreamFilter struct {
+	producer        CanProduceMediaPacket
+	describer       CanDescribeMediaPacket
+	filters         []*bitstreamFilter
+	codecParameters *astiav.CodecParameters
+	buffer          buffer.BufferWithGenerator[astiav.Packet]
+	eos             *endOfStream[astiav.Packet]
+	ctx             context.Context
+	cancel          context.CancelFunc
+}
+
+func CreateGeneralBitstreamFilter(ctx context.Context, canProduceMediaPacket CanProduceMediaPacket, names []string, options ...BitstreamFilterOption) (*GeneralBitstreamFilter, error) {
+	if len(names) == 0 {
+		return nil, ErrorNoBitstreamFilter
+	}
+
+	describer, ok := canProduceMediaPacket.(CanDescribeMediaPacket)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	filter := &GeneralBitstreamFilter{
+		producer:        canProduceMediaPacket,
+		describer:       describer,
+		codecParameters: astiav.AllocCodecParameters(),
+		eos:             newEndOfStream[astiav.Packet](),
+		ctx:             ctx2,
+		cancel:          cancel,
+	}
+
+	parameters := describer.GetCodecParameters()
+	for _, name := range names {
+		f, err := newBitstreamFilter(name, parameters, describer.TimeBase())
+		if err != nil {
+			filter.close()
+			cancel()
+			return nil, err
+		}
+
+		filter.filters = append(filter.filters, f)
+		parameters = f.ctx.OutputCodecParameters()
+	}
+
+	if err := parameters.Copy(filter.codecParameters); err != nil {
+		filter.close()
+		cancel()
+		return nil, err
+	}
+
+	for _, option := range options {
+		if err := option(filter); err != nil {
+			filter.close()
+			cancel()
+			return nil, err
+		}
+	}
+
+	if filter.buffer == nil {
+		filter.buffer = buffer.CreateChannelBuffer(ctx2, 256, internal.CreatePacketPool())
+	}
+
+	return filter, nil
+}
+
+func (filter *GeneralBitstreamFilter) Ctx() context.Context {
+	return filter.ctx
+}
+
+func (filter *GeneralBitstreamFilter) Start() {
+	go filter.loop()
+}
+
+func (filter *GeneralBitstreamFilter) Stop() {
+	filter.cancel()
+}
+
+func (filter *GeneralBitstreamFilter) loop() {
+	defer filter.close()
+
+	for {
+		select {
+		case <-filter.ctx.Done():
+			return
+		default:
+			packet, err := filter.getPacket()
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					filter.drain()
+					return
+				}
+				continue
+			}
+
+			filter.filterFrom(0, packet)
+			filter.producer.PutBack(packet)
+		}
+	}
+}
+
+// filterFrom sends the packet into the filter at index and passes on everything that filter puts out. Sending takes
+// the reference of the packet, so the caller can put it back right after.
+func (filter *GeneralBitstreamFilter) filterFrom(index int, packet *astiav.Packet) {
+	if err := filter.filters[index].ctx.SendPacket(packet); err != nil {
+		return
+	}
+
+	filter.receiveFrom(index)
+}
+
+// receiveFrom passes the packets the filter at index puts out on to the next filter, or downstream after the last one.
+func (filter *GeneralBitstreamFilter) receiveFrom(index int) {
+	for {
+		packet := filter.buffer.Generate()
+		if err := filter.filters[index].ctx.ReceivePacket(packet); err != nil {
+			filter.buffer.PutBack(packet)
+			return
+		}
+
+		if index+1 < len(filter.filters) {
+			filter.filterFrom(index+1, packet)
+			filter.buffer.PutBack(packet)
+			continue
+		}
+
+		if err := filter.pushPacket(packet); err != nil {
+			filter.buffer.PutBack(packet)
+		}
+	}
+}
+
+// drain flushes the filters in order, so the packets held by one (vp9_superframe holds frames until it can merge them)
+// still pass through the ones after it, and signals the end of stream downstream. It then waits for the filter to be
+// stopped, so it stays describable until then.
+func (filter *GeneralBitstreamFilter) drain() {
+	for index, f := range filter.filters {
+		if err := f.ctx.SendPacket(nil); err != nil {
+			continue
+		}
+		filter.receiveFrom(index)
+	}
+
+	if err := filter.eos.push(filter.ctx, filter.buffer); err != nil {
+		return
+	}
+
+	<-filter.ctx.Done()
+}
+
+func (filter *GeneralBitstreamFilter) getPacket() (*astiav.Packet, error) {
+	ctx, cancel := context.WithTimeout(filter.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return filter.producer.GetPacket(ctx)
+}
+
+func (filter *GeneralBitstreamFilter) pushPacket(packet *astiav.Packet) error {
+	ctx, cancel := context.WithTimeout(filter.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return filter.buffer.Push(ctx, packet)
+}
+
+func (filter *GeneralBitstreamFilter) GetPacket(ctx context.Context) (*astiav.Packet, error) {
+	return filter.eos.pop(ctx, filter.buffer)
+}
+
+func (filter *GeneralBitstreamFilter) PutBack(packet *astiav.Packet) {
+	filter.buffer.PutBack(packet)
+}
+
+func (filter *GeneralBitstreamFilter) SetBuffer(buffer buffer.BufferWithGenerator[astiav.Packet]) {
+	filter.buffer = buffer
+}
+
+func (filter *GeneralBitstreamFilter) close() {
+	for _, f := range filter.filters {
+		f.free()
+	}
+
+	if filter.codecParameters != nil {
+		filter.codecParameters.Free()
+	}
+}
+
+// GetParameterSets returns the first SPS and PPS of the filtered stream, each behind a start code.
+func (filter *GeneralBitstreamFilter) GetParameterSets() ([]byte, []byte, error) {
+	sets, err := filter.GetCodecParameterSets()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return annexBFirst(sets.SPS), annexBFirst(sets.PPS), nil
+}
+
+// GetCodecParameterSets returns the parameter sets in the extradata of the filtered stream.
+func (filter *GeneralBitstreamFilter) GetCodecParameterSets() (ParameterSets, error) {
+	return parseParameterSets(filter.codecParameters.CodecID(), filter.codecParameters.ExtraData())
+}
+
+// ## CanDescribeMediaPacket
+
+func (filter *GeneralBitstreamFilter) MediaType() astiav.MediaType {
+	return filter.describer.MediaType()
+}
+
+func (filter *GeneralBitstreamFilter) CodecID() astiav.CodecID {
+	return filter.codecParameters.CodecID()
+}
+
+func (filter *GeneralBitstreamFilter) GetCodecParameters() *astiav.CodecParameters {
+	return filter.codecParameters
+}
+
+func (filter *GeneralBitstreamFilter) FrameRate() astiav.Rational {
+	return filter.describer.FrameRate()
+}
+
+// TimeBase is the time base of the producer; none of the bitstream filters change it.
+func (filter *GeneralBitstreamFilter) TimeBase() astiav.Rational {
+	return filter.describer.TimeBase()
+}
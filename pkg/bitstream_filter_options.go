@@ -0,0 +1,33 @@
+package transcode
+
+import (
+	"github.com/asticode/go-astiav"
+
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+type BitstreamFilterOption = func(BitstreamFilter) error
+
+func WithBitstreamFilterBufferSize(size int) BitstreamFilterOption {
+	return func(filter BitstreamFilter) error {
+		s, ok := filter.(CanSetBuffer[astiav.Packet])
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.SetBuffer(buffer.CreateChannelBuffer(filter.Ctx(), size, internal.CreatePacketPool()))
+		return nil
+	}
+}
+
+// WithBitstreamFilterRepeatParameterSets sends the parameter sets in-band in front of every keyframe; what
+// WithRepeatParameterSets does for an encoder, for GeneralBitstreamFormatter.
+func WithBitstreamFilterRepeatParameterSets(filter BitstreamFilter) error {
+	s, ok := filter.(CanRepeatParameterSets)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetRepeatParameterSets(true)
+	return nil
+}
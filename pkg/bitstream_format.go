@@ -0,0 +1,235 @@
+package transcode
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/asticode/go-astiav"
+)
+
+// BitstreamFormat is how the NAL units of H.264 and HEVC packets are delimited. AV1 packets are the same in both.
+type BitstreamFormat uint8
+
+const (
+	// BitstreamFormatAnnexB delimits NAL units with start codes; what RTP packetizers and most WebRTC stacks expect.
+	BitstreamFormatAnnexB BitstreamFormat = iota
+	// BitstreamFormatLengthPrefixed prefixes NAL units with their 4 byte length (AVCC/HVCC); what MP4 and Matroska
+	// muxers and some WebRTC stacks expect. The extradata becomes an avcC or hvcC record.
+	BitstreamFormatLengthPrefixed
+)
+
+func (f BitstreamFormat) String() string {
+	switch f {
+	case BitstreamFormatAnnexB:
+		return "annexb"
+	case BitstreamFormatLengthPrefixed:
+		return "length-prefixed"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(f))
+	}
+}
+
+// bitstreamFilter is one initialised libavcodec bitstream filter; GeneralBitstreamFilter chains them.
+type bitstreamFilter struct {
+	ctx *astiav.BitStreamFilterContext
+}
+
+func newBitstreamFilter(name string, parameters *astiav.CodecParameters, timeBase astiav.Rational) (*bitstreamFilter, error) {
+	filter := astiav.FindBitStreamFilterByName(name)
+	if filter == nil {
+		return nil, fmt.Errorf("%w: %s", ErrorNoBitstreamFilter, name)
+	}
+
+	ctx, err := astiav.AllocBitStreamFilterContext(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parameters.Copy(ctx.InputCodecParameters()); err != nil {
+		ctx.Free()
+		return nil, err
+	}
+	ctx.SetInputTimeBase(timeBase)
+
+	if err := ctx.Initialize(); err != nil {
+		ctx.Free()
+		return nil, err
+	}
+
+	return &bitstreamFilter{ctx: ctx}, nil
+}
+
+// filter runs the packet through the filter in place. Only filters that put out a packet for every packet they take
+// in can be used this way, like the *_mp4toannexb filters.
+func (f *bitstreamFilter) filter(packet *astiav.Packet) error {
+	if err := f.ctx.SendPacket(packet); err != nil {
+		return err
+	}
+
+	return f.ctx.ReceivePacket(packet)
+}
+
+func (f *bitstreamFilter) free() {
+	f.ctx.Free()
+}
+
+// bitstreamFormatter rewrites the packets of an encoder, or of any packet producer through GeneralBitstreamFormatter,
+// into the requested bitstream format and, when asked to, repeats the parameter sets in front of every keyframe for
+// receivers that join mid-stream. The encoders are opened with a global header, so without repeating them the
+// parameter sets are only in the extradata.
+type bitstreamFormatter struct {
+	format   BitstreamFormat
+	repeat   bool
+	toAnnexB *bitstreamFilter // NOTE: ONLY FOR PRODUCERS PUTTING OUT LENGTH PREFIXED PACKETS
+}
+
+// newBitstreamFormatter returns nil if the packets of the producer can be used as they are. The extradata tells
+// whether the producer puts out Annex-B or length prefixed packets.
+func newBitstreamFormatter(parameters *astiav.CodecParameters, timeBase astiav.Rational, format BitstreamFormat, repeat bool) (*bitstreamFormatter, error) {
+	codecID := parameters.CodecID()
+
+	if codecID != astiav.CodecIDH264 && codecID != astiav.CodecIDHevc {
+		if format != BitstreamFormatAnnexB && !isAV1(codecID) {
+			return nil, fmt.Errorf("%w: %s with %s", ErrorBitstreamFormatNotSupported, format, codecID.Name())
+		}
+		if !repeat || !isAV1(codecID) {
+			return nil, nil
+		}
+		return &bitstreamFormatter{format: BitstreamFormatAnnexB, repeat: repeat}, nil
+	}
+
+	formatter := &bitstreamFormatter{format: format, repeat: repeat}
+
+	if extraData := parameters.ExtraData(); len(extraData) > 0 && !isAnnexB(extraData) {
+		// NOTE: avcC/hvcC EXTRADATA; THE PRODUCER PUTS OUT LENGTH PREFIXED PACKETS
+		name := "h264_mp4toannexb"
+		if codecID == astiav.CodecIDHevc {
+			name = "hevc_mp4toannexb"
+		}
+
+		toAnnexB, err := newBitstreamFilter(name, parameters, timeBase)
+		if err != nil {
+			return nil, err
+		}
+		formatter.toAnnexB = toAnnexB
+		return formatter, nil
+	}
+
+	if format == BitstreamFormatAnnexB && !repeat {
+		return nil, nil
+	}
+
+	return formatter, nil
+}
+
+// extraData returns the parameter sets as the extradata of the output format.
+func (f *bitstreamFormatter) extraData(sets ParameterSets) ([]byte, error) {
+	if isAV1(sets.CodecID) {
+		return sets.SequenceHeader, nil
+	}
+	if f.format == BitstreamFormatLengthPrefixed {
+		return sets.ConfigurationRecord()
+	}
+
+	return sets.AnnexB(), nil
+}
+
+// rewrite rewrites the packet in place.
+func (f *bitstreamFormatter) rewrite(packet *astiav.Packet, sets ParameterSets) error {
+	if f.toAnnexB != nil {
+		if err := f.toAnnexB.filter(packet); err != nil {
+			return err
+		}
+	}
+
+	if f.format == BitstreamFormatAnnexB && !f.repeat {
+		return nil
+	}
+
+	data := packet.Data()
+	if f.repeat && packet.Flags().Has(astiav.PacketFlagKey) {
+		data = sets.inBand(data)
+	}
+	if f.format == BitstreamFormatLengthPrefixed {
+		data = annexBToLengthPrefixed(data)
+	}
+
+	return setPacketData(packet, data)
+}
+
+// inBand returns the keyframe data, already in the output format, with the parameter sets in front of it.
+func (f *bitstreamFormatter) inBand(data []byte, sets ParameterSets) []byte {
+	if f.format == BitstreamFormatLengthPrefixed {
+		return sets.lengthPrefixedInBand(data)
+	}
+
+	return sets.inBand(data)
+}
+
+func (f *bitstreamFormatter) free() {
+	if f.toAnnexB != nil {
+		f.toAnnexB.free()
+	}
+}
+
+// setPacketData replaces the data of the packet, keeping its properties.
+func setPacketData(packet *astiav.Packet, data []byte) error {
+	properties := astiav.AllocPacket()
+	defer properties.Free()
+
+	if err := properties.CopyProperties(packet); err != nil {
+		return err
+	}
+
+	packet.Unref()
+	if err := packet.FromData(data); err != nil {
+		return err
+	}
+
+	return packet.CopyProperties(properties)
+}
+
+// annexBToLengthPrefixed rewrites an Annex-B byte stream into NAL units prefixed by their 4 byte length. Data that is
+// not Annex-B is returned as it is.
+func annexBToLengthPrefixed(data []byte) []byte {
+	if !isAnnexB(data) {
+		return data
+	}
+
+	return lengthPrefixed(splitAnnexB(data))
+}
+
+func lengthPrefixed(nalus [][]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+
+	data := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		data = append(binary.BigEndian.AppendUint32(data, uint32(len(nalu))), nalu...)
+	}
+
+	return data
+}
+
+// splitLengthPrefixed returns the NAL units of data with 4 byte length prefixes, without their prefixes.
+func splitLengthPrefixed(data []byte) ([][]byte, error) {
+	var nalus [][]byte
+
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errorBitstreamTooShort
+		}
+
+		length := binary.BigEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(length) {
+			return nil, errorBitstreamTooShort
+		}
+
+		nalus = append(nalus, data[4:4+length])
+		data = data[4+length:]
+	}
+
+	return nalus, nil
+}
@@ -0,0 +1,54 @@
+package transcode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAnnexBToLengthPrefixed(t *testing.T) {
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{
+			name: "4 byte start codes",
+			data: []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x68, 0xCE},
+			want: []byte{0, 0, 0, 2, 0x67, 0x42, 0, 0, 0, 2, 0x68, 0xCE},
+		},
+		{
+			name: "3 byte start codes",
+			data: append([]byte{0, 0, 1, 0x09, 0xF0, 0, 0, 1}, idr...),
+			want: append([]byte{0, 0, 0, 2, 0x09, 0xF0, 0, 0, 0, 4}, idr...),
+		},
+		{
+			name: "single nal unit",
+			data: append([]byte{0, 0, 0, 1}, idr...),
+			want: append([]byte{0, 0, 0, 4}, idr...),
+		},
+		{
+			name: "already length prefixed",
+			data: append([]byte{0, 0, 0, 4}, idr...),
+			want: append([]byte{0, 0, 0, 4}, idr...),
+		},
+		{name: "empty", data: nil, want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := annexBToLengthPrefixed(test.data)
+			if !bytes.Equal(got, test.want) {
+				t.Fatalf("got %x, want %x", got, test.want)
+			}
+
+			if len(got) == 0 {
+				return
+			}
+			if _, err := splitLengthPrefixed(got); err != nil {
+				t.Fatalf("Failed to split the result: %v", err)
+			}
+		})
+	}
+}
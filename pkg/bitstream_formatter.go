@@ -0,0 +1,262 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/asticode/go-astiav"
+
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+// GeneralBitstreamFormatter rewrites the packets of any packet producer (a demuxer, a passthrough transcoder, a
+// bitstream filter) into the requested bitstream format and, with WithBitstreamFilterRepeatParameterSets, repeats the
+// parameter sets in front of every keyframe. It is what WithBitstreamFormat and WithRepeatParameterSets do for an
+// encoder, as a stage of its own. The parameter sets are taken from the extradata of the producer, and replaced by the
+// ones in new extradata side data of its packets.
+type GeneralBitstreamFormatter struct {
+	producer        CanProduceMediaPacket
+	describer       CanDescribeMediaPacket
+	format          BitstreamFormat
+	repeat          bool
+	formatter       *bitstreamFormatter // NOTE: NIL IF THE PACKETS OF THE PRODUCER ARE ALREADY IN THE FORMAT
+	parameterSets   ParameterSets       // NOTE: SET ONCE WHEN THE FORMATTER IS CREATED
+	current         ParameterSets       // NOTE: ONLY USED BY THE LOOP
+	codecParameters *astiav.CodecParameters
+	buffer          buffer.BufferWithGenerator[astiav.Packet]
+	eos             *endOfStream[astiav.Packet]
+	ctx             context.Context
+	cancel          context.CancelFunc
+}
+
+func CreateGeneralBitstreamFormatter(ctx context.Context, canProduceMediaPacket CanProduceMediaPacket, format BitstreamFormat, options ...BitstreamFilterOption) (*GeneralBitstreamFormatter, error) {
+	describer, ok := canProduceMediaPacket.(CanDescribeMediaPacket)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	formatter := &GeneralBitstreamFormatter{
+		producer:        canProduceMediaPacket,
+		describer:       describer,
+		format:          format,
+		codecParameters: astiav.AllocCodecParameters(),
+		eos:             newEndOfStream[astiav.Packet](),
+		ctx:             ctx2,
+		cancel:          cancel,
+	}
+
+	for _, option := range options {
+		if err := option(formatter); err != nil {
+			return formatter.fail(err)
+		}
+	}
+
+	if err := formatter.init(); err != nil {
+		return formatter.fail(err)
+	}
+
+	if formatter.buffer == nil {
+		formatter.buffer = buffer.CreateChannelBuffer(ctx2, 256, internal.CreatePacketPool())
+	}
+
+	return formatter, nil
+}
+
+// init sets up the conversion from the codec parameters of the producer, and gives the formatter the extradata of
+// the output format.
+func (formatter *GeneralBitstreamFormatter) init() error {
+	parameters := formatter.describer.GetCodecParameters()
+	if err := parameters.Copy(formatter.codecParameters); err != nil {
+		return err
+	}
+
+	sets, err := parseParameterSets(parameters.CodecID(), parameters.ExtraData())
+	if err != nil {
+		return err
+	}
+	formatter.parameterSets = sets
+	formatter.current = sets
+
+	f, err := newBitstreamFormatter(parameters, formatter.describer.TimeBase(), formatter.format, formatter.repeat)
+	if err != nil || f == nil {
+		return err
+	}
+	formatter.formatter = f
+
+	if sets.Empty() {
+		return nil
+	}
+
+	extraData, err := f.extraData(sets)
+	if err != nil {
+		return err
+	}
+
+	return formatter.codecParameters.SetExtraData(extraData)
+}
+
+// fail frees what CreateGeneralBitstreamFormatter allocated so far.
+func (formatter *GeneralBitstreamFormatter) fail(err error) (*GeneralBitstreamFormatter, error) {
+	formatter.close()
+	formatter.cancel()
+	return nil, err
+}
+
+func (formatter *GeneralBitstreamFormatter) Ctx() context.Context {
+	return formatter.ctx
+}
+
+func (formatter *GeneralBitstreamFormatter) Start() {
+	go formatter.loop()
+}
+
+func (formatter *GeneralBitstreamFormatter) Stop() {
+	formatter.cancel()
+}
+
+func (formatter *GeneralBitstreamFormatter) SetRepeatParameterSets(repeat bool) {
+	formatter.repeat = repeat
+}
+
+func (formatter *GeneralBitstreamFormatter) loop() {
+	defer formatter.close()
+
+	for {
+		select {
+		case <-formatter.ctx.Done():
+			return
+		default:
+			packet, err := formatter.getPacket()
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					if err := formatter.eos.push(formatter.ctx, formatter.buffer); err != nil {
+						return
+					}
+					<-formatter.ctx.Done()
+					return
+				}
+				continue
+			}
+
+			out, err := formatter.rewrite(packet)
+			formatter.producer.PutBack(packet)
+			if err != nil {
+				continue
+			}
+
+			if err := formatter.pushPacket(out); err != nil {
+				formatter.buffer.PutBack(out)
+			}
+		}
+	}
+}
+
+// rewrite returns a copy of the packet in the output format; the producer keeps its own packet.
+func (formatter *GeneralBitstreamFormatter) rewrite(packet *astiav.Packet) (*astiav.Packet, error) {
+	out := formatter.buffer.Generate()
+	if err := out.Ref(packet); err != nil {
+		formatter.buffer.PutBack(out)
+		return nil, err
+	}
+
+	if formatter.formatter == nil {
+		return out, nil
+	}
+
+	formatter.updateParameterSets(packet)
+
+	if err := formatter.formatter.rewrite(out, formatter.current); err != nil {
+		formatter.buffer.PutBack(out)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// updateParameterSets takes the parameter sets from new extradata side data, like the one MultiUpdateEncoder adds
+// when it switches to an encoder with other parameter sets.
+func (formatter *GeneralBitstreamFormatter) updateParameterSets(packet *astiav.Packet) {
+	extraData := packet.SideData().Get(astiav.PacketSideDataTypeNewExtradata)
+	if len(extraData) == 0 {
+		return
+	}
+
+	sets, err := parseParameterSets(formatter.codecParameters.CodecID(), extraData)
+	if err != nil || sets.Empty() {
+		return
+	}
+	formatter.current = sets
+}
+
+func (formatter *GeneralBitstreamFormatter) getPacket() (*astiav.Packet, error) {
+	ctx, cancel := context.WithTimeout(formatter.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return formatter.producer.GetPacket(ctx)
+}
+
+func (formatter *GeneralBitstreamFormatter) pushPacket(packet *astiav.Packet) error {
+	ctx, cancel := context.WithTimeout(formatter.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return formatter.buffer.Push(ctx, packet)
+}
+
+func (formatter *GeneralBitstreamFormatter) GetPacket(ctx context.Context) (*astiav.Packet, error) {
+	return formatter.eos.pop(ctx, formatter.buffer)
+}
+
+func (formatter *GeneralBitstreamFormatter) PutBack(packet *astiav.Packet) {
+	formatter.buffer.PutBack(packet)
+}
+
+func (formatter *GeneralBitstreamFormatter) SetBuffer(buffer buffer.BufferWithGenerator[astiav.Packet]) {
+	formatter.buffer = buffer
+}
+
+func (formatter *GeneralBitstreamFormatter) close() {
+	if formatter.formatter != nil {
+		formatter.formatter.free()
+	}
+
+	if formatter.codecParameters != nil {
+		formatter.codecParameters.Free()
+	}
+}
+
+// GetParameterSets returns the first SPS and PPS of the producer, each behind a start code.
+func (formatter *GeneralBitstreamFormatter) GetParameterSets() ([]byte, []byte, error) {
+	return annexBFirst(formatter.parameterSets.SPS), annexBFirst(formatter.parameterSets.PPS), nil
+}
+
+// GetCodecParameterSets returns the parameter sets of the producer, as parsed when the formatter was created.
+func (formatter *GeneralBitstreamFormatter) GetCodecParameterSets() (ParameterSets, error) {
+	return formatter.parameterSets, nil
+}
+
+// ## CanDescribeMediaPacket
+
+func (formatter *GeneralBitstreamFormatter) MediaType() astiav.MediaType {
+	return formatter.describer.MediaType()
+}
+
+func (formatter *GeneralBitstreamFormatter) CodecID() astiav.CodecID {
+	return formatter.codecParameters.CodecID()
+}
+
+func (formatter *GeneralBitstreamFormatter) GetCodecParameters() *astiav.CodecParameters {
+	return formatter.codecParameters
+}
+
+func (formatter *GeneralBitstreamFormatter) FrameRate() astiav.Rational {
+	return formatter.describer.FrameRate()
+}
+
+// TimeBase is the time base of the producer; rewriting the bitstream does not change it.
+func (formatter *GeneralBitstreamFormatter) TimeBase() astiav.Rational {
+	return formatter.describer.TimeBase()
+}
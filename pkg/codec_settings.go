@@ -0,0 +1,253 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// codecSettingsTag is the struct tag the codec settings types describe their options with:
+//
+//	codec:"name"               the AVOption name, private to the codec (preset, x264opts) or generic (b, g, qmin)
+//	codec:"name,omitempty"     the option is left out while the field is its zero value
+//	codec:"name,opts=x264opts" the option is joined as name=value into the colon separated option x264opts
+//	codec:"name,suffix=k"      appended to the value; for fields in kbps of options in bits per second
+//	codec:"name,unit=ms"       the unit time.Duration fields are written in: us, ms (the default) or s
+//
+// Fields can be strings, bools (written as 1 or 0), integers, floats or time.Durations. Embedded structs and struct
+// pointers are walked as if their fields were part of the outer struct; nil ones are skipped.
+const codecSettingsTag = "codec"
+
+type codecTag struct {
+	name      string
+	opts      string
+	suffix    string
+	unit      string
+	omitEmpty bool
+}
+
+func parseCodecTag(tag string) codecTag {
+	parts := strings.Split(tag, ",")
+	parsed := codecTag{name: parts[0], unit: "ms"}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "omitempty":
+			parsed.omitEmpty = true
+		case "opts":
+			parsed.opts = value
+		case "suffix":
+			parsed.suffix = value
+		case "unit":
+			parsed.unit = value
+		}
+	}
+
+	return parsed
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func formatCodecValue(value reflect.Value, tag codecTag) (string, error) {
+	if value.Type() == durationType {
+		duration := time.Duration(value.Int())
+		switch tag.unit {
+		case "us":
+			return strconv.FormatInt(duration.Microseconds(), 10), nil
+		case "ms":
+			return strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', -1, 64), nil
+		case "s":
+			return strconv.FormatFloat(duration.Seconds(), 'f', -1, 64), nil
+		default:
+			return "", fmt.Errorf("unknown duration unit '%s' of codec option '%s'", tag.unit, tag.name)
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		if value.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported type %s of codec option '%s'", value.Type(), tag.name)
+	}
+}
+
+// forEachCodecOption calls fn with every option described by the codec tags of settings, a struct or a pointer to
+// one. Options joined into an opts string are passed last, once per opts string.
+func forEachCodecOption(settings any, fn func(key, value string) error) error {
+	var (
+		order  []string
+		joined = make(map[string][]string)
+	)
+
+	err := walkCodecOptions(reflect.ValueOf(settings), func(tag codecTag, value string) error {
+		value += tag.suffix
+		if tag.opts == "" {
+			return fn(tag.name, value)
+		}
+
+		if _, ok := joined[tag.opts]; !ok {
+			order = append(order, tag.opts)
+		}
+		joined[tag.opts] = append(joined[tag.opts], tag.name+"="+value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, opts := range order {
+		if err := fn(opts, strings.Join(joined[opts], ":")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkCodecOptions(v reflect.Value, fn func(tag codecTag, value string) error) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("codec settings need to be a struct, got %s", v.Type())
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		raw, ok := field.Tag.Lookup(codecSettingsTag)
+		if !ok {
+			if field.Anonymous {
+				if err := walkCodecOptions(v.Field(i), fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		tag := parseCodecTag(raw)
+		if tag.omitEmpty && v.Field(i).IsZero() {
+			continue
+		}
+
+		value, err := formatCodecValue(v.Field(i), tag)
+		if err != nil {
+			return err
+		}
+		if err := fn(tag, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateCodecOptions checks the options against the private AVOptions of the codec before it is opened. Options
+// the codec does not know may still be generic AVCodecContext options; those are checked by unusedCodecOptions.
+func validateCodecOptions(eCtx *astiav.CodecContext, options *astiav.Dictionary) error {
+	private := eCtx.PrivateData()
+	if private == nil || private.Options() == nil {
+		return nil
+	}
+
+	var invalid []string
+	flags := astiav.NewDictionaryFlags(astiav.DictionaryFlagIgnoreSuffix)
+
+	for entry := options.Get("", nil, flags); entry != nil; entry = options.Get("", entry, flags) {
+		err := private.Options().Set(entry.Key(), entry.Value(), astiav.NewOptionSearchFlags())
+		if err != nil && !errors.Is(err, astiav.ErrOptionNotFound) {
+			invalid = append(invalid, fmt.Sprintf("%s=%s (%s)", entry.Key(), entry.Value(), err.Error()))
+		}
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("%w: %s", ErrorInvalidCodecOption, strings.Join(invalid, ", "))
+	}
+
+	return nil
+}
+
+// unusedCodecOptions reports the options left in the dictionary after the codec was opened. avcodec_open2 takes out
+// every option it applied, so whatever is left is unknown to both the codec and AVCodecContext.
+func unusedCodecOptions(options *astiav.Dictionary) error {
+	var unknown []string
+	flags := astiav.NewDictionaryFlags(astiav.DictionaryFlagIgnoreSuffix)
+
+	for entry := options.Get("", nil, flags); entry != nil; entry = options.Get("", entry, flags) {
+		unknown = append(unknown, entry.Key())
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrorUnknownCodecOption, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// cloneCodecSettings returns a deep copy of settings, so the copy can be changed without affecting anyone holding the
+// original. Settings that are pointers to structs are copied field by field, following struct pointers (e.g. the
+// embedded *X264AdvancedOptions of X264Options); other settings cannot be copied and are returned as they are.
+func cloneCodecSettings(settings codecSettings) codecSettings {
+	v := reflect.ValueOf(settings)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return settings
+	}
+
+	clone, ok := cloneStructPointer(v).Interface().(codecSettings)
+	if !ok {
+		return settings
+	}
+
+	return clone
+}
+
+func cloneStructPointer(v reflect.Value) reflect.Value {
+	clone := reflect.New(v.Elem().Type())
+	clone.Elem().Set(v.Elem())
+
+	for i := 0; i < clone.Elem().NumField(); i++ {
+		field := clone.Elem().Field(i)
+		if !field.CanSet() || field.Kind() != reflect.Pointer || field.IsNil() || field.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		field.Set(cloneStructPointer(field))
+	}
+
+	return clone
+}
+
+// withUpdatedBitrate returns a copy of settings with the bitrate changed; settings itself is left alone.
+func withUpdatedBitrate(settings codecSettings, bps int64) (codecSettings, error) {
+	updated := cloneCodecSettings(settings)
+
+	u, ok := updated.(CanUpdateBitrate)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+	if err := u.UpdateBitrate(bps); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
@@ -17,6 +17,7 @@ type GeneralDecoder struct {
 	decoderContext *astiav.CodecContext
 	codec          *astiav.Codec
 	buffer         buffer.BufferWithGenerator[astiav.Frame]
+	eos            *endOfStream[astiav.Frame]
 	ctx            context.Context
 	cancel         context.CancelFunc
 }
@@ -31,6 +32,7 @@ func CreateGeneralDecoder(ctx context.Context, canProduceMediaType CanProduceMed
 	ctx2, cancel := context.WithCancel(ctx)
 	decoder = &GeneralDecoder{
 		demuxer: canProduceMediaType,
+		eos:     newEndOfStream[astiav.Frame](),
 		ctx:     ctx2,
 		cancel:  cancel,
 	}
@@ -89,6 +91,10 @@ loop1:
 		default:
 			packet, err := decoder.getPacket()
 			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					decoder.drain()
+					return
+				}
 				// fmt.Println("unable to get packet from demuxer; err:", err.Error())
 				continue
 			}
@@ -118,6 +124,33 @@ loop1:
 	}
 }
 
+// drain flushes the frames buffered inside the decoder and signals the end of stream downstream. It then waits for
+// the decoder to be stopped, so the decoder stays describable until then.
+func (decoder *GeneralDecoder) drain() {
+	if err := decoder.decoderContext.SendPacket(nil); err == nil {
+		for {
+			frame := decoder.buffer.Generate()
+			if err := decoder.decoderContext.ReceiveFrame(frame); err != nil {
+				decoder.buffer.PutBack(frame)
+				break
+			}
+
+			frame.SetPictureType(astiav.PictureTypeNone)
+
+			if err := decoder.buffer.Push(decoder.ctx, frame); err != nil {
+				decoder.buffer.PutBack(frame)
+				return
+			}
+		}
+	}
+
+	if err := decoder.eos.push(decoder.ctx, decoder.buffer); err != nil {
+		return
+	}
+
+	<-decoder.ctx.Done()
+}
+
 func (decoder *GeneralDecoder) pushFrame(frame *astiav.Frame) error {
 	ctx, cancel := context.WithTimeout(decoder.ctx, 50*time.Millisecond)
 	defer cancel()
@@ -133,7 +166,7 @@ func (decoder *GeneralDecoder) getPacket() (*astiav.Packet, error) {
 }
 
 func (decoder *GeneralDecoder) GetFrame(ctx context.Context) (*astiav.Frame, error) {
-	return decoder.buffer.Pop(ctx)
+	return decoder.eos.pop(ctx, decoder.buffer)
 }
 
 func (decoder *GeneralDecoder) PutBack(frame *astiav.Frame) {
@@ -2,7 +2,8 @@ package transcode
 
 import (
 	"context"
-	"time"
+	"errors"
+	"sync"
 
 	"github.com/asticode/go-astiav"
 
@@ -11,24 +12,38 @@ import (
 )
 
 type GeneralDemuxer struct {
-	formatContext   *astiav.FormatContext
-	inputOptions    *astiav.Dictionary
-	inputFormat     *astiav.InputFormat
-	stream          *astiav.Stream
-	codecParameters *astiav.CodecParameters
-	buffer          buffer.BufferWithGenerator[astiav.Packet]
-	ctx             context.Context
-	cancel          context.CancelFunc
+	formatContext      *astiav.FormatContext
+	containerAddress   string
+	inputOptions       *astiav.Dictionary
+	packedInputOptions []byte
+	inputFormat        *astiav.InputFormat
+	selectors          []StreamSelector
+	primary            *DemuxerStream
+	streams            []*DemuxerStream
+	streamIndex        map[int]*DemuxerStream
+	reconnect          *ReconnectConfig
+	events             chan ReconnectEvent
+	dropWhenFull       bool
+	onError            func(error)
+	buffer             buffer.BufferWithGenerator[astiav.Packet]
+	mux                sync.RWMutex
+	ctx                context.Context
+	cancel             context.CancelFunc
 }
 
 func CreateGeneralDemuxer(ctx context.Context, containerAddress string, options ...DemuxerOption) (*GeneralDemuxer, error) {
 	ctx2, cancel := context.WithCancel(ctx)
 	astiav.RegisterAllDevices()
 	demuxer := &GeneralDemuxer{
-		formatContext: astiav.AllocFormatContext(),
-		inputOptions:  astiav.NewDictionary(),
-		ctx:           ctx2,
-		cancel:        cancel,
+		formatContext:    astiav.AllocFormatContext(),
+		containerAddress: containerAddress,
+		inputOptions:     astiav.NewDictionary(),
+		selectors:        make([]StreamSelector, 0),
+		streams:          make([]*DemuxerStream, 0),
+		streamIndex:      make(map[int]*DemuxerStream),
+		events:           make(chan ReconnectEvent, 16),
+		ctx:              ctx2,
+		cancel:           cancel,
 	}
 
 	if demuxer.formatContext == nil {
@@ -45,6 +60,9 @@ func CreateGeneralDemuxer(ctx context.Context, containerAddress string, options
 		}
 	}
 
+	// NOTE: OpenInput CONSUMES THE RECOGNISED OPTIONS; KEEP A COPY FOR RECONNECTS
+	demuxer.packedInputOptions = demuxer.inputOptions.Pack()
+
 	if err := demuxer.formatContext.OpenInput(containerAddress, demuxer.inputFormat, demuxer.inputOptions); err != nil {
 		return nil, err
 	}
@@ -53,21 +71,59 @@ func CreateGeneralDemuxer(ctx context.Context, containerAddress string, options
 		return nil, ErrorNoStreamFound
 	}
 
-	for _, stream := range demuxer.formatContext.Streams() {
-		demuxer.stream = stream
-		break
+	if demuxer.buffer == nil {
+		demuxer.buffer = buffer.CreateChannelBuffer(ctx, 256, internal.CreatePacketPool())
 	}
 
-	if demuxer.stream == nil {
-		return nil, ErrorNoVideoStreamFound
+	if err := demuxer.selectStreams(); err != nil {
+		return nil, err
 	}
-	demuxer.codecParameters = demuxer.stream.CodecParameters()
 
-	if demuxer.buffer == nil {
-		demuxer.buffer = buffer.CreateChannelBuffer(ctx, 256, internal.CreatePacketPool())
+	return demuxer, nil
+}
+
+// selectStreams resolves the stream selectors against the opened input. Without any selector, only the first stream
+// is selected. The first selected stream is the primary stream, which is what GeneralDemuxer itself produces and
+// describes; it uses the demuxer buffer while every other selected stream gets its own buffer.
+func (demuxer *GeneralDemuxer) selectStreams() error {
+	selectors := demuxer.selectors
+	if len(selectors) == 0 {
+		selectors = []StreamSelector{selectFirstStream}
 	}
 
-	return demuxer, nil
+	for _, selector := range selectors {
+		streams, err := selector(demuxer.formatContext)
+		if err != nil {
+			return err
+		}
+
+		for _, stream := range streams {
+			if _, exists := demuxer.streamIndex[stream.Index()]; exists {
+				continue
+			}
+
+			b := demuxer.buffer
+			if demuxer.primary != nil {
+				b = buffer.CreateChannelBuffer(demuxer.ctx, 256, internal.CreatePacketPool())
+			}
+
+			s, err := newDemuxerStream(demuxer, stream, b)
+			if err != nil {
+				return err
+			}
+			if demuxer.primary == nil {
+				demuxer.primary = s
+			}
+			demuxer.streams = append(demuxer.streams, s)
+			demuxer.streamIndex[stream.Index()] = s
+		}
+	}
+
+	if demuxer.primary == nil {
+		return ErrorNoStreamFound
+	}
+
+	return nil
 }
 
 func (demuxer *GeneralDemuxer) Ctx() context.Context {
@@ -85,52 +141,100 @@ func (demuxer *GeneralDemuxer) Stop() {
 func (demuxer *GeneralDemuxer) loop() {
 	defer demuxer.close()
 
-loop1:
 	for {
 		select {
 		case <-demuxer.ctx.Done():
 			return
 		default:
-		loop2:
-			for {
-				packet := demuxer.buffer.Generate()
+			packet := demuxer.buffer.Generate()
 
-				if err := demuxer.formatContext.ReadFrame(packet); err != nil {
-					demuxer.buffer.PutBack(packet)
-					continue loop1
+			if err := demuxer.formatContext.ReadFrame(packet); err != nil {
+				demuxer.buffer.PutBack(packet)
+				if errors.Is(err, astiav.ErrEof) && demuxer.reconnect == nil {
+					demuxer.endOfStream()
+					return
 				}
-
-				if packet.StreamIndex() != demuxer.stream.Index() {
-					demuxer.buffer.PutBack(packet)
-					continue loop2
+				if demuxer.reconnect == nil || errors.Is(err, astiav.ErrEagain) {
+					continue
 				}
-
-				if err := demuxer.pushPacket(packet); err != nil {
-					demuxer.buffer.PutBack(packet)
-					continue loop1
+				if err := demuxer.reconnectInput(err); err != nil {
+					// NOTE: THE INPUT IS GONE FOR GOOD; LET THE CONSUMERS DRAIN WHAT THEY HAVE
+					demuxer.endOfStream()
+					return
 				}
-				break loop2
+				continue
+			}
+
+			stream, ok := demuxer.streamIndex[packet.StreamIndex()]
+			if !ok {
+				demuxer.buffer.PutBack(packet)
+				continue
+			}
+
+			stream.rebase(packet)
+			if err := stream.pushPacket(demuxer.ctx, packet); err != nil {
+				stream.PutBack(packet)
+				continue
 			}
 		}
 	}
 }
 
-func (demuxer *GeneralDemuxer) pushPacket(packet *astiav.Packet) error {
-	ctx, cancel := context.WithTimeout(demuxer.ctx, 50*time.Millisecond) // TODO: NEEDS TO BE BASED ON FPS ON INPUT_FORMAT
-	defer cancel()
+// endOfStream signals the end of the input to every selected stream and then waits for the demuxer to be stopped,
+// so the streams stay describable until then. The markers are pushed side by side; a stream whose consumer has stopped
+// reading does not keep the end of stream from the others.
+func (demuxer *GeneralDemuxer) endOfStream() {
+	var wg sync.WaitGroup
+	for _, stream := range demuxer.streams {
+		wg.Add(1)
+		go func(stream *DemuxerStream) {
+			defer wg.Done()
+			_ = stream.eos.push(demuxer.ctx, stream.buffer)
+		}(stream)
+	}
+	wg.Wait()
+
+	<-demuxer.ctx.Done()
+}
+
+// Streams returns the packet producers for all the selected streams in selection order.
+func (demuxer *GeneralDemuxer) Streams() []*DemuxerStream {
+	return demuxer.streams
+}
+
+// GetStream returns the first selected stream of the given media type.
+func (demuxer *GeneralDemuxer) GetStream(mediaType astiav.MediaType) (*DemuxerStream, error) {
+	for _, stream := range demuxer.streams {
+		if stream.MediaType() == mediaType {
+			return stream, nil
+		}
+	}
+
+	return nil, ErrorNoStreamFound
+}
+
+// GetStreamByIndex returns the selected stream with the given container stream index.
+func (demuxer *GeneralDemuxer) GetStreamByIndex(index int) (*DemuxerStream, error) {
+	stream, ok := demuxer.streamIndex[index]
+	if !ok {
+		return nil, ErrorNoStreamFound
+	}
 
-	return demuxer.buffer.Push(ctx, packet)
+	return stream, nil
 }
 
 func (demuxer *GeneralDemuxer) GetPacket(ctx context.Context) (*astiav.Packet, error) {
-	return demuxer.buffer.Pop(ctx)
+	return demuxer.primary.GetPacket(ctx)
 }
 
 func (demuxer *GeneralDemuxer) PutBack(packet *astiav.Packet) {
-	demuxer.buffer.PutBack(packet)
+	demuxer.primary.PutBack(packet)
 }
 
 func (demuxer *GeneralDemuxer) close() {
+	for _, stream := range demuxer.streams {
+		stream.free()
+	}
 	if demuxer.formatContext != nil {
 		demuxer.formatContext.CloseInput()
 		demuxer.formatContext.Free()
@@ -149,22 +253,42 @@ func (demuxer *GeneralDemuxer) SetBuffer(buffer buffer.BufferWithGenerator[astia
 	demuxer.buffer = buffer
 }
 
+// SetDropWhenFull makes the streams drop packets their consumer has no room for, instead of holding back the read loop.
+func (demuxer *GeneralDemuxer) SetDropWhenFull(drop bool) {
+	demuxer.dropWhenFull = drop
+}
+
+func (demuxer *GeneralDemuxer) SetErrorHandler(handler func(error)) {
+	demuxer.onError = handler
+}
+
+// reportError passes an error of the read loop to the handler of WithDemuxerErrorHandler, if there is one.
+func (demuxer *GeneralDemuxer) reportError(err error) {
+	if demuxer.onError != nil {
+		demuxer.onError(err)
+	}
+}
+
+func (demuxer *GeneralDemuxer) AddStreamSelector(selector StreamSelector) {
+	demuxer.selectors = append(demuxer.selectors, selector)
+}
+
 func (demuxer *GeneralDemuxer) GetCodecParameters() *astiav.CodecParameters {
-	return demuxer.codecParameters
+	return demuxer.primary.GetCodecParameters()
 }
 
 func (demuxer *GeneralDemuxer) MediaType() astiav.MediaType {
-	return demuxer.codecParameters.MediaType()
+	return demuxer.primary.MediaType()
 }
 
 func (demuxer *GeneralDemuxer) CodecID() astiav.CodecID {
-	return demuxer.codecParameters.CodecID()
+	return demuxer.primary.CodecID()
 }
 
 func (demuxer *GeneralDemuxer) FrameRate() astiav.Rational {
-	return demuxer.formatContext.GuessFrameRate(demuxer.stream, nil)
+	return demuxer.primary.FrameRate()
 }
 
 func (demuxer *GeneralDemuxer) TimeBase() astiav.Rational {
-	return demuxer.stream.TimeBase()
+	return demuxer.primary.TimeBase()
 }
@@ -10,6 +10,7 @@ import (
 
 type DemuxerOption = func(demuxer Demuxer) error
 
+// WithRTSPInputOption sets low latency RTSP over TCP. It does not reconnect; add WithReconnectOption for that.
 func WithRTSPInputOption(demuxer Demuxer) error {
 	s, ok := demuxer.(CanSetDemuxerInputOption)
 	if !ok {
@@ -34,6 +35,46 @@ func WithRTSPInputOption(demuxer Demuxer) error {
 	return nil
 }
 
+// WithReconnectOption reopens the input with the same options and format when reading from it fails, backing off
+// between attempts as configured, e.g. WithReconnectOption(DefaultReconnectConfig). The timestamps of the reopened input
+// continue from the last packet before the disconnect. Reconnect progress is published on GeneralDemuxer.ReconnectEvents.
+func WithReconnectOption(config ReconnectConfig) DemuxerOption {
+	return func(demuxer Demuxer) error {
+		s, ok := demuxer.(CanSetDemuxerReconnect)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		return s.SetReconnectConfig(config)
+	}
+}
+
+// WithLiveInputDropOption is meant for live inputs, where holding back the read loop only lets the source fall
+// behind. A stream whose consumer has no room for a packet drops it, and every packet after it up to the next keyframe,
+// instead of waiting. Each dropped packet is reported as an ErrorStreamBufferFull to the handler of
+// WithDemuxerErrorHandler. Without this option the demuxer waits for its consumers, so a file is demuxed completely
+// however slow they are.
+func WithLiveInputDropOption(demuxer Demuxer) error {
+	s, ok := demuxer.(CanSetDemuxerDropWhenFull)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetDropWhenFull(true)
+	return nil
+}
+
+// WithDemuxerErrorHandler receives the errors of the read loop no caller can get to, like the packets dropped with
+// WithLiveInputDropOption. The handler is called from the read loop and should not block.
+func WithDemuxerErrorHandler(handler func(error)) DemuxerOption {
+	return func(demuxer Demuxer) error {
+		s, ok := demuxer.(CanSetErrorHandler)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.SetErrorHandler(handler)
+		return nil
+	}
+}
+
 func WithFileInputOption(demuxer Demuxer) error {
 	s, ok := demuxer.(CanSetDemuxerInputOption)
 	if !ok {
@@ -96,3 +137,71 @@ func WithDemuxerBufferSize(size int) DemuxerOption {
 		return nil
 	}
 }
+
+func selectFirstStream(formatContext *astiav.FormatContext) ([]*astiav.Stream, error) {
+	streams := formatContext.Streams()
+	if len(streams) == 0 {
+		return nil, ErrorNoStreamFound
+	}
+	return streams[:1], nil
+}
+
+// WithBestStreamOption selects the stream FFmpeg considers the best of the given media type.
+func WithBestStreamOption(mediaType astiav.MediaType) DemuxerOption {
+	return func(demuxer Demuxer) error {
+		s, ok := demuxer.(CanSelectDemuxerStream)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.AddStreamSelector(func(formatContext *astiav.FormatContext) ([]*astiav.Stream, error) {
+			stream, _, err := formatContext.FindBestStream(mediaType, -1, -1)
+			if err != nil {
+				return nil, ErrorNoStreamFound
+			}
+			return []*astiav.Stream{stream}, nil
+		})
+		return nil
+	}
+}
+
+// WithStreamIndexOption selects the stream with the given container stream index.
+func WithStreamIndexOption(index int) DemuxerOption {
+	return func(demuxer Demuxer) error {
+		s, ok := demuxer.(CanSelectDemuxerStream)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.AddStreamSelector(func(formatContext *astiav.FormatContext) ([]*astiav.Stream, error) {
+			for _, stream := range formatContext.Streams() {
+				if stream.Index() == index {
+					return []*astiav.Stream{stream}, nil
+				}
+			}
+			return nil, ErrorNoStreamFound
+		})
+		return nil
+	}
+}
+
+// WithMediaTypeStreamsOption selects every stream of the given media type.
+func WithMediaTypeStreamsOption(mediaType astiav.MediaType) DemuxerOption {
+	return func(demuxer Demuxer) error {
+		s, ok := demuxer.(CanSelectDemuxerStream)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.AddStreamSelector(func(formatContext *astiav.FormatContext) ([]*astiav.Stream, error) {
+			streams := make([]*astiav.Stream, 0)
+			for _, stream := range formatContext.Streams() {
+				if stream.CodecParameters().MediaType() == mediaType {
+					streams = append(streams, stream)
+				}
+			}
+			if len(streams) == 0 {
+				return nil, ErrorNoStreamFound
+			}
+			return streams, nil
+		})
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+type ReconnectConfig struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	MaxAttempts    int // 0 RETRIES FOREVER
+}
+
+var DefaultReconnectConfig = ReconnectConfig{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	MaxAttempts:    0,
+}
+
+func (c ReconnectConfig) validate() error {
+	if c.InitialBackoff <= 0 {
+		return fmt.Errorf("initial backoff needs to be more than 0")
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("maximum backoff is lower than initial backoff in the reconnect config")
+	}
+	if c.Multiplier < 1 {
+		return fmt.Errorf("backoff multiplier needs to be at least 1")
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("maximum attempts cannot be negative")
+	}
+	return nil
+}
+
+// backoff returns the delay before the given (1-based) reconnect attempt.
+func (c ReconnectConfig) backoff(attempt int) time.Duration {
+	delay := float64(c.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= c.Multiplier
+		if delay >= float64(c.MaxBackoff) {
+			return c.MaxBackoff
+		}
+	}
+
+	return time.Duration(delay)
+}
+
+type ReconnectEventType int
+
+const (
+	ReconnectEventDisconnected ReconnectEventType = iota
+	ReconnectEventAttempt
+	ReconnectEventReconnected
+	ReconnectEventFailed
+)
+
+func (t ReconnectEventType) String() string {
+	switch t {
+	case ReconnectEventDisconnected:
+		return "disconnected"
+	case ReconnectEventAttempt:
+		return "attempt"
+	case ReconnectEventReconnected:
+		return "reconnected"
+	case ReconnectEventFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+type ReconnectEvent struct {
+	Type    ReconnectEventType
+	Attempt int
+	Err     error
+	Time    time.Time
+}
+
+func (demuxer *GeneralDemuxer) SetReconnectConfig(config ReconnectConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	demuxer.reconnect = &config
+	return nil
+}
+
+// ReconnectEvents returns the channel on which reconnect events are published. Events are dropped if the channel is
+// not drained, except a ReconnectEventFailed, which replaces the oldest event. After it the streams end.
+func (demuxer *GeneralDemuxer) ReconnectEvents() <-chan ReconnectEvent {
+	return demuxer.events
+}
+
+func (demuxer *GeneralDemuxer) emit(eventType ReconnectEventType, attempt int, err error) {
+	event := ReconnectEvent{Type: eventType, Attempt: attempt, Err: err, Time: time.Now()}
+	for {
+		select {
+		case demuxer.events <- event:
+			return
+		default:
+		}
+
+		if eventType != ReconnectEventFailed {
+			return
+		}
+
+		// NOTE: THE FAILURE IS THE LAST EVENT; MAKE ROOM FOR IT BY DROPPING THE OLDEST ONE
+		select {
+		case <-demuxer.events:
+		default:
+		}
+	}
+}
+
+// reconnectInput keeps reopening the input according to the reconnect config until it succeeds, the attempts run
+// out, the codec parameters of the input change or the demuxer is stopped. When it gives up, the final error is
+// published as a ReconnectEventFailed, wrapping the error of the last attempt.
+func (demuxer *GeneralDemuxer) reconnectInput(cause error) error {
+	demuxer.emit(ReconnectEventDisconnected, 0, cause)
+
+	last := cause
+	attempt := 1
+	for ; demuxer.reconnect.MaxAttempts == 0 || attempt <= demuxer.reconnect.MaxAttempts; attempt++ {
+		select {
+		case <-demuxer.ctx.Done():
+			return demuxer.ctx.Err()
+		case <-time.After(demuxer.reconnect.backoff(attempt)):
+		}
+
+		demuxer.emit(ReconnectEventAttempt, attempt, nil)
+
+		if err := demuxer.reopen(); err != nil {
+			if errors.Is(err, ErrorReconnectParametersChanged) {
+				demuxer.emit(ReconnectEventFailed, attempt, err)
+				return err
+			}
+			last = err
+			continue
+		}
+
+		demuxer.emit(ReconnectEventReconnected, attempt, nil)
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %v", ErrorReconnectMaxAttempts, last)
+	demuxer.emit(ReconnectEventFailed, attempt-1, err)
+	return err
+}
+
+func (demuxer *GeneralDemuxer) reopen() error {
+	formatContext := astiav.AllocFormatContext()
+	if formatContext == nil {
+		return ErrorAllocateFormatContext
+	}
+
+	options := astiav.NewDictionary()
+	defer options.Free()
+
+	if err := options.Unpack(demuxer.packedInputOptions); err != nil {
+		formatContext.Free()
+		return err
+	}
+
+	if err := formatContext.OpenInput(demuxer.containerAddress, demuxer.inputFormat, options); err != nil {
+		formatContext.Free()
+		return err
+	}
+
+	if err := formatContext.FindStreamInfo(nil); err != nil {
+		formatContext.CloseInput()
+		formatContext.Free()
+		return ErrorNoStreamFound
+	}
+
+	streams := make(map[int]*astiav.Stream)
+	for _, stream := range formatContext.Streams() {
+		streams[stream.Index()] = stream
+	}
+
+	for _, s := range demuxer.streams {
+		stream, ok := streams[s.index]
+		if !ok || !sameCodecParameters(s.GetCodecParameters(), stream.CodecParameters()) {
+			formatContext.CloseInput()
+			formatContext.Free()
+			return ErrorReconnectParametersChanged
+		}
+	}
+
+	demuxer.mux.Lock()
+	old := demuxer.formatContext
+	demuxer.formatContext = formatContext
+	var err error
+	for _, s := range demuxer.streams {
+		if e := s.reopened(streams[s.index]); e != nil && err == nil {
+			err = e
+		}
+	}
+	demuxer.mux.Unlock()
+
+	old.CloseInput()
+	old.Free()
+
+	return err
+}
+
+func sameCodecParameters(a, b *astiav.CodecParameters) bool {
+	if a.MediaType() != b.MediaType() || a.CodecID() != b.CodecID() {
+		return false
+	}
+
+	switch a.MediaType() {
+	case astiav.MediaTypeVideo:
+		return a.Width() == b.Width() && a.Height() == b.Height() && a.PixelFormat() == b.PixelFormat()
+	case astiav.MediaTypeAudio:
+		return a.SampleRate() == b.SampleRate() && a.SampleFormat() == b.SampleFormat() && a.ChannelLayout().Equal(b.ChannelLayout())
+	default:
+		return true
+	}
+}
@@ -0,0 +1,203 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+
+	"github.com/harshabose/tools/buffer/pkg"
+)
+
+// StreamSelector picks the streams of an opened input that the GeneralDemuxer should produce packets for.
+type StreamSelector = func(formatContext *astiav.FormatContext) ([]*astiav.Stream, error)
+
+// DemuxerStream is the packet producer for one selected stream of a GeneralDemuxer. All DemuxerStream of a demuxer
+// are fed from the same read loop, so a video decoder and an audio decoder can consume the same input.
+type DemuxerStream struct {
+	demuxer         *GeneralDemuxer
+	index           int
+	stream          *astiav.Stream
+	codecParameters *astiav.CodecParameters // NOTE: OWN COPY; STAYS VALID WHEN THE DEMUXER RECONNECTS
+	timeBase        astiav.Rational         // NOTE: OF THE FIRST INPUT; PACKETS OF A REOPENED INPUT ARE RESCALED TO IT
+	buffer          buffer.BufferWithGenerator[astiav.Packet]
+	eos             *endOfStream[astiav.Packet]
+	skipToKeyFrame  bool // NOTE: ONLY USED BY THE READ LOOP
+	timestamps      streamTimestamps
+}
+
+// streamTimestamps keeps the timestamps of a stream continuous across reconnects. Only the read loop uses it.
+type streamTimestamps struct {
+	offset  int64
+	nextDTS int64
+	rebase  bool
+	started bool
+}
+
+func newDemuxerStream(demuxer *GeneralDemuxer, stream *astiav.Stream, buffer buffer.BufferWithGenerator[astiav.Packet]) (*DemuxerStream, error) {
+	codecParameters := astiav.AllocCodecParameters()
+	if codecParameters == nil {
+		return nil, ErrorGeneralAllocate
+	}
+	if err := stream.CodecParameters().Copy(codecParameters); err != nil {
+		codecParameters.Free()
+		return nil, err
+	}
+
+	return &DemuxerStream{
+		demuxer:         demuxer,
+		index:           stream.Index(),
+		stream:          stream,
+		codecParameters: codecParameters,
+		timeBase:        stream.TimeBase(),
+		buffer:          buffer,
+		eos:             newEndOfStream[astiav.Packet](),
+	}, nil
+}
+
+// reopened points the stream at the stream of the reopened input; the caller holds the demuxer lock. The next packet
+// is rebased to follow the last packet of the old input.
+func (s *DemuxerStream) reopened(stream *astiav.Stream) error {
+	s.stream = stream
+	s.timestamps.rebase = s.timestamps.started
+	return stream.CodecParameters().Copy(s.codecParameters)
+}
+
+// rebase rescales the packet to the time base of the first input and shifts it by the offset of the reconnects, so
+// the timestamps keep increasing when the reopened input starts over.
+func (s *DemuxerStream) rebase(packet *astiav.Packet) {
+	// NOTE: THE STREAM IS ONLY SWAPPED BY THE READ LOOP ITSELF; NO LOCK NEEDED
+	if inputTimeBase := s.stream.TimeBase(); inputTimeBase != s.timeBase {
+		packet.RescaleTs(inputTimeBase, s.timeBase)
+	}
+
+	dts := packet.Dts()
+	if dts == astiav.NoPtsValue {
+		dts = packet.Pts()
+	}
+	if dts == astiav.NoPtsValue {
+		return
+	}
+
+	ts := &s.timestamps
+	if ts.rebase {
+		ts.offset = ts.nextDTS - dts
+		ts.rebase = false
+	}
+
+	if ts.offset != 0 {
+		packet.SetDts(shiftTimestamp(packet.Dts(), ts.offset))
+		packet.SetPts(shiftTimestamp(packet.Pts(), ts.offset))
+	}
+
+	ts.nextDTS = dts + ts.offset + max(packet.Duration(), 1)
+	ts.started = true
+}
+
+func shiftTimestamp(ts, offset int64) int64 {
+	if ts == astiav.NoPtsValue {
+		return ts
+	}
+	return ts + offset
+}
+
+func (s *DemuxerStream) free() {
+	if s.codecParameters != nil {
+		s.codecParameters.Free()
+		s.codecParameters = nil
+	}
+}
+
+func (s *DemuxerStream) Index() int {
+	return s.index
+}
+
+// pushPacket hands the packet to the stream, waiting for room in its buffer so a consumer that falls behind holds back
+// the read loop. With WithLiveInputDropOption the packet is dropped instead when the buffer is full, and so is every
+// packet after it up to the next keyframe, as the decoder cannot use them; each dropped packet is reported.
+func (s *DemuxerStream) pushPacket(ctx context.Context, packet *astiav.Packet) error {
+	if !s.demuxer.dropWhenFull {
+		return s.buffer.Push(ctx, packet)
+	}
+
+	if s.skipToKeyFrame && !packet.Flags().Has(astiav.PacketFlagKey) {
+		return s.dropped(fmt.Errorf("%w: stream %d is waiting for a keyframe", ErrorStreamBufferFull, s.index))
+	}
+
+	if err := s.tryPush(ctx, packet); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		s.skipToKeyFrame = true
+		return s.dropped(fmt.Errorf("%w: stream %d", ErrorStreamBufferFull, s.index))
+	}
+
+	s.skipToKeyFrame = false
+	return nil
+}
+
+func (s *DemuxerStream) dropped(err error) error {
+	s.demuxer.reportError(err)
+	return err
+}
+
+func (s *DemuxerStream) tryPush(ctx context.Context, packet *astiav.Packet) error {
+	channel := s.buffer.GetChannel()
+	if channel == nil {
+		// NOTE: A BUFFER SET WITH SetBuffer MAY NOT BE BACKED BY A CHANNEL; ONLY WAIT BRIEFLY ON IT
+		ctx2, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+		defer cancel()
+
+		return s.buffer.Push(ctx2, packet)
+	}
+
+	select {
+	case channel <- packet:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return ErrorStreamBufferFull
+	}
+}
+
+// ## CanProduceMediaPacket
+
+func (s *DemuxerStream) GetPacket(ctx context.Context) (*astiav.Packet, error) {
+	return s.eos.pop(ctx, s.buffer)
+}
+
+func (s *DemuxerStream) PutBack(packet *astiav.Packet) {
+	s.buffer.PutBack(packet)
+}
+
+// ## CanDescribeMediaPacket
+
+// NOTE: THE UNDERLYING STREAM IS SWAPPED WHEN THE DEMUXER RECONNECTS; HENCE THE LOCKS
+
+func (s *DemuxerStream) GetCodecParameters() *astiav.CodecParameters {
+	s.demuxer.mux.RLock()
+	defer s.demuxer.mux.RUnlock()
+
+	return s.codecParameters
+}
+
+func (s *DemuxerStream) MediaType() astiav.MediaType {
+	return s.GetCodecParameters().MediaType()
+}
+
+func (s *DemuxerStream) CodecID() astiav.CodecID {
+	return s.GetCodecParameters().CodecID()
+}
+
+func (s *DemuxerStream) FrameRate() astiav.Rational {
+	s.demuxer.mux.RLock()
+	defer s.demuxer.mux.RUnlock()
+
+	return s.demuxer.formatContext.GuessFrameRate(s.stream, nil)
+}
+
+func (s *DemuxerStream) TimeBase() astiav.Rational {
+	return s.timeBase
+}
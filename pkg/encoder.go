@@ -2,9 +2,10 @@ package transcode
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/asticode/go-astiav"
@@ -15,35 +16,46 @@ import (
 )
 
 type GeneralEncoder struct {
-	buffer          buffer.BufferWithGenerator[astiav.Packet]
-	producer        CanProduceMediaFrame
-	codec           *astiav.Codec
-	encoderContext  *astiav.CodecContext
-	codecFlags      *astiav.Dictionary
-	encoderSettings codecSettings
-	sps             []byte
-	pps             []byte
-	ctx             context.Context
-	cancel          context.CancelFunc
+	buffer             buffer.BufferWithGenerator[astiav.Packet]
+	producer           CanProduceMediaFrame
+	codec              *astiav.Codec
+	encoderContext     *astiav.CodecContext
+	codecFlags         *astiav.Dictionary
+	encoderSettings    codecSettings
+	settingsMux        sync.RWMutex
+	codecParameters    *astiav.CodecParameters
+	eos                *endOfStream[astiav.Packet]
+	keyFrames          *keyFrameRequests
+	fifo               *audioFIFO
+	bitstreamFormat    BitstreamFormat
+	repeatSets         bool
+	formatter          *bitstreamFormatter
+	pendingRateControl atomic.Pointer[RateControlConfig]
+	parameterSets      ParameterSets // NOTE: SET ONCE WHEN THE ENCODER IS OPENED
+	ctx                context.Context
+	cancel             context.CancelFunc
 }
 
 func CreateGeneralEncoder(ctx context.Context, codecID astiav.CodecID, canProduceMediaFrame CanProduceMediaFrame, options ...EncoderOption) (*GeneralEncoder, error) {
 	ctx2, cancel := context.WithCancel(ctx)
 	encoder := &GeneralEncoder{
-		producer:   canProduceMediaFrame,
-		codecFlags: astiav.NewDictionary(),
-		ctx:        ctx2,
-		cancel:     cancel,
+		producer:        canProduceMediaFrame,
+		codecFlags:      astiav.NewDictionary(),
+		codecParameters: astiav.AllocCodecParameters(),
+		eos:             newEndOfStream[astiav.Packet](),
+		keyFrames:       newKeyFrameRequests(defaultKeyFrameRequestInterval),
+		ctx:             ctx2,
+		cancel:          cancel,
 	}
 
 	encoder.codec = astiav.FindEncoder(codecID)
 	if encoder.encoderContext = astiav.AllocCodecContext(encoder.codec); encoder.encoderContext == nil {
-		return nil, ErrorAllocateCodecContext
+		return encoder.fail(ErrorAllocateCodecContext)
 	}
 
 	canDescribeMediaFrame, ok := canProduceMediaFrame.(CanDescribeMediaFrame)
 	if !ok {
-		return nil, ErrorInterfaceMismatch
+		return encoder.fail(ErrorInterfaceMismatch)
 	}
 	if canDescribeMediaFrame.MediaType() == astiav.MediaTypeAudio {
 		withAudioSetEncoderContextParameters(canDescribeMediaFrame, encoder.encoderContext)
@@ -54,7 +66,7 @@ func CreateGeneralEncoder(ctx context.Context, codecID astiav.CodecID, canProduc
 
 	for _, option := range options {
 		if err := option(encoder); err != nil {
-			return nil, err
+			return encoder.fail(err)
 		}
 	}
 
@@ -62,19 +74,53 @@ func CreateGeneralEncoder(ctx context.Context, codecID astiav.CodecID, canProduc
 		fmt.Println("warn: no encoder settings are provided")
 	}
 
+	if encoder.buffer == nil {
+		encoder.buffer = buffer.CreateChannelBuffer(ctx2, 256, internal.CreatePacketPool())
+	}
+
+	if err := encoder.open(); err != nil {
+		return encoder.fail(err)
+	}
+
+	return encoder, nil
+}
+
+// open opens the encoder context with the flags collected from the settings and options, and sets up what depends on
+// the opened context. CreateGeneralEncoder and GeneralEncoderBuilder both open through here, so both reject options
+// the codec does not know.
+func (encoder *GeneralEncoder) open() error {
+	// NOTE: THE PARAMETER SETS ARE NEEDED AS EXTRADATA; USE WithRepeatParameterSets TO ALSO HAVE THEM IN-BAND
 	encoder.encoderContext.SetFlags(astiav.NewCodecContextFlags(astiav.CodecContextFlagGlobalHeader))
 
+	if err := validateCodecOptions(encoder.encoderContext, encoder.codecFlags); err != nil {
+		return err
+	}
+
 	if err := encoder.encoderContext.Open(encoder.codec, encoder.codecFlags); err != nil {
-		return nil, err
+		return err
 	}
 
-	if encoder.buffer == nil {
-		encoder.buffer = buffer.CreateChannelBuffer(ctx2, 256, internal.CreatePacketPool())
+	if err := unusedCodecOptions(encoder.codecFlags); err != nil {
+		return err
 	}
 
-	encoder.findParameterSets(encoder.encoderContext.ExtraData())
+	if err := encoder.codecParameters.FromCodecContext(encoder.encoderContext); err != nil {
+		return err
+	}
 
-	return encoder, nil
+	encoder.fifo = newAudioFIFO(encoder.encoderContext)
+	if err := encoder.findParameterSets(encoder.encoderContext.ExtraData()); err != nil {
+		return err
+	}
+
+	return encoder.initFormatter()
+}
+
+// fail frees what was allocated for an encoder that could not be created and cancels its context.
+func (encoder *GeneralEncoder) fail(err error) (*GeneralEncoder, error) {
+	encoder.close()
+	encoder.cancel()
+	return nil, err
 }
 
 func (encoder *GeneralEncoder) Ctx() context.Context {
@@ -85,9 +131,80 @@ func (encoder *GeneralEncoder) Start() {
 	go encoder.loop()
 }
 
+// ForceKeyFrame marks the next frame sent to the encoder as a keyframe. Requests arriving faster than the keyframe
+// request interval are coalesced.
+func (encoder *GeneralEncoder) ForceKeyFrame() error {
+	encoder.keyFrames.request()
+	return nil
+}
+
+func (encoder *GeneralEncoder) SetKeyFrameRequestInterval(interval time.Duration) {
+	encoder.keyFrames.setMinInterval(interval)
+}
+
+func (encoder *GeneralEncoder) SetBitstreamFormat(format BitstreamFormat) {
+	encoder.bitstreamFormat = format
+}
+
+func (encoder *GeneralEncoder) SetRepeatParameterSets(repeat bool) {
+	encoder.repeatSets = repeat
+}
+
+// initFormatter sets up the conversion of the packets to the bitstream format, once the encoder is open. The codec
+// parameters get the extradata of that format.
+func (encoder *GeneralEncoder) initFormatter() error {
+	formatter, err := newBitstreamFormatter(encoder.codecParameters, encoder.encoderContext.TimeBase(), encoder.bitstreamFormat, encoder.repeatSets)
+	if err != nil || formatter == nil {
+		return err
+	}
+	encoder.formatter = formatter
+
+	extraData, err := formatter.extraData(encoder.parameterSets)
+	if err != nil {
+		return err
+	}
+
+	return encoder.codecParameters.SetExtraData(extraData)
+}
+
+// inBand returns the keyframe data with the parameter sets of the encoder in front of it, in the bitstream format of
+// the encoder.
+func (encoder *GeneralEncoder) inBand(data []byte) []byte {
+	if encoder.formatter != nil {
+		return encoder.formatter.inBand(data, encoder.parameterSets)
+	}
+
+	return encoder.parameterSets.inBand(data)
+}
+
+// GetParameterSets returns the first SPS and PPS, each behind a start code. HEVC also needs its VPS; use
+// GetCodecParameterSets for it.
 func (encoder *GeneralEncoder) GetParameterSets() ([]byte, []byte, error) {
-	encoder.findParameterSets(encoder.encoderContext.ExtraData())
-	return encoder.sps, encoder.pps, nil
+	return annexBFirst(encoder.parameterSets.SPS), annexBFirst(encoder.parameterSets.PPS), nil
+}
+
+// GetCodecParameterSets returns all parameter sets of the encoder: SPS/PPS for H.264, VPS/SPS/PPS for HEVC and the
+// sequence header for AV1.
+func (encoder *GeneralEncoder) GetCodecParameterSets() (ParameterSets, error) {
+	return encoder.parameterSets, nil
+}
+
+// ## CanDescribeMediaPacket
+
+func (encoder *GeneralEncoder) MediaType() astiav.MediaType {
+	return encoder.encoderContext.MediaType()
+}
+
+func (encoder *GeneralEncoder) CodecID() astiav.CodecID {
+	return encoder.encoderContext.CodecID()
+}
+
+func (encoder *GeneralEncoder) GetCodecParameters() *astiav.CodecParameters {
+	return encoder.codecParameters
+}
+
+func (encoder *GeneralEncoder) FrameRate() astiav.Rational {
+	return encoder.encoderContext.Framerate()
 }
 
 func (encoder *GeneralEncoder) TimeBase() astiav.Rational {
@@ -105,31 +222,106 @@ loop1:
 		default:
 			frame, err := encoder.getFrame()
 			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					encoder.drain()
+					return
+				}
 				// fmt.Println("unable to get packet from encoder; err:", err.Error())
 				continue
 			}
+			if !encoder.fitsContext(frame) {
+				// NOTE: FRAMES FROM BEFORE AN UPSTREAM RESIZE; THE ENCODER THAT FITS THEM HAS BEEN REPLACED
+				encoder.producer.PutBack(frame)
+				continue
+			}
+			if encoder.fifo != nil {
+				encoder.encodeAudio(frame)
+				continue loop1
+			}
+			encoder.applyRateControl()
+			if encoder.keyFrames.take(time.Now()) {
+				// NOTE: libx264 TURNS AN I PICTURE TYPE INTO AN IDR (OR AN I WITH RECOVERY POINT WITH OPEN-GOP)
+				frame.SetPictureType(astiav.PictureTypeI)
+			}
 			if err := encoder.encoderContext.SendFrame(frame); err != nil {
 				encoder.producer.PutBack(frame)
 				if !errors.Is(err, astiav.ErrEagain) {
 					continue loop1
 				}
 			}
-		loop2:
-			for {
-				packet := encoder.buffer.Generate()
-				if err = encoder.encoderContext.ReceivePacket(packet); err != nil {
-					encoder.buffer.PutBack(packet)
-					break loop2
-				}
+			encoder.receivePackets()
+			encoder.producer.PutBack(frame)
+		}
+	}
+}
 
-				if err := encoder.pushPacket(packet); err != nil {
-					encoder.buffer.PutBack(packet)
-					continue loop2
-				}
+func (encoder *GeneralEncoder) receivePackets() {
+	for {
+		packet := encoder.buffer.Generate()
+		if err := encoder.encoderContext.ReceivePacket(packet); err != nil {
+			encoder.buffer.PutBack(packet)
+			return
+		}
+
+		if err := encoder.formatPacket(packet); err != nil {
+			encoder.buffer.PutBack(packet)
+			continue
+		}
+
+		if err := encoder.pushPacket(packet); err != nil {
+			encoder.buffer.PutBack(packet)
+		}
+	}
+}
+
+// drain flushes the packets buffered inside the encoder and signals the end of stream downstream. It then waits for
+// the encoder to be stopped, so the encoder stays describable until then.
+func (encoder *GeneralEncoder) drain() {
+	if encoder.fifo != nil {
+		encoder.flushAudio()
+	}
+
+	if err := encoder.encoderContext.SendFrame(nil); err == nil {
+		for {
+			packet := encoder.buffer.Generate()
+			if err := encoder.encoderContext.ReceivePacket(packet); err != nil {
+				encoder.buffer.PutBack(packet)
+				break
+			}
+
+			if err := encoder.formatPacket(packet); err != nil {
+				encoder.buffer.PutBack(packet)
+				continue
+			}
+
+			if err := encoder.buffer.Push(encoder.ctx, packet); err != nil {
+				encoder.buffer.PutBack(packet)
+				return
 			}
-			encoder.producer.PutBack(frame)
 		}
 	}
+
+	if err := encoder.eos.push(encoder.ctx, encoder.buffer); err != nil {
+		return
+	}
+
+	<-encoder.ctx.Done()
+}
+
+func (encoder *GeneralEncoder) formatPacket(packet *astiav.Packet) error {
+	if encoder.formatter == nil {
+		return nil
+	}
+
+	return encoder.formatter.rewrite(packet, encoder.parameterSets)
+}
+
+func (encoder *GeneralEncoder) fitsContext(frame *astiav.Frame) bool {
+	if encoder.encoderContext.MediaType() != astiav.MediaTypeVideo {
+		return true
+	}
+
+	return frame.Width() == encoder.encoderContext.Width() && frame.Height() == encoder.encoderContext.Height()
 }
 
 func (encoder *GeneralEncoder) getFrame() (*astiav.Frame, error) {
@@ -140,7 +332,7 @@ func (encoder *GeneralEncoder) getFrame() (*astiav.Frame, error) {
 }
 
 func (encoder *GeneralEncoder) GetPacket(ctx context.Context) (*astiav.Packet, error) {
-	return encoder.buffer.Pop(ctx)
+	return encoder.eos.pop(ctx, encoder.buffer)
 }
 
 func (encoder *GeneralEncoder) pushPacket(packet *astiav.Packet) error {
@@ -159,6 +351,14 @@ func (encoder *GeneralEncoder) Stop() {
 }
 
 func (encoder *GeneralEncoder) close() {
+	if encoder.fifo != nil {
+		encoder.fifo.free()
+	}
+
+	if encoder.formatter != nil {
+		encoder.formatter.free()
+	}
+
 	if encoder.encoderContext != nil {
 		encoder.encoderContext.Free()
 	}
@@ -166,59 +366,73 @@ func (encoder *GeneralEncoder) close() {
 	if encoder.codecFlags != nil {
 		encoder.codecFlags.Free()
 	}
-}
-
-func (encoder *GeneralEncoder) findParameterSets(extraData []byte) {
-	if len(extraData) > 0 {
-		// Find the first start code (0x00000001)
-		for i := 0; i < len(extraData)-4; i++ {
-			if extraData[i] == 0 && extraData[i+1] == 0 && extraData[i+2] == 0 && extraData[i+3] == 1 {
-				// Skip start code to get the NAL type
-				nalType := extraData[i+4] & 0x1F
 
-				// Find the next start code or end
-				nextStart := len(extraData)
-				for j := i + 4; j < len(extraData)-4; j++ {
-					if extraData[j] == 0 && extraData[j+1] == 0 && extraData[j+2] == 0 && extraData[j+3] == 1 {
-						nextStart = j
-						break
-					}
-				}
+	if encoder.codecParameters != nil {
+		encoder.codecParameters.Free()
+	}
+}
 
-				if nalType == 7 { // SPS
-					encoder.sps = make([]byte, nextStart-i)
-					copy(encoder.sps, extraData[i:nextStart])
-				} else if nalType == 8 { // PPS
-					encoder.pps = make([]byte, len(extraData)-i)
-					copy(encoder.pps, extraData[i:])
-				}
+// findParameterSets parses the parameter sets out of the extradata of the opened encoder. The extradata does not
+// change once the encoder is open, so this only runs when it is opened; parameterSets is read-only afterwards
+// and safe to read from any goroutine. Extradata that cannot be parsed fails the open, as the parameter sets could
+// neither be repeated in-band nor be given out.
+func (encoder *GeneralEncoder) findParameterSets(extraData []byte) error {
+	if len(extraData) == 0 {
+		return nil
+	}
 
-				i = nextStart - 1
-			}
-		}
-		fmt.Println("SPS for current encoder: ", encoder.sps)
-		fmt.Println("\tSPS for current encoder in Base64:", base64.StdEncoding.EncodeToString(encoder.sps))
-		fmt.Println("PPS for current encoder: ", encoder.pps)
-		fmt.Println("\tPPS for current encoder in Base64:", base64.StdEncoding.EncodeToString(encoder.pps))
+	sets, err := parseParameterSets(encoder.encoderContext.CodecID(), extraData)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrorParseParameterSets, err)
 	}
+	encoder.parameterSets = sets
+	return nil
 }
 
 func (encoder *GeneralEncoder) SetBuffer(buffer buffer.BufferWithGenerator[astiav.Packet]) {
 	encoder.buffer = buffer
 }
 
+// SetEncoderCodecSettings applies a private copy of the settings, so presets can be shared by many encoders.
 func (encoder *GeneralEncoder) SetEncoderCodecSettings(settings codecSettings) error {
-	encoder.encoderSettings = settings
-	return encoder.encoderSettings.ForEach(func(key string, value string) error {
+	settings = cloneCodecSettings(settings)
+	encoder.setSettings(settings)
+
+	if err := settings.ForEach(func(key string, value string) error {
 		if value == "" {
 			return nil
 		}
 		return encoder.codecFlags.Set(key, value, 0)
-	})
+	}); err != nil {
+		return err
+	}
+
+	// NOTE: KEEP THE CONTEXT IN SYNC WITH THE SETTINGS; libx264 RECONFIGURES FROM THE CONTEXT FIELDS ON EVERY FRAME
+	if r, ok := settings.(canDescribeRateControl); ok && encoder.CanReconfigure() {
+		if config, err := r.rateControl(); err == nil {
+			encoder.setRateControl(config)
+		}
+	}
+
+	return nil
+}
+
+func (encoder *GeneralEncoder) settings() codecSettings {
+	encoder.settingsMux.RLock()
+	defer encoder.settingsMux.RUnlock()
+
+	return encoder.encoderSettings
+}
+
+func (encoder *GeneralEncoder) setSettings(settings codecSettings) {
+	encoder.settingsMux.Lock()
+	defer encoder.settingsMux.Unlock()
+
+	encoder.encoderSettings = settings
 }
 
 func (encoder *GeneralEncoder) GetCurrentBitrate() (int64, error) {
-	g, ok := encoder.encoderSettings.(CanGetCurrentBitrate)
+	g, ok := encoder.settings().(CanGetCurrentBitrate)
 	if !ok {
 		return 0, ErrorInterfaceMismatch
 	}
@@ -0,0 +1,114 @@
+package transcode
+
+import (
+	"errors"
+
+	"github.com/asticode/go-astiav"
+)
+
+// audioFIFO re-chunks audio frames to the frame size the encoder requires. Opus (960 samples at 48 kHz) and AAC
+// (1024 samples) reject frames of any other size, while filters and decoders produce whatever size they like.
+type audioFIFO struct {
+	fifo      *astiav.AudioFifo
+	frameSize int
+	format    astiav.SampleFormat
+	layout    astiav.ChannelLayout
+	rate      int
+	timeBase  astiav.Rational
+	nextPts   int64
+}
+
+// newAudioFIFO returns nil for video encoders and for audio encoders that accept frames of any size.
+func newAudioFIFO(eCtx *astiav.CodecContext) *audioFIFO {
+	if eCtx.MediaType() != astiav.MediaTypeAudio || eCtx.FrameSize() <= 0 {
+		return nil
+	}
+
+	return &audioFIFO{
+		fifo:      astiav.AllocAudioFifo(eCtx.SampleFormat(), eCtx.ChannelLayout().Channels(), eCtx.FrameSize()),
+		frameSize: eCtx.FrameSize(),
+		format:    eCtx.SampleFormat(),
+		layout:    eCtx.ChannelLayout(),
+		rate:      eCtx.SampleRate(),
+		timeBase:  eCtx.TimeBase(),
+	}
+}
+
+func (f *audioFIFO) write(frame *astiav.Frame) error {
+	if f.fifo.Size() == 0 {
+		// NOTE: RESYNC ON THE INPUT WHENEVER NOTHING IS HELD, SO GAPS IN THE INPUT SURVIVE THE RE-CHUNKING
+		f.nextPts = frame.Pts()
+	}
+
+	_, err := f.fifo.Write(frame)
+	return err
+}
+
+// read fills frame with the next chunk of frame size samples. With flush, a last shorter chunk is also returned.
+// Returns false if there are not enough samples.
+func (f *audioFIFO) read(frame *astiav.Frame, flush bool) (bool, error) {
+	samples := min(f.fifo.Size(), f.frameSize)
+	if samples == 0 || (samples < f.frameSize && !flush) {
+		return false, nil
+	}
+
+	frame.Unref()
+	frame.SetNbSamples(samples)
+	frame.SetSampleFormat(f.format)
+	frame.SetChannelLayout(f.layout)
+	frame.SetSampleRate(f.rate)
+	if err := frame.AllocBuffer(0); err != nil {
+		return false, err
+	}
+
+	if _, err := f.fifo.Read(frame); err != nil {
+		return false, err
+	}
+
+	frame.SetPts(f.nextPts)
+	f.nextPts += astiav.RescaleQ(int64(samples), astiav.NewRational(1, f.rate), f.timeBase)
+
+	return true, nil
+}
+
+func (f *audioFIFO) free() {
+	f.fifo.Free()
+}
+
+// encodeAudio queues the frame in the FIFO and encodes every full chunk it holds.
+func (encoder *GeneralEncoder) encodeAudio(frame *astiav.Frame) {
+	err := encoder.fifo.write(frame)
+	encoder.producer.PutBack(frame)
+	if err != nil {
+		return
+	}
+
+	encoder.encodeAudioChunks(false)
+}
+
+// flushAudio encodes what is left in the FIFO at the end of stream.
+func (encoder *GeneralEncoder) flushAudio() {
+	encoder.encodeAudioChunks(true)
+}
+
+func (encoder *GeneralEncoder) encodeAudioChunks(flush bool) {
+	chunk := astiav.AllocFrame()
+	defer chunk.Free()
+
+	for {
+		ok, err := encoder.fifo.read(chunk, flush)
+		if err != nil || !ok {
+			return
+		}
+
+		encoder.applyRateControl()
+		err = encoder.encoderContext.SendFrame(chunk)
+		if errors.Is(err, astiav.ErrEagain) {
+			encoder.receivePackets()
+			err = encoder.encoderContext.SendFrame(chunk)
+		}
+		if err == nil {
+			encoder.receivePackets()
+		}
+	}
+}
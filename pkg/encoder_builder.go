@@ -2,6 +2,7 @@ package transcode
 
 import (
 	"context"
+	"sync"
 
 	"github.com/asticode/go-astiav"
 )
@@ -11,24 +12,42 @@ type GeneralEncoderBuilder struct {
 	bufferSize int
 	settings   codecSettings
 	producer   CanProduceMediaFrame
+	options    []EncoderOption
+	mux        sync.RWMutex
 }
 
-func NewEncoderBuilder(codecID astiav.CodecID, settings codecSettings, bufferSize int, producer CanProduceMediaFrame) *GeneralEncoderBuilder {
+// NewEncoderBuilder keeps a private copy of the settings, so presets can be shared by many builders. The options are
+// applied to every encoder built, after the settings.
+func NewEncoderBuilder(codecID astiav.CodecID, settings codecSettings, bufferSize int, producer CanProduceMediaFrame, options ...EncoderOption) *GeneralEncoderBuilder {
 	return &GeneralEncoderBuilder{
 		bufferSize: bufferSize,
 		codecID:    codecID,
-		settings:   settings,
+		settings:   cloneCodecSettings(settings),
 		producer:   producer,
+		options:    options,
 	}
 }
 
+// UpdateBitrate replaces the settings of the builder with a copy at the new bitrate. Encoders already built keep the
+// settings they were built with.
 func (b *GeneralEncoderBuilder) UpdateBitrate(bps int64) error {
-	s, ok := b.settings.(CanUpdateBitrate)
-	if !ok {
-		return ErrorInterfaceMismatch
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	updated, err := withUpdatedBitrate(b.settings, bps)
+	if err != nil {
+		return err
 	}
 
-	return s.UpdateBitrate(bps)
+	b.settings = updated
+	return nil
+}
+
+func (b *GeneralEncoderBuilder) currentSettings() codecSettings {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	return b.settings
 }
 
 func (b *GeneralEncoderBuilder) BuildWithProducer(ctx context.Context, producer CanProduceMediaFrame) (Encoder, error) {
@@ -37,28 +56,33 @@ func (b *GeneralEncoderBuilder) BuildWithProducer(ctx context.Context, producer
 }
 
 func (b *GeneralEncoderBuilder) Build(ctx context.Context) (Encoder, error) {
-	codec := astiav.FindEncoder(b.codecID)
+	settings := b.currentSettings()
+
+	codec := findEncoder(b.codecID, settings)
 	if codec == nil {
 		return nil, ErrorNoCodecFound
 	}
 
 	ctx2, cancel := context.WithCancel(ctx)
 	encoder := &GeneralEncoder{
-		producer:   b.producer,
-		codec:      codec,
-		codecFlags: astiav.NewDictionary(),
-		ctx:        ctx2,
-		cancel:     cancel,
+		producer:        b.producer,
+		codec:           codec,
+		codecFlags:      astiav.NewDictionary(),
+		codecParameters: astiav.AllocCodecParameters(),
+		eos:             newEndOfStream[astiav.Packet](),
+		keyFrames:       newKeyFrameRequests(defaultKeyFrameRequestInterval),
+		ctx:             ctx2,
+		cancel:          cancel,
 	}
 
 	encoder.encoderContext = astiav.AllocCodecContext(codec)
 	if encoder.encoderContext == nil {
-		return nil, ErrorAllocateCodecContext
+		return b.fail(encoder, ErrorAllocateCodecContext)
 	}
 
 	canDescribeMediaFrame, ok := encoder.producer.(CanDescribeMediaFrame)
 	if !ok {
-		return nil, ErrorInterfaceMismatch
+		return b.fail(encoder, ErrorInterfaceMismatch)
 	}
 	if canDescribeMediaFrame.MediaType() == astiav.MediaTypeAudio {
 		withAudioSetEncoderContextParameters(canDescribeMediaFrame, encoder.encoderContext)
@@ -67,26 +91,64 @@ func (b *GeneralEncoderBuilder) Build(ctx context.Context) (Encoder, error) {
 		withVideoSetEncoderContextParameter(canDescribeMediaFrame, encoder.encoderContext)
 	}
 
-	if err := encoder.SetEncoderCodecSettings(b.settings); err != nil {
-		return nil, err
+	if err := encoder.SetEncoderCodecSettings(settings); err != nil {
+		return b.fail(encoder, err)
 	}
 
 	if err := WithEncoderBufferSize(b.bufferSize)(encoder); err != nil {
-		return nil, err
+		return b.fail(encoder, err)
 	}
-	encoder.encoderContext.SetFlags(astiav.NewCodecContextFlags(astiav.CodecContextFlagGlobalHeader))
 
-	if err := encoder.encoderContext.Open(encoder.codec, encoder.codecFlags); err != nil {
-		return nil, err
+	for _, option := range b.options {
+		if err := option(encoder); err != nil {
+			return b.fail(encoder, err)
+		}
 	}
 
-	encoder.findParameterSets(encoder.encoderContext.ExtraData())
+	if err := encoder.open(); err != nil {
+		return b.fail(encoder, err)
+	}
 
 	return encoder, nil
 }
 
+// fail frees the encoder that could not be built. The encoder is returned as an Encoder, so it cannot use
+// GeneralEncoder.fail directly: a typed nil would not compare equal to nil.
+func (b *GeneralEncoderBuilder) fail(encoder *GeneralEncoder, err error) (Encoder, error) {
+	_, err = encoder.fail(err)
+	return nil, err
+}
+
+// canNameEncoder is implemented by codec settings that only apply to one of the encoders of their codec, e.g. the
+// AV1 settings which are either for libsvtav1 or for libaom-av1.
+type canNameEncoder interface {
+	encoderName() string
+}
+
+func findEncoder(codecID astiav.CodecID, settings codecSettings) *astiav.Codec {
+	if n, ok := settings.(canNameEncoder); ok {
+		if codec := astiav.FindEncoderByName(n.encoderName()); codec != nil && codec.ID() == codecID {
+			return codec
+		}
+	}
+
+	return astiav.FindEncoder(codecID)
+}
+
+// rateControl returns the rate control of the builder settings, or just the bitrate if the settings do not describe a
+// VBV setup.
+func (b *GeneralEncoderBuilder) rateControl(bps int64) RateControlConfig {
+	if r, ok := b.currentSettings().(canDescribeRateControl); ok {
+		if config, err := r.rateControl(); err == nil {
+			return config
+		}
+	}
+
+	return RateControlConfig{Bitrate: bps}
+}
+
 func (b *GeneralEncoderBuilder) GetCurrentBitrate() (int64, error) {
-	g, ok := b.settings.(CanGetCurrentBitrate)
+	g, ok := b.currentSettings().(CanGetCurrentBitrate)
 	if !ok {
 		return 0, ErrorInterfaceMismatch
 	}
@@ -2,8 +2,7 @@ package transcode
 
 import (
 	"fmt"
-	"reflect"
-	"strings"
+	"time"
 
 	"github.com/asticode/go-astiav"
 
@@ -21,49 +20,16 @@ type codecSettings interface {
 }
 
 type X264Opts struct {
-	Bitrate       string `x264-opts:"bitrate"`
-	VBVMaxBitrate string `x264-opts:"vbv-maxrate"`
-	VBVBuffer     string `x264-opts:"vbv-bufsize"`
-	RateTol       string `x264-opts:"ratetol"`
-	SyncLookAhead string `x264-opts:"sync-lookahead"`
-	AnnexB        string `x264-opts:"annexb"`
+	Bitrate       string `codec:"bitrate,opts=x264opts,omitempty"`
+	VBVMaxBitrate string `codec:"vbv-maxrate,opts=x264opts,omitempty"`
+	VBVBuffer     string `codec:"vbv-bufsize,opts=x264opts,omitempty"`
+	RateTol       string `codec:"ratetol,opts=x264opts,omitempty"`
+	SyncLookAhead string `codec:"sync-lookahead,opts=x264opts,omitempty"`
+	AnnexB        string `codec:"annexb,opts=x264opts,omitempty"` // NOTE: KEEP AT 1; USE WithBitstreamFormat FOR AVCC
 }
 
 func (x264 *X264Opts) ForEach(fn func(string, string) error) error {
-	t := reflect.TypeOf(*x264)
-	v := reflect.ValueOf(*x264)
-
-	// Build a single x264opts string
-	var optParts []string
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("x264-opts")
-		if tag != "" && v.Field(i).String() != "" {
-			optParts = append(optParts, tag+"="+v.Field(i).String())
-		}
-	}
-
-	// Join all options with colons
-	if len(optParts) > 0 {
-		x264optsValue := strings.Join(optParts, ":")
-		if err := fn("x264opts", x264optsValue); err != nil {
-			return err
-		}
-	}
-
-	// Also apply any regular x264 parameters
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("x264")
-		if tag != "" {
-			if err := fn(tag, v.Field(i).String()); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
+	return forEachCodecOption(x264, fn)
 }
 
 func (x264 *X264Opts) UpdateBitrate(bps int64) error {
@@ -74,47 +40,40 @@ func (x264 *X264Opts) UpdateBitrate(bps int64) error {
 	return nil
 }
 
+// X264OpenSettings are set on libx264 when it is opened. Every key is validated against the options libx264 knows, so
+// two keys that libx264 never understood were renamed, which changes what reaches the encoder:
+//   - SlicedThreads was sent as the unknown private option "slice" and dropped; it is now "sliced-threads" inside
+//     x264opts, so sliced threading is actually on where a preset sets it to "1".
+//   - ForceIDR was sent as "force-idr" and dropped; it is now libx264's "forced-idr", so forced keyframes are IDR
+//     frames where a preset sets it to "1".
 type X264OpenSettings struct {
 	*X264Opts
-	// RateControl   string `x264:"rc"`            // not sure; fuck
-	Preset        string `x264:"preset"`        // exists
-	Tune          string `x264:"tune"`          // exists
-	Refs          string `x264:"refs"`          // exists
-	Profile       string `x264:"profile"`       // exists
-	Level         string `x264:"level"`         // exists
-	Qmin          string `x264:"qmin"`          // exists
-	Qmax          string `x264:"qmax"`          // exists
-	BFrames       string `x264:"bf"`            // exists
-	BAdapt        string `x264:"b_strategy"`    // exists
-	NGOP          string `x264:"g"`             // exists
-	NGOPMin       string `x264:"keyint_min"`    // exists
-	Scenecut      string `x264:"sc_threshold"`  // exists
-	IntraRefresh  string `x264:"intra-refresh"` // exists
-	LookAhead     string `x264:"rc-lookahead"`  // exists
-	SlicedThreads string `x264:"slice"`         // exists
-	ForceIDR      string `x264:"force-idr"`     // exists
-	AQMode        string `x264:"aq-mode"`       // exists
-	AQStrength    string `x264:"aq-strength"`   // exists
-	MBTree        string `x264:"mbtree"`        // exists
-	Threads       string `x264:"threads"`       // exists
-	Aud           string `x264:"aud"`           // exists
+	// RateControl   string `codec:"rc,omitempty"`            // not sure; fuck
+	Preset        string `codec:"preset,omitempty"`                       // exists
+	Tune          string `codec:"tune,omitempty"`                         // exists
+	Refs          string `codec:"refs,omitempty"`                         // exists
+	Profile       string `codec:"profile,omitempty"`                      // exists
+	Level         string `codec:"level,omitempty"`                        // exists
+	Qmin          string `codec:"qmin,omitempty"`                         // exists
+	Qmax          string `codec:"qmax,omitempty"`                         // exists
+	BFrames       string `codec:"bf,omitempty"`                           // exists
+	BAdapt        string `codec:"b_strategy,omitempty"`                   // exists
+	NGOP          string `codec:"g,omitempty"`                            // exists
+	NGOPMin       string `codec:"keyint_min,omitempty"`                   // exists
+	Scenecut      string `codec:"sc_threshold,omitempty"`                 // exists
+	IntraRefresh  string `codec:"intra-refresh,omitempty"`                // exists
+	LookAhead     string `codec:"rc-lookahead,omitempty"`                 // exists
+	SlicedThreads string `codec:"sliced-threads,opts=x264opts,omitempty"` // exists
+	ForceIDR      string `codec:"forced-idr,omitempty"`                   // exists
+	AQMode        string `codec:"aq-mode,omitempty"`                      // exists
+	AQStrength    string `codec:"aq-strength,omitempty"`                  // exists
+	MBTree        string `codec:"mbtree,omitempty"`                       // exists
+	Threads       string `codec:"threads,omitempty"`                      // exists
+	Aud           string `codec:"aud,omitempty"`                          // exists
 }
 
 func (s *X264OpenSettings) ForEach(fn func(key, value string) error) error {
-	t := reflect.TypeOf(*s)
-	v := reflect.ValueOf(*s)
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("x264")
-		if tag != "" {
-			if err := fn(tag, v.Field(i).String()); err != nil {
-				return err
-			}
-		}
-	}
-
-	return s.X264Opts.ForEach(fn)
+	return forEachCodecOption(s, fn)
 }
 
 func (s *X264OpenSettings) UpdateBitrate(bps int64) error {
@@ -297,6 +256,26 @@ func WithWebRTCOptimisedOptions(encoder Encoder) error {
 	return WithCodecSettings(&WebRTCOptimisedX264Settings)(encoder)
 }
 
+func WithVP8RealtimeOptions(encoder Encoder) error {
+	return WithCodecSettings(&RealtimeVP8Settings)(encoder)
+}
+
+func WithVP9RealtimeOptions(encoder Encoder) error {
+	return WithCodecSettings(&RealtimeVP9Settings)(encoder)
+}
+
+func WithOpusVoIPOptions(encoder Encoder) error {
+	return WithCodecSettings(&VoIPOpusSettings)(encoder)
+}
+
+func WithOpusMusicOptions(encoder Encoder) error {
+	return WithCodecSettings(&MusicOpusSettings)(encoder)
+}
+
+func WithAACDefaultOptions(encoder Encoder) error {
+	return WithCodecSettings(&DefaultAACSettings)(encoder)
+}
+
 func WithCodecSettings(settings codecSettings) EncoderOption {
 	return func(encoder Encoder) error {
 		s, ok := encoder.(CanSetEncoderCodecSettings)
@@ -328,6 +307,41 @@ func withVideoSetEncoderContextParameter(filter CanDescribeMediaVideoFrame, eCtx
 	eCtx.SetFramerate(filter.FrameRate())
 }
 
+// WithKeyFrameRequestInterval sets the minimum time between keyframes forced by ForceKeyFrame.
+func WithKeyFrameRequestInterval(interval time.Duration) EncoderOption {
+	return func(encoder Encoder) error {
+		s, ok := encoder.(CanSetKeyFrameRequestInterval)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.SetKeyFrameRequestInterval(interval)
+		return nil
+	}
+}
+
+// WithBitstreamFormat sets how the H.264 and HEVC packets of the encoder are delimited; Annex-B by default.
+func WithBitstreamFormat(format BitstreamFormat) EncoderOption {
+	return func(encoder Encoder) error {
+		s, ok := encoder.(CanSetBitstreamFormat)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.SetBitstreamFormat(format)
+		return nil
+	}
+}
+
+// WithRepeatParameterSets sends the parameter sets in-band in front of every keyframe, for receivers that join
+// mid-stream.
+func WithRepeatParameterSets(encoder Encoder) error {
+	s, ok := encoder.(CanRepeatParameterSets)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetRepeatParameterSets(true)
+	return nil
+}
+
 func WithEncoderBufferSize(size int) EncoderOption {
 	return func(encoder Encoder) error {
 		s, ok := encoder.(CanSetBuffer[astiav.Packet])
@@ -338,41 +352,3 @@ func WithEncoderBufferSize(size int) EncoderOption {
 		return nil
 	}
 }
-
-//
-// type VP8Settings struct {
-// 	Deadline string `vp8:"deadline"` // Real-time encoding
-// 	Bitrate  string `vp8:"b"`        // Target bitrate
-// 	MinRate  string `vp8:"minrate"`  // Minimum bitrate
-// 	MaxRate  string `vp8:"maxrate"`  // Maximum bitrate
-// 	BufSize  string `vp8:"bufsize"`  // Buffer size
-// 	CRF      string `vp8:"crf"`      // Quality setting
-// 	CPUUsed  string `vp8:"cpu-used"` // Speed preset
-// }
-//
-// var DefaultVP8Settings = VP8Settings{
-// 	Deadline: "1",     // Real-time
-// 	Bitrate:  "2500k", // 2.5 Mbps
-// 	MinRate:  "2000k", // Min 2 Mbps
-// 	MaxRate:  "3000k", // Max 3 Mbps
-// 	BufSize:  "500k",  // 500kb buffer
-// 	CRF:      "10",    // Good quality
-// 	CPUUsed:  "8",     // Fastest
-// }
-//
-// func (s VP8Settings) ForEach(fn func(key, value string) error) error {
-// 	t := reflect.TypeOf(s)
-// 	v := reflect.ValueOf(s)
-//
-// 	for i := 0; i < t.NumField(); i++ {
-// 		field := t.Field(i)
-// 		tag := field.Tag.Get("vp8")
-// 		if tag != "" {
-// 			if err := fn(tag, v.Field(i).String()); err != nil {
-// 				return err
-// 			}
-// 		}
-// 	}
-//
-// 	return nil
-// }
@@ -0,0 +1,65 @@
+package transcode
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/harshabose/tools/buffer/pkg"
+)
+
+// endOfStream marks the end of a stage's output in-band. After a stage has drained its codec or filter graph, it
+// pushes the marker into its buffer behind the last item; the consumer therefore receives every buffered item before
+// GetPacket/GetFrame starts returning ErrorEndOfStream. The marker is generated from the buffer, and the consumer puts
+// it back once it has seen it.
+type endOfStream[T any] struct {
+	marker  atomic.Pointer[T]
+	pushed  bool
+	reached atomic.Bool
+	mux     sync.Mutex
+}
+
+func newEndOfStream[T any]() *endOfStream[T] {
+	return &endOfStream[T]{}
+}
+
+// push blocks until the marker is in the buffer or the context is done. Once the marker is in, further pushes do
+// nothing.
+func (e *endOfStream[T]) push(ctx context.Context, buffer buffer.BufferWithGenerator[T]) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if e.pushed {
+		return nil
+	}
+
+	marker := buffer.Generate()
+	e.marker.Store(marker)
+	if err := buffer.Push(ctx, marker); err != nil {
+		e.marker.Store(nil)
+		buffer.PutBack(marker)
+		return err
+	}
+
+	e.pushed = true
+	return nil
+}
+
+func (e *endOfStream[T]) pop(ctx context.Context, buffer buffer.BufferWithGenerator[T]) (*T, error) {
+	if e.reached.Load() {
+		return nil, ErrorEndOfStream
+	}
+
+	item, err := buffer.Pop(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if marker := e.marker.Load(); marker != nil && item == marker {
+		e.reached.Store(true)
+		buffer.PutBack(item)
+		return nil, ErrorEndOfStream
+	}
+
+	return item, nil
+}
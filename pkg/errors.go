@@ -1,6 +1,10 @@
 package transcode
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"io"
+)
 
 var (
 	ErrorAllocateFormatContext = errors.New("error allocate format context")
@@ -9,6 +13,13 @@ var (
 	ErrorGeneralAllocate       = errors.New("error allocating general object")
 	ErrorNoVideoStreamFound    = errors.New("no video stream found")
 	ErrorInterfaceMismatch     = errors.New("interface mismatch")
+	ErrorStreamBufferFull      = errors.New("error stream buffer is full; packet dropped")
+
+	// ErrorEndOfStream is returned by GetPacket/GetFrame once a stage is fully drained. It wraps io.EOF.
+	ErrorEndOfStream = fmt.Errorf("end of stream: %w", io.EOF)
+
+	ErrorReconnectMaxAttempts       = errors.New("error reconnecting input; maximum attempts reached")
+	ErrorReconnectParametersChanged = errors.New("error reconnecting input; codec parameters changed")
 
 	ErrorNoCodecFound         = errors.New("error no codec found")
 	ErrorAllocateCodecContext = errors.New("error allocating codec context")
@@ -17,11 +28,42 @@ var (
 	ErrorNoFilterName           = errors.New("error filter name does not exists")
 	WarnNoFilterContent         = errors.New("content is empty. no filtering will be done")
 	ErrorGraphParse             = errors.New("error parsing the filter graph")
+	ErrorUnknownFilterOption    = errors.New("error unknown filter option")
+	ErrorInvalidFilterOption    = errors.New("error invalid filter option")
+	ErrorInvalidFilterLabel     = errors.New("error invalid filter label")
+	ErrorInvalidFilterInput     = errors.New("error invalid filter input")
+	ErrorInvalidFilterOutput    = errors.New("error invalid filter output")
+	ErrorVideoOutputReplaced    = errors.New("error video output request replaced by a newer one")
+	ErrorFilterNotRunning       = errors.New("error filter is not running")
+	ErrorFilterOutputBufferFull = errors.New("error filter output buffer is full; frame dropped")
+	ErrorFilterCommand          = errors.New("error running filter command")
 	ErrorGraphConfigure         = errors.New("error configuring the filter graph")
 	ErrorSrcContextSetParameter = errors.New("error while setting parameters to source context")
 	ErrorSrcContextInitialise   = errors.New("error initialising the source context")
 	ErrorAllocSrcContext        = errors.New("error setting source context")
 	ErrorAllocSinkContext       = errors.New("error setting sink context")
 
-	ErrorCodecNoSetting = errors.New("error no settings given")
+	ErrorCodecNoSetting          = errors.New("error no settings given")
+	ErrorReconfigureNotSupported = errors.New("error encoder does not support in-place reconfiguration")
+	ErrorUnknownCodecOption      = errors.New("error unknown codec option")
+	ErrorInvalidCodecOption      = errors.New("error invalid codec option")
+
+	ErrorBitstreamFormatNotSupported = errors.New("error bitstream format not supported for codec")
+	ErrorNoBitstreamFilter           = errors.New("error no bitstream filter found")
+	ErrorParseParameterSets          = errors.New("error parsing the parameter sets of the extradata")
+
+	ErrorPassthroughCodecMismatch = errors.New("error encoder codec differs from the passthrough source codec")
+
+	ErrorNoMAVLinkEndpoint = errors.New("error no mavlink endpoint given")
+	ErrorNoFilterToUpdate  = errors.New("error no filter to update")
+
+	ErrorRTPCodecNotSupported = errors.New("error codec not supported for rtp packetization")
+	ErrorRTPMTUTooSmall       = errors.New("error mtu too small for rtp packets")
+
+	ErrorAllocateOutputFormatContext = errors.New("error allocate output format context")
+	ErrorAllocateStream              = errors.New("error allocating output stream")
+	ErrorOpenOutputContainer         = errors.New("error opening output container")
+	ErrorOutputConsumedByMuxer       = errors.New("error output is consumed by the muxer")
+	ErrorWritePacket                 = errors.New("error writing packet to container")
+	ErrorWriteTrailer                = errors.New("error writing trailer to container")
 )
@@ -2,7 +2,11 @@ package transcode
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/asticode/go-astiav"
@@ -13,15 +17,30 @@ import (
 )
 
 type GeneralFilter struct {
-	content          string
+	content          *FilterChain
+	adaptation       []*FilterNode
 	decoder          CanProduceMediaFrame
 	buffer           buffer.BufferWithGenerator[astiav.Frame]
+	filterSrc        *astiav.Filter
+	filterSink       *astiav.Filter
 	graph            *astiav.FilterGraph
 	input            *astiav.FilterInOut
 	output           *astiav.FilterInOut
 	srcContext       *astiav.BuffersrcFilterContext
 	sinkContext      *astiav.BuffersinkFilterContext
-	srcContextParams *astiav.BuffersrcFilterContextParameters // NOTE: THIS BECOMES NIL AFTER INITIALISATION
+	srcContextParams *astiav.BuffersrcFilterContextParameters // NOTE: KEPT UNTIL CLOSE; THE GRAPH CAN BE REBUILT
+	timeBase         astiav.Rational
+	pendingOutput    atomic.Pointer[videoOutputRequest]
+	commands         chan *filterCommand
+	started          atomic.Bool
+	commandsStopped  chan struct{} // NOTE: CLOSED ONCE THE LOOP NO LONGER RUNS COMMANDS
+	stopCommands     sync.Once
+	onError          func(error)
+	queued           []*filterCommand // NOTE: OWNED BY THE LOOP
+	applied          []*filterCommand // NOTE: OWNED BY THE LOOP
+	updators         []FilterUpdator
+	eos              *endOfStream[astiav.Frame]
+	mux              sync.RWMutex
 	ctx              context.Context
 	cancel           context.CancelFunc
 }
@@ -29,39 +48,27 @@ type GeneralFilter struct {
 func CreateGeneralFilter(ctx context.Context, canProduceMediaFrame CanProduceMediaFrame, filterConfig FilterConfig, options ...FilterOption) (*GeneralFilter, error) {
 	ctx2, cancel := context.WithCancel(ctx)
 	filter := &GeneralFilter{
-		graph:            astiav.AllocFilterGraph(),
 		decoder:          canProduceMediaFrame,
-		input:            astiav.AllocFilterInOut(),
-		output:           astiav.AllocFilterInOut(),
+		content:          NewFilterChain(),
 		srcContextParams: astiav.AllocBuffersrcFilterContextParameters(),
+		commands:         make(chan *filterCommand, filterCommandsSize),
+		commandsStopped:  make(chan struct{}),
+		applied:          make([]*filterCommand, 0),
+		eos:              newEndOfStream[astiav.Frame](),
 		ctx:              ctx2,
 		cancel:           cancel,
 	}
 
 	// TODO: CHECK IF ALL ATTRIBUTES ARE ALLOCATED PROPERLY
 
-	filterSrc := astiav.FindFilterByName(filterConfig.Source.String())
-	if filterSrc == nil {
+	if filter.filterSrc = astiav.FindFilterByName(filterConfig.Source.String()); filter.filterSrc == nil {
 		return nil, ErrorNoFilterName
 	}
 
-	filterSink := astiav.FindFilterByName(filterConfig.Sink.String())
-	if filterSink == nil {
+	if filter.filterSink = astiav.FindFilterByName(filterConfig.Sink.String()); filter.filterSink == nil {
 		return nil, ErrorNoFilterName
 	}
 
-	srcContext, err := filter.graph.NewBuffersrcFilterContext(filterSrc, "in")
-	if err != nil {
-		return nil, ErrorAllocSrcContext
-	}
-	filter.srcContext = srcContext
-
-	sinkContext, err := filter.graph.NewBuffersinkFilterContext(filterSink, "out")
-	if err != nil {
-		return nil, ErrorAllocSinkContext
-	}
-	filter.sinkContext = sinkContext
-
 	canDescribeMediaFrame, ok := canProduceMediaFrame.(CanDescribeMediaFrame)
 	if !ok {
 		return nil, ErrorInterfaceMismatch
@@ -74,7 +81,7 @@ func CreateGeneralFilter(ctx context.Context, canProduceMediaFrame CanProduceMed
 	}
 
 	for _, option := range options {
-		if err = option(filter); err != nil {
+		if err := option(filter); err != nil {
 			// TODO: SET CONTENT HERE
 			return nil, err
 		}
@@ -84,57 +91,121 @@ func CreateGeneralFilter(ctx context.Context, canProduceMediaFrame CanProduceMed
 		filter.buffer = buffer.CreateChannelBuffer(ctx, 256, internal.CreateFramePool())
 	}
 
-	if err = filter.srcContext.SetParameters(filter.srcContextParams); err != nil {
-		return nil, ErrorSrcContextSetParameter
+	if len(filter.content.Nodes()) == 0 {
+		fmt.Println(WarnNoFilterContent)
 	}
 
-	if err = filter.srcContext.Initialize(astiav.NewDictionary()); err != nil {
-		return nil, ErrorSrcContextInitialise
+	if err := filter.buildGraph(); err != nil {
+		return nil, err
 	}
+	filter.timeBase = filter.sinkContext.TimeBase()
 
-	filter.output.SetName("in")
-	filter.output.SetFilterContext(filter.srcContext.FilterContext())
-	filter.output.SetPadIdx(0)
-	filter.output.SetNext(nil)
+	return filter, nil
+}
 
-	filter.input.SetName("out")
-	filter.input.SetFilterContext(filter.sinkContext.FilterContext())
-	filter.input.SetPadIdx(0)
-	filter.input.SetNext(nil)
+// buildGraph allocates and configures a filter graph from the source parameters, the filter content and the
+// adaptation stage (see SetVideoOutput). On success it replaces the current graph; the caller frees the old one.
+func (filter *GeneralFilter) buildGraph() error {
+	content := filter.graphContent()
+	if err := content.Validate(); err != nil {
+		return err
+	}
 
-	if filter.content == "" {
-		fmt.Println(WarnNoFilterContent)
+	var (
+		graph  = astiav.AllocFilterGraph()
+		input  = astiav.AllocFilterInOut()
+		output = astiav.AllocFilterInOut()
+		err    error
+	)
+
+	freeAll := func() {
+		graph.Free()
+		input.Free()
+		output.Free()
 	}
 
-	if err = filter.graph.Parse(filter.content, filter.input, filter.output); err != nil {
-		return nil, ErrorGraphParse
+	srcContext, err := graph.NewBuffersrcFilterContext(filter.filterSrc, "in")
+	if err != nil {
+		freeAll()
+		return ErrorAllocSrcContext
 	}
 
-	if err = filter.graph.Configure(); err != nil {
-		return nil, ErrorGraphConfigure
+	sinkContext, err := graph.NewBuffersinkFilterContext(filter.filterSink, "out")
+	if err != nil {
+		freeAll()
+		return ErrorAllocSinkContext
 	}
 
-	if filter.srcContextParams != nil {
-		filter.srcContextParams.Free()
+	if err = srcContext.SetParameters(filter.srcContextParams); err != nil {
+		freeAll()
+		return ErrorSrcContextSetParameter
 	}
 
-	return filter, nil
+	if err = srcContext.Initialize(astiav.NewDictionary()); err != nil {
+		freeAll()
+		return ErrorSrcContextInitialise
+	}
+
+	output.SetName("in")
+	output.SetFilterContext(srcContext.FilterContext())
+	output.SetPadIdx(0)
+	output.SetNext(nil)
+
+	input.SetName("out")
+	input.SetFilterContext(sinkContext.FilterContext())
+	input.SetPadIdx(0)
+	input.SetNext(nil)
+
+	if err = graph.Parse(content.String(), input, output); err != nil {
+		freeAll()
+		return ErrorGraphParse
+	}
+
+	if err = graph.Configure(); err != nil {
+		freeAll()
+		return ErrorGraphConfigure
+	}
+
+	filter.mux.Lock()
+	filter.graph, filter.input, filter.output = graph, input, output
+	filter.srcContext, filter.sinkContext = srcContext, sinkContext
+	filter.mux.Unlock()
+
+	return nil
+}
+
+// graphContent is the filter content followed by the adaptation stage, as one chain from "in" to "out".
+func (filter *GeneralFilter) graphContent() *FilterGraphBuilder {
+	nodes := append(append([]*FilterNode{}, filter.content.Nodes()...), filter.adaptation...)
+	return NewFilterGraphBuilder(NewFilterChain(nodes...))
 }
 
 func (filter *GeneralFilter) Ctx() context.Context {
 	return filter.ctx
 }
 
+func (filter *GeneralFilter) AddUpdator(updator FilterUpdator) {
+	filter.updators = append(filter.updators, updator)
+}
+
 func (filter *GeneralFilter) Start() {
+	filter.started.Store(true)
 	go filter.loop()
+	for _, updator := range filter.updators {
+		updator.Start(filter)
+	}
 }
 
 func (filter *GeneralFilter) Stop() {
+	for _, updator := range filter.updators {
+		updator.Stop()
+	}
 	filter.cancel()
 }
 
 func (filter *GeneralFilter) loop() {
 	defer filter.close()
+	defer filter.stopRunningCommands()
 
 loop1:
 	for {
@@ -142,11 +213,20 @@ loop1:
 		case <-filter.ctx.Done():
 			return
 		default:
+			filter.applyVideoOutput()
+			filter.applyCommands()
+
 			srcFrame, err := filter.getFrame()
 			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					filter.stopRunningCommands()
+					filter.drain()
+					return
+				}
 				// fmt.Println("unable to get frame from decoder; err:", err.Error())
 				continue
 			}
+			filter.applyQueuedCommands(srcFrame)
 			if err := filter.srcContext.AddFrame(srcFrame, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
 				filter.buffer.PutBack(srcFrame)
 				continue loop1
@@ -169,6 +249,41 @@ loop1:
 	}
 }
 
+// drain closes the buffer source, flushes the frames buffered inside the filter graph and signals the end of stream
+// downstream. It then waits for the filter to be stopped, so the filter stays describable until then.
+func (filter *GeneralFilter) drain() {
+	if err := filter.flush(); err != nil {
+		return
+	}
+
+	if err := filter.eos.push(filter.ctx, filter.buffer); err != nil {
+		return
+	}
+
+	<-filter.ctx.Done()
+}
+
+// flush closes the buffer source and pushes every frame still inside the filter graph. It only fails if the filter is
+// stopped while pushing.
+func (filter *GeneralFilter) flush() error {
+	if err := filter.srcContext.AddFrame(nil, astiav.NewBuffersrcFlags()); err != nil {
+		return nil
+	}
+
+	for {
+		sinkFrame := filter.buffer.Generate()
+		if err := filter.sinkContext.GetFrame(sinkFrame, astiav.NewBuffersinkFlags()); err != nil {
+			filter.buffer.PutBack(sinkFrame)
+			return nil
+		}
+
+		if err := filter.buffer.Push(filter.ctx, sinkFrame); err != nil {
+			filter.buffer.PutBack(sinkFrame)
+			return err
+		}
+	}
+}
+
 func (filter *GeneralFilter) pushFrame(frame *astiav.Frame) error {
 	ctx, cancel := context.WithTimeout(filter.ctx, 50*time.Millisecond)
 	defer cancel()
@@ -188,10 +303,18 @@ func (filter *GeneralFilter) PutBack(frame *astiav.Frame) {
 }
 
 func (filter *GeneralFilter) GetFrame(ctx context.Context) (*astiav.Frame, error) {
-	return filter.buffer.Pop(ctx)
+	return filter.eos.pop(ctx, filter.buffer)
 }
 
 func (filter *GeneralFilter) close() {
+	filter.freeGraph()
+
+	if filter.srcContextParams != nil {
+		filter.srcContextParams.Free()
+	}
+}
+
+func (filter *GeneralFilter) freeGraph() {
 	if filter.graph != nil {
 		filter.graph.Free()
 	}
@@ -207,8 +330,16 @@ func (filter *GeneralFilter) SetBuffer(buffer buffer.BufferWithGenerator[astiav.
 	filter.buffer = buffer
 }
 
+// AddToFilterContent appends raw filter graph syntax to the filter content. Leading and trailing commas are dropped;
+// the chain puts them back between nodes.
 func (filter *GeneralFilter) AddToFilterContent(content string) {
-	filter.content += content
+	if content = strings.Trim(content, ", "); content != "" {
+		filter.content.Append(RawFilterNode(content))
+	}
+}
+
+func (filter *GeneralFilter) AddFilterNode(nodes ...*FilterNode) {
+	filter.content.Append(nodes...)
 }
 
 func (filter *GeneralFilter) SetFrameRate(describe CanDescribeFrameRate) {
@@ -255,50 +386,61 @@ func (filter *GeneralFilter) SetChannelLayout(describe CanDescribeMediaAudioFram
 	filter.srcContextParams.SetChannelLayout(describe.ChannelLayout())
 }
 
+// ## CanDescribeMediaFrame
+
+// NOTE: THE SINK CONTEXT IS REPLACED WHEN THE GRAPH IS REBUILT; HENCE THE LOCK
+
+func (filter *GeneralFilter) sink() *astiav.BuffersinkFilterContext {
+	filter.mux.RLock()
+	defer filter.mux.RUnlock()
+
+	return filter.sinkContext
+}
+
 func (filter *GeneralFilter) MediaType() astiav.MediaType {
-	return filter.sinkContext.MediaType()
+	return filter.sink().MediaType()
 }
 
 func (filter *GeneralFilter) FrameRate() astiav.Rational {
-	return filter.sinkContext.FrameRate()
+	return filter.sink().FrameRate()
 }
 
 func (filter *GeneralFilter) TimeBase() astiav.Rational {
-	return filter.sinkContext.TimeBase()
+	return filter.sink().TimeBase()
 }
 
 func (filter *GeneralFilter) Height() int {
-	return filter.sinkContext.Height()
+	return filter.sink().Height()
 }
 
 func (filter *GeneralFilter) Width() int {
-	return filter.sinkContext.Width()
+	return filter.sink().Width()
 }
 
 func (filter *GeneralFilter) PixelFormat() astiav.PixelFormat {
-	return filter.sinkContext.PixelFormat()
+	return filter.sink().PixelFormat()
 }
 
 func (filter *GeneralFilter) SampleAspectRatio() astiav.Rational {
-	return filter.sinkContext.SampleAspectRatio()
+	return filter.sink().SampleAspectRatio()
 }
 
 func (filter *GeneralFilter) ColorSpace() astiav.ColorSpace {
-	return filter.sinkContext.ColorSpace()
+	return filter.sink().ColorSpace()
 }
 
 func (filter *GeneralFilter) ColorRange() astiav.ColorRange {
-	return filter.sinkContext.ColorRange()
+	return filter.sink().ColorRange()
 }
 
 func (filter *GeneralFilter) SampleRate() int {
-	return filter.sinkContext.SampleRate()
+	return filter.sink().SampleRate()
 }
 
 func (filter *GeneralFilter) SampleFormat() astiav.SampleFormat {
-	return filter.sinkContext.SampleFormat()
+	return filter.sink().SampleFormat()
 }
 
 func (filter *GeneralFilter) ChannelLayout() astiav.ChannelLayout {
-	return filter.sinkContext.ChannelLayout()
+	return filter.sink().ChannelLayout()
 }
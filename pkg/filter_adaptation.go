@@ -0,0 +1,99 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+)
+
+// VideoOutput is the size and frame rate the adaptation stage at the end of a video GeneralFilter scales to. The zero
+// value removes the stage.
+type VideoOutput struct {
+	Width  uint16
+	Height uint16
+	FPS    uint8
+}
+
+type videoOutputRequest struct {
+	output VideoOutput
+	done   chan error
+}
+
+// SetVideoOutput rebuilds the filter graph with a scale/fps stage appended to the filter content. The rebuild is done
+// by the filtering loop between two frames, after flushing the frames still inside the old graph; SetVideoOutput
+// blocks until then. The output time base is kept, so downstream stages do not need to be told. A request that is
+// still pending when a newer one comes in fails with ErrorVideoOutputReplaced.
+func (filter *GeneralFilter) SetVideoOutput(ctx context.Context, output VideoOutput) error {
+	request := &videoOutputRequest{
+		output: output,
+		done:   make(chan error, 1),
+	}
+
+	if replaced := filter.pendingOutput.Swap(request); replaced != nil {
+		replaced.done <- ErrorVideoOutputReplaced
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-filter.ctx.Done():
+		return filter.ctx.Err()
+	case err := <-request.done:
+		return err
+	}
+}
+
+func (filter *GeneralFilter) applyVideoOutput() {
+	request := filter.pendingOutput.Swap(nil)
+	if request == nil {
+		return
+	}
+
+	adaptation := filter.adaptationContent(request.output)
+	if NewFilterChain(adaptation...).String() == NewFilterChain(filter.adaptation...).String() {
+		request.done <- nil
+		return
+	}
+
+	if err := filter.flush(); err != nil {
+		request.done <- err
+		return
+	}
+
+	graph, input, output := filter.graph, filter.input, filter.output
+	previous := filter.adaptation
+	filter.adaptation = adaptation
+
+	err := filter.buildGraph()
+	if err != nil {
+		// NOTE: THE OLD GRAPH WAS FLUSHED; BUILD IT AGAIN TO KEEP FILTERING
+		filter.adaptation = previous
+		if err := filter.buildGraph(); err != nil {
+			filter.cancel() // NOTE: THE OLD GRAPH IS STILL THE CURRENT ONE AND IS FREED ON CLOSE
+			request.done <- err
+			return
+		}
+	}
+
+	filter.replayCommands()
+
+	graph.Free()
+	input.Free()
+	output.Free()
+	request.done <- err
+}
+
+func (filter *GeneralFilter) adaptationContent(output VideoOutput) []*FilterNode {
+	var stages []*FilterNode
+
+	if output.Width > 0 && output.Height > 0 {
+		stages = append(stages, NewFilterNode("scale").Set("w", output.Width).Set("h", output.Height))
+	}
+	if output.FPS > 0 {
+		// NOTE: settb KEEPS THE ORIGINAL TIME BASE; fps WOULD OTHERWISE SET IT TO 1/FPS
+		stages = append(stages,
+			NewFilterNode("fps").Set("fps", output.FPS),
+			NewFilterNode("settb").Set("expr", fmt.Sprintf("%d/%d", filter.timeBase.Num(), filter.timeBase.Den())))
+	}
+
+	return stages
+}
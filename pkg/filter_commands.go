@@ -0,0 +1,175 @@
+package transcode
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// filterCommandsSize bounds how many commands can wait for the filtering loop before SendCommand and QueueCommand
+// block.
+const filterCommandsSize = 32
+
+type filterCommand struct {
+	target string
+	cmd    string
+	arg    string
+	at     time.Duration
+	queued bool
+	done   chan filterCommandResult // NOTE: NIL FOR QUEUED COMMANDS; NOBODY WAITS FOR THEM
+}
+
+type filterCommandResult struct {
+	response string
+	err      error
+}
+
+// key identifies what a command changes, so only the latest value is replayed after a rebuild.
+func (c *filterCommand) key() string {
+	return c.target + "\x00" + c.cmd
+}
+
+// SendCommand sends a command to the filters of the graph, e.g. SendCommand("highpass@hp", "frequency", "120") for
+// the filter added by WithAudioHighPassFilterContent with id "hp". The target is either "all", a filter name or a
+// filter instance tagged with @id. The command is run by the filtering loop between two frames; SendCommand blocks
+// until then and returns the response of the filter. Successful commands are sent again when the graph is rebuilt.
+// Before the filter is started, and once it is draining at the end of stream, it returns ErrorFilterNotRunning.
+func (filter *GeneralFilter) SendCommand(target, cmd, arg string) (string, error) {
+	if !filter.started.Load() {
+		return "", ErrorFilterNotRunning
+	}
+
+	command := &filterCommand{
+		target: target,
+		cmd:    cmd,
+		arg:    arg,
+		done:   make(chan filterCommandResult, 1),
+	}
+
+	if err := filter.pushCommand(command); err != nil {
+		return "", err
+	}
+
+	select {
+	case <-filter.ctx.Done():
+		return "", filter.ctx.Err()
+	case <-filter.commandsStopped:
+		return "", ErrorFilterNotRunning
+	case result := <-command.done:
+		return result.response, result.err
+	}
+}
+
+// QueueCommand sends a command to the filters of the graph right before the first frame at or after at, on the
+// timeline of the frames going into the filter. It returns once the command is queued; commands that fail are
+// passed to the handler of WithFilterErrorHandler.
+func (filter *GeneralFilter) QueueCommand(at time.Duration, target, cmd, arg string) error {
+	return filter.pushCommand(&filterCommand{
+		target: target,
+		cmd:    cmd,
+		arg:    arg,
+		at:     at,
+		queued: true,
+	})
+}
+
+func (filter *GeneralFilter) pushCommand(command *filterCommand) error {
+	select {
+	case <-filter.ctx.Done():
+		return filter.ctx.Err()
+	case <-filter.commandsStopped:
+		return ErrorFilterNotRunning
+	case filter.commands <- command:
+		return nil
+	}
+}
+
+// stopRunningCommands fails the commands that are sent from now on, or still wait for the loop.
+func (filter *GeneralFilter) stopRunningCommands() {
+	filter.stopCommands.Do(func() {
+		close(filter.commandsStopped)
+	})
+}
+
+func (filter *GeneralFilter) SetErrorHandler(handler func(error)) {
+	filter.onError = handler
+}
+
+// reportError passes an error no caller can get to the handler of WithFilterErrorHandler, if there is one.
+func (filter *GeneralFilter) reportError(err error) {
+	if filter.onError != nil {
+		filter.onError(err)
+	}
+}
+
+// applyCommands runs the commands sent since the last frame, and keeps the queued ones in order of their time.
+func (filter *GeneralFilter) applyCommands() {
+	for {
+		select {
+		case command := <-filter.commands:
+			if command.queued {
+				filter.queued = append(filter.queued, command)
+				sort.SliceStable(filter.queued, func(i, j int) bool {
+					return filter.queued[i].at < filter.queued[j].at
+				})
+				continue
+			}
+
+			response, err := filter.runCommand(command)
+			command.done <- filterCommandResult{response: response, err: err}
+		default:
+			return
+		}
+	}
+}
+
+// applyQueuedCommands runs the queued commands that are due before the frame goes into the graph.
+func (filter *GeneralFilter) applyQueuedCommands(frame *astiav.Frame) {
+	if len(filter.queued) == 0 || frame.Pts() == astiav.NoPtsValue {
+		return
+	}
+
+	at := time.Duration(astiav.RescaleQ(frame.Pts(), filter.srcContextParams.TimeBase(), astiav.NewRational(1, int(time.Second))))
+
+	for len(filter.queued) > 0 && filter.queued[0].at <= at {
+		command := filter.queued[0]
+		filter.queued = filter.queued[1:]
+
+		if _, err := filter.runCommand(command); err != nil {
+			filter.reportError(fmt.Errorf("%w '%s %s %s' at %s: %v", ErrorFilterCommand, command.target, command.cmd, command.arg, command.at, err))
+		}
+	}
+}
+
+func (filter *GeneralFilter) runCommand(command *filterCommand) (string, error) {
+	response, err := filter.graph.SendCommand(command.target, command.cmd, command.arg, astiav.NewFilterCommandFlags())
+	if err != nil {
+		return response, err
+	}
+
+	filter.remember(command)
+	return response, nil
+}
+
+// remember keeps the command for replaying, in place of an earlier one with the same key.
+func (filter *GeneralFilter) remember(command *filterCommand) {
+	for i, applied := range filter.applied {
+		if applied.key() == command.key() {
+			filter.applied[i] = command
+			return
+		}
+	}
+	filter.applied = append(filter.applied, command)
+}
+
+// replayCommands sends the commands applied so far to a rebuilt graph, which starts from the filter content again.
+// They are sent in the order they were first applied, so commands touching the same parameter end up the same way.
+func (filter *GeneralFilter) replayCommands() {
+	for _, command := range filter.applied {
+		if _, err := filter.graph.SendCommand(command.target, command.cmd, command.arg, astiav.NewFilterCommandFlags()); err != nil {
+			filter.reportError(fmt.Errorf("%w '%s %s %s' on the rebuilt graph: %v", ErrorFilterCommand, command.target, command.cmd, command.arg, err))
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/asticode/go-astiav"
+)
+
+type filterNodeOption struct {
+	key   string // NOTE: EMPTY FOR POSITIONAL ARGUMENTS
+	value string
+}
+
+// FilterNode is one filter of a filter graph with its options, e.g.
+// NewFilterNode("bandreject").WithID("n0").Set("frequency", 120).Set("width_type", "q").Set("width", 4). Values are
+// escaped when the node is serialised.
+type FilterNode struct {
+	name    string
+	id      string
+	options []filterNodeOption
+	raw     string
+}
+
+func NewFilterNode(name string) *FilterNode {
+	return &FilterNode{name: name}
+}
+
+// RawFilterNode wraps already serialised filter graph syntax, e.g. "scale=640:480,fps=30". It is validated as a whole;
+// prefer NewFilterNode so errors can point at an option.
+func RawFilterNode(content string) *FilterNode {
+	return &FilterNode{raw: content}
+}
+
+// WithID tags the filter instance so commands can target it as name@id; see GeneralFilter.SendCommand.
+func (node *FilterNode) WithID(id string) *FilterNode {
+	node.id = id
+	return node
+}
+
+// Set adds a named option. Numbers are written without rounding and fmt.Stringer values (e.g. astiav.PixelFormat)
+// through String.
+func (node *FilterNode) Set(key string, value any) *FilterNode {
+	node.options = append(node.options, filterNodeOption{key: key, value: formatFilterValue(value)})
+	return node
+}
+
+// Arg adds a positional option, taken by the filter in the order of its options.
+func (node *FilterNode) Arg(value any) *FilterNode {
+	return node.Set("", value)
+}
+
+func (node *FilterNode) Name() string {
+	return node.name
+}
+
+// Instance is the name commands use to target this node: name@id when tagged, otherwise the filter name.
+func (node *FilterNode) Instance() string {
+	if node.id == "" {
+		return node.name
+	}
+	return node.name + "@" + node.id
+}
+
+func (node *FilterNode) String() string {
+	if node.raw != "" {
+		return node.raw
+	}
+	return node.serialise(node.options)
+}
+
+func (node *FilterNode) serialise(options []filterNodeOption) string {
+	if len(options) == 0 {
+		return node.Instance()
+	}
+
+	args := make([]string, 0, len(options))
+	for _, option := range options {
+		value := escapeFilterValue(option.value, `\':`)
+		if option.key == "" {
+			args = append(args, value)
+			continue
+		}
+		args = append(args, option.key+"="+value)
+	}
+
+	// NOTE: THE ARGUMENTS ARE UNESCAPED TWICE; ONCE BY THE GRAPH PARSER AND ONCE BY THE OPTION PARSER
+	return node.Instance() + "=" + escapeFilterValue(strings.Join(args, ":"), `\'[],;`)
+}
+
+func formatFilterValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func escapeFilterValue(value string, special string) string {
+	if !strings.ContainsAny(value, special) {
+		return value
+	}
+
+	var builder strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(special, r) {
+			builder.WriteByte('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// validate checks the node against libavfilter: the filter has to exist and accept every option. It creates the node
+// on its own in a scratch graph, so it does not need the pads to be connected.
+func (node *FilterNode) validate() error {
+	if node.raw != "" {
+		if err := parseFilterNodes(node.raw); err != nil {
+			return fmt.Errorf("%w: %s", ErrorInvalidFilterOption, err.Error())
+		}
+		return nil
+	}
+
+	if astiav.FindFilterByName(node.name) == nil {
+		return fmt.Errorf("%w: %s", ErrorNoFilterName, node.name)
+	}
+	if node.id != "" && !validFilterLabel(node.id) {
+		return fmt.Errorf("%w: id '%s'", ErrorInvalidFilterLabel, node.id)
+	}
+
+	err := parseFilterNodes(node.String())
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, astiav.ErrOptionNotFound) {
+		return fmt.Errorf("%w: %s", ErrorInvalidFilterOption, err.Error())
+	}
+
+	// NOTE: POSITIONAL ARGUMENTS DEPEND ON WHAT COMES BEFORE THEM; GROW THE OPTIONS UNTIL ONE IS REJECTED
+	for i := range node.options {
+		if err := parseFilterNodes(node.serialise(node.options[:i+1])); errors.Is(err, astiav.ErrOptionNotFound) {
+			if node.options[i].key == "" {
+				return fmt.Errorf("%w: argument %d", ErrorUnknownFilterOption, i)
+			}
+			return fmt.Errorf("%w: %s", ErrorUnknownFilterOption, node.options[i].key)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrorUnknownFilterOption, err.Error())
+}
+
+func parseFilterNodes(content string) error {
+	graph := astiav.AllocFilterGraph()
+	defer graph.Free()
+
+	return graph.Parse(content, nil, nil)
+}
+
+func validFilterLabel(label string) bool {
+	return label != "" && !strings.ContainsAny(label, "[]=,;:@'\\ \t\n")
+}
+
+// FilterChain is a linear chain of filters; each node feeds the next. From and To label the input pads of the first
+// node and the output pads of the last one, to link the chain with other chains of the graph.
+type FilterChain struct {
+	inputs  []string
+	outputs []string
+	nodes   []*FilterNode
+}
+
+func NewFilterChain(nodes ...*FilterNode) *FilterChain {
+	return &FilterChain{nodes: nodes}
+}
+
+func (chain *FilterChain) From(labels ...string) *FilterChain {
+	chain.inputs = append(chain.inputs, labels...)
+	return chain
+}
+
+func (chain *FilterChain) To(labels ...string) *FilterChain {
+	chain.outputs = append(chain.outputs, labels...)
+	return chain
+}
+
+func (chain *FilterChain) Append(nodes ...*FilterNode) *FilterChain {
+	chain.nodes = append(chain.nodes, nodes...)
+	return chain
+}
+
+func (chain *FilterChain) Nodes() []*FilterNode {
+	return chain.nodes
+}
+
+func (chain *FilterChain) String() string {
+	if len(chain.nodes) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	for _, label := range chain.inputs {
+		builder.WriteString("[" + label + "]")
+	}
+	for i, node := range chain.nodes {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(node.String())
+	}
+	for _, label := range chain.outputs {
+		builder.WriteString("[" + label + "]")
+	}
+	return builder.String()
+}
+
+// FilterGraphBuilder puts chains together into the filter graph syntax of libavfilter and validates them before the
+// graph is parsed.
+type FilterGraphBuilder struct {
+	chains []*FilterChain
+}
+
+func NewFilterGraphBuilder(chains ...*FilterChain) *FilterGraphBuilder {
+	return &FilterGraphBuilder{chains: chains}
+}
+
+func (builder *FilterGraphBuilder) Chain(chains ...*FilterChain) *FilterGraphBuilder {
+	builder.chains = append(builder.chains, chains...)
+	return builder
+}
+
+func (builder *FilterGraphBuilder) Chains() []*FilterChain {
+	return builder.chains
+}
+
+func (builder *FilterGraphBuilder) String() string {
+	chains := make([]string, 0, len(builder.chains))
+	for _, chain := range builder.chains {
+		if content := chain.String(); content != "" {
+			chains = append(chains, content)
+		}
+	}
+	return strings.Join(chains, ";")
+}
+
+// Validate checks the labels and every node of the graph. The error names the chain and node that failed and wraps
+// ErrorNoFilterName, ErrorUnknownFilterOption, ErrorInvalidFilterOption or ErrorInvalidFilterLabel.
+func (builder *FilterGraphBuilder) Validate() error {
+	for c, chain := range builder.chains {
+		if len(chain.nodes) == 0 && (len(chain.inputs) > 0 || len(chain.outputs) > 0) {
+			return fmt.Errorf("%w: chain %d has labels but no filters", ErrorInvalidFilterLabel, c)
+		}
+
+		for _, label := range append(append([]string{}, chain.inputs...), chain.outputs...) {
+			if !validFilterLabel(label) {
+				return fmt.Errorf("%w: chain %d label '%s'", ErrorInvalidFilterLabel, c, label)
+			}
+		}
+
+		for n, node := range chain.nodes {
+			if err := node.validate(); err != nil {
+				return fmt.Errorf("chain %d node %d '%s': %w", c, n, node.String(), err)
+			}
+		}
+	}
+
+	return nil
+}
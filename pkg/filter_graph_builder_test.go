@@ -0,0 +1,45 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFilterGraphBuilderString(t *testing.T) {
+	graph := NewFilterGraphBuilder(
+		NewFilterChain(
+			NewFilterNode("highpass").WithID("hp").Set("frequency", float32(120)).Set("poles", 2),
+			NewFilterNode("lowpass").WithID("lp").Set("frequency", 3400.5),
+		).From("in0").To("voice"),
+		NewFilterChain(
+			NewFilterNode("drawtext").Set("text", "a:b, [c]"),
+		),
+	)
+
+	want := `[in0]highpass@hp=frequency=120:poles=2,lowpass@lp=frequency=3400.5[voice];drawtext=text=a\\:b\, \[c\]`
+	if got := graph.String(); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestFilterGraphBuilderValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		node *FilterNode
+		want error
+	}{
+		{name: "valid", node: NewFilterNode("highpass").WithID("hp").Set("frequency", 120)},
+		{name: "unknown filter", node: NewFilterNode("highpas"), want: ErrorNoFilterName},
+		{name: "unknown option", node: NewFilterNode("highpass").Set("frequency", 120).Set("pols", 2), want: ErrorUnknownFilterOption},
+		{name: "invalid id", node: NewFilterNode("highpass").WithID("a,b"), want: ErrorInvalidFilterLabel},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewFilterGraphBuilder(NewFilterChain(NewFilterNode("anull"), test.node)).Validate()
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ package transcode
 
 import (
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/asticode/go-astiav"
 
@@ -54,6 +54,77 @@ func WithFilterBufferSize(size int) FilterOption {
 	}
 }
 
+// WithFilterInputSyncDelay sets how long a MultiInputFilter waits for an input that falls behind before the other
+// inputs go on without it.
+func WithFilterInputSyncDelay(delay time.Duration) FilterOption {
+	return func(filter Filter) error {
+		s, ok := filter.(CanSetInputSyncDelay)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		if delay < 0 {
+			return fmt.Errorf("input sync delay cannot be negative")
+		}
+		s.SetInputSyncDelay(delay)
+		return nil
+	}
+}
+
+// WithFilterOutputDropWhenFull lets the MultiInputFilter output with the label drop the frames its consumer has no room
+// for, instead of holding back the other outputs, e.g. a live preview next to a recording. Each dropped frame is
+// counted by FilterOutput.Dropped and reported as an ErrorFilterOutputBufferFull to the handler of
+// WithFilterErrorHandler.
+func WithFilterOutputDropWhenFull(label string) FilterOption {
+	return func(filter Filter) error {
+		s, ok := filter.(CanSetOutputDropWhenFull)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		return s.SetOutputDropWhenFull(label)
+	}
+}
+
+// WithFilterErrorHandler receives the errors the filter cannot return to a caller, like queued commands that fail
+// or commands that cannot be replayed after the graph is rebuilt. The handler is called from the filtering loop and
+// should not block.
+func WithFilterErrorHandler(handler func(error)) FilterOption {
+	return func(filter Filter) error {
+		s, ok := filter.(CanSetErrorHandler)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.SetErrorHandler(handler)
+		return nil
+	}
+}
+
+// WithFilterUpdator starts and stops the updator with the filter, e.g. a PropNoiseFilterUpdator for the notches of
+// WithAudioNotchHarmonicsFilterContent.
+func WithFilterUpdator(updator FilterUpdator) FilterOption {
+	return func(filter Filter) error {
+		a, ok := filter.(CanAddFilterUpdator)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		a.AddUpdator(updator)
+		return nil
+	}
+}
+
+// WithFilterNodes appends nodes to the filter content, e.g.
+// WithFilterNodes(NewFilterNode("hflip"), NewFilterNode("eq").Set("contrast", 1.2)).
+func WithFilterNodes(nodes ...*FilterNode) FilterOption {
+	return func(filter Filter) error {
+		a, ok := filter.(CanAddFilterNode)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+
+		a.AddFilterNode(nodes...)
+		return nil
+	}
+}
+
 func withVideoSetFilterContextParameters(decoder CanDescribeMediaVideoFrame) func(Filter) error {
 	return func(filter Filter) error {
 		canSetMediaVideoFrame, ok := filter.(CanSetMediaVideoFrame)
@@ -77,36 +148,36 @@ func withVideoSetFilterContextParameters(decoder CanDescribeMediaVideoFrame) fun
 
 func WithVideoScaleFilterContent(width, height uint16) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("scale=%d:%d,", width, height))
+		a.AddFilterNode(NewFilterNode("scale").Set("w", width).Set("h", height))
 		return nil
 	}
 }
 
 func WithVideoPixelFormatFilterContent(pixelFormat astiav.PixelFormat) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 		fmt.Println("pixel filter added:", pixelFormat.String())
-		a.AddToFilterContent(fmt.Sprintf("format=pix_fmts=%s,", pixelFormat))
+		a.AddFilterNode(NewFilterNode("format").Set("pix_fmts", pixelFormat))
 		return nil
 	}
 }
 
 func WithVideoFPSFilterContent(fps uint8) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("fps=%d,", fps))
+		a.AddFilterNode(NewFilterNode("fps").Set("fps", fps))
 		return nil
 	}
 }
@@ -130,36 +201,36 @@ func withAudioSetFilterContextParameters(decoder CanDescribeMediaAudioFrame) fun
 
 func WithAudioSampleFormatChannelLayoutFilter(sampleFormat astiav.SampleFormat, channelLayout astiav.ChannelLayout) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("aformat=sample_fmts=%s:channel_layouts=%s", sampleFormat.String(), channelLayout.String()) + ",")
+		a.AddFilterNode(NewFilterNode("aformat").Set("sample_fmts", sampleFormat).Set("channel_layouts", channelLayout))
 		return nil
 	}
 }
 
 func WithAudioSampleRateFilter(samplerate uint32) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("aresample=%d,", samplerate))
+		a.AddFilterNode(NewFilterNode("aresample").Set("sample_rate", samplerate))
 		return nil
 	}
 }
 
 func WithAudioSamplesPerFrameContent(nsamples uint16) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("asetnsamples=%d,", nsamples))
+		a.AddFilterNode(NewFilterNode("asetnsamples").Set("nb_out_samples", nsamples))
 		return nil
 	}
 }
@@ -168,13 +239,16 @@ func WithAudioCompressionContent(threshold int, ratio int, attack float64, relea
 	return func(filter Filter) error {
 		// NOTE: DYNAMIC RANGE COMPRESSION TO HANDLE SUDDEN VOLUME CHANGES
 		// Possible values 'acompressor=threshold=-12dB:ratio=2:attack=0.05:release=0.2" // MOST POPULAR VALUES
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("acompressor=threshold=%ddB:ratio=%d:attack=%.2f:release=%.2f,",
-			threshold, ratio, attack, release))
+		a.AddFilterNode(NewFilterNode("acompressor").
+			Set("threshold", fmt.Sprintf("%ddB", threshold)).
+			Set("ratio", ratio).
+			Set("attack", attack).
+			Set("release", release))
 		return nil
 	}
 }
@@ -183,68 +257,71 @@ func WithAudioHighPassFilterContent(id string, frequency float32, order uint8) F
 	return func(filter Filter) error {
 		// NOTE: HIGH-PASS FILTER TO REMOVE WIND NOISE AND TURBULENCE
 		// NOTE: 120HZ CUTOFF MIGHT PRESERVE VOICE WHILE REMOVING LOW RUMBLE; BUT MORE TESTING IS NEEDED
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("highpass@%s=frequency=%.2f:poles=%d", id, frequency, order))
+		a.AddFilterNode(NewFilterNode("highpass").WithID(id).Set("frequency", frequency).Set("poles", order))
 		return nil
 	}
 }
 
 func WithAudioLowPassFilterContent(id string, frequency float32, order uint8) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("lowpass@%s=frequency=%.2f:poles=%d", id, frequency, order))
+		a.AddFilterNode(NewFilterNode("lowpass").WithID(id).Set("frequency", frequency).Set("poles", order))
 		return nil
 	}
 }
 
 func WithAudioNotchFilterContent(id string, frequency float32, qFactor float32) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("bandreject@%s=frequency=%.2f:width_type=q:width=%.2f", id, frequency, qFactor))
+		a.AddFilterNode(notchFilterNode(id, frequency, qFactor))
 		return nil
 	}
 }
 
 func WithAudioNotchHarmonicsFilterContent(id string, fundamental float32, harmonics uint8, qFactor float32) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		var filters = make([]string, 0)
-
 		for i := uint8(0); i < harmonics; i++ {
-			harmonic := fundamental * float32(i+1)
-			filters = append(filters, fmt.Sprintf("bandreject@%s%d=frequency=%.2f:width_type=q:width=%.2f", id, i, harmonic, qFactor))
+			a.AddFilterNode(notchFilterNode(fmt.Sprintf("%s%d", id, i), fundamental*float32(i+1), qFactor))
 		}
-
-		a.AddToFilterContent(strings.Join(filters, ",") + ",")
 		return nil
 	}
 }
 
+func notchFilterNode(id string, frequency float32, qFactor float32) *FilterNode {
+	return NewFilterNode("bandreject").WithID(id).Set("frequency", frequency).Set("width_type", "q").Set("width", qFactor)
+}
+
 func WithAudioEqualiserFilter(id string, frequency float32, width float32, gain float32) FilterOption {
 	return func(filter Filter) error {
 		// NOTE: EQUALISER CAN BE USED TO ENHANCE SPEECH BANDWIDTH (300 - 3kHz). MORE RESEARCH NEEDS TO DONE
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("equalizer@%s=frequency=%.2f:width_type=h:width=%.2f:gain=%.2f,", id, frequency, width, gain))
+		a.AddFilterNode(NewFilterNode("equalizer").WithID(id).
+			Set("frequency", frequency).
+			Set("width_type", "h").
+			Set("width", width).
+			Set("gain", gain))
 		return nil
 	}
 }
@@ -253,13 +330,16 @@ func WithAudioSilenceGateContent(threshold int, range_ int, attack float64, rele
 	return func(filter Filter) error {
 		// NOTE: IF EVERYTHING WORKS, WE SHOULD HAVE LIGHT NOISE WHICH CAN BE CONSIDERED AS SILENCE. THIS GATE REMOVES SILENCE
 		// NOTE: POSSIBLE VALUES 'agate=threshold=-30dB:range=-30dB:attack=0.01:release=0.1" // MOST POPULAR; MORE TESTING IS NEEDED
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("agate=threshold=%ddB:range=%ddB:attack=%.2f:release=%.2f,",
-			threshold, range_, attack, release))
+		a.AddFilterNode(NewFilterNode("agate").
+			Set("threshold", fmt.Sprintf("%ddB", threshold)).
+			Set("range", fmt.Sprintf("%ddB", range_)).
+			Set("attack", attack).
+			Set("release", release))
 		return nil
 	}
 }
@@ -268,13 +348,12 @@ func WithAudioLoudnessNormaliseContent(intensity int, truePeak float64, range_ i
 	return func(filter Filter) error {
 		// NOTE: NORMALISES THE FINAL AUDIO. MUST BE CALLED AT THE END
 		// NOTE: POSSIBLE VALUES "loudnorm=I=-16:TP=-1.5:LRA=11" // MOST POPULAR
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("loudnorm=I=%d:TP=%.1f:LRA=%d",
-			intensity, truePeak, range_))
+		a.AddFilterNode(NewFilterNode("loudnorm").Set("I", intensity).Set("TP", truePeak).Set("LRA", range_))
 		return nil
 	}
 }
@@ -282,24 +361,22 @@ func WithAudioLoudnessNormaliseContent(intensity int, truePeak float64, range_ i
 func WithFFTBroadBandNoiseFilter(id string, strength float32, rPatch float32, rSearch float32) FilterOption {
 	return func(filter Filter) error {
 		// TODO: NEEDS A UPDATOR TO CONTROL NOISE SAMPLING
-		a, ok := filter.(CanAddToFilterContent)
-		if !ok {
+		if _, ok := filter.(CanAddFilterNode); !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf(""))
 		return nil
 	}
 }
 
 func WithMeanBroadBandNoiseFilter(id string, strength float32, rPatch float32, rSearch float32) FilterOption {
 	return func(filter Filter) error {
-		a, ok := filter.(CanAddToFilterContent)
+		a, ok := filter.(CanAddFilterNode)
 		if !ok {
 			return ErrorInterfaceMismatch
 		}
 
-		a.AddToFilterContent(fmt.Sprintf("anlmdn@%s=strength=%.2f:patch=%.2f:research=%.2f", id, strength, rPatch, rSearch))
+		a.AddFilterNode(NewFilterNode("anlmdn").WithID(id).Set("strength", strength).Set("patch", rPatch).Set("research", rSearch))
 		return nil
 	}
 }
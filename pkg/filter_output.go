@@ -0,0 +1,203 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+// FilterOutput is one buffersink of a MultiInputFilter, linked to the pad with its label in the graph. It has its own
+// buffer and end of stream, and describes its own frames, so each output can feed its own encoder. The filtering loop
+// waits for room in the buffer of every output, so no frame is lost when a consumer falls behind. An output whose
+// frames may be lost rather than slow down the others, like a live preview next to a recording, is set with
+// WithFilterOutputDropWhenFull.
+type FilterOutput struct {
+	label        string
+	filterSink   *astiav.Filter
+	sinkContext  *astiav.BuffersinkFilterContext
+	buffer       buffer.BufferWithGenerator[astiav.Frame]
+	eos          *endOfStream[astiav.Frame]
+	dropWhenFull bool
+	dropped      atomic.Uint64
+	ctx          context.Context
+}
+
+func newFilterOutput(ctx context.Context, label string, filterSink *astiav.Filter) *FilterOutput {
+	return &FilterOutput{
+		label:      label,
+		filterSink: filterSink,
+		eos:        newEndOfStream[astiav.Frame](),
+		ctx:        ctx,
+	}
+}
+
+func (output *FilterOutput) Label() string {
+	return output.label
+}
+
+func (output *FilterOutput) Ctx() context.Context {
+	return output.ctx
+}
+
+func (output *FilterOutput) SetBuffer(buffer buffer.BufferWithGenerator[astiav.Frame]) {
+	output.buffer = buffer
+}
+
+func (output *FilterOutput) setDefaultBuffer(ctx context.Context) {
+	if output.buffer == nil {
+		output.buffer = buffer.CreateChannelBuffer(ctx, 256, internal.CreateFramePool())
+	}
+}
+
+// pull pushes every frame the sink has ready. A frame dropped because the output has no room for it is counted and
+// reported.
+func (output *FilterOutput) pull(report func(error)) {
+	for {
+		sinkFrame := output.buffer.Generate()
+		if err := output.sinkContext.GetFrame(sinkFrame, astiav.NewBuffersinkFlags()); err != nil {
+			output.buffer.PutBack(sinkFrame)
+			return
+		}
+
+		if err := output.pushFrame(sinkFrame); err != nil {
+			output.buffer.PutBack(sinkFrame)
+			if errors.Is(err, ErrorFilterOutputBufferFull) {
+				output.dropped.Add(1)
+				report(fmt.Errorf("%w: output '%s'", err, output.label))
+			}
+		}
+	}
+}
+
+// remaining takes the frames left in the sink once the inputs of the graph are closed.
+func (output *FilterOutput) remaining() []*astiav.Frame {
+	frames := make([]*astiav.Frame, 0)
+	for {
+		sinkFrame := output.buffer.Generate()
+		if err := output.sinkContext.GetFrame(sinkFrame, astiav.NewBuffersinkFlags()); err != nil {
+			output.buffer.PutBack(sinkFrame)
+			return frames
+		}
+		frames = append(frames, sinkFrame)
+	}
+}
+
+// finish pushes the remaining frames, blocking until they are taken, and then the end of stream marker. It does not
+// touch the graph, so outputs can finish side by side.
+func (output *FilterOutput) finish(frames []*astiav.Frame) error {
+	for i, frame := range frames {
+		if err := output.buffer.Push(output.ctx, frame); err != nil {
+			for _, frame := range frames[i:] {
+				output.buffer.PutBack(frame)
+			}
+			return err
+		}
+	}
+
+	return output.eos.push(output.ctx, output.buffer)
+}
+
+// pushFrame hands the frame to the output, waiting for room in its buffer unless the output drops frames when full.
+func (output *FilterOutput) pushFrame(frame *astiav.Frame) error {
+	if !output.dropWhenFull {
+		return output.buffer.Push(output.ctx, frame)
+	}
+
+	channel := output.buffer.GetChannel()
+	if channel == nil {
+		// NOTE: A BUFFER SET WITH SetBuffer MAY NOT BE BACKED BY A CHANNEL; ONLY WAIT BRIEFLY ON IT
+		ctx, cancel := context.WithTimeout(output.ctx, 5*time.Millisecond)
+		defer cancel()
+
+		err := output.buffer.Push(ctx, frame)
+		if err != nil && output.ctx.Err() == nil {
+			return ErrorFilterOutputBufferFull
+		}
+		return err
+	}
+
+	select {
+	case channel <- frame:
+		return nil
+	case <-output.ctx.Done():
+		return output.ctx.Err()
+	default:
+		return ErrorFilterOutputBufferFull
+	}
+}
+
+// SetDropWhenFull makes the output drop the frames its consumer has no room for, instead of holding back the
+// filtering loop and with it the other outputs. It has to be set before the filter is started.
+func (output *FilterOutput) SetDropWhenFull(drop bool) {
+	output.dropWhenFull = drop
+}
+
+// Dropped returns the number of frames the output dropped because its consumer had no room for them.
+func (output *FilterOutput) Dropped() uint64 {
+	return output.dropped.Load()
+}
+
+func (output *FilterOutput) PutBack(frame *astiav.Frame) {
+	output.buffer.PutBack(frame)
+}
+
+func (output *FilterOutput) GetFrame(ctx context.Context) (*astiav.Frame, error) {
+	return output.eos.pop(ctx, output.buffer)
+}
+
+// ## CanDescribeMediaFrame
+
+func (output *FilterOutput) MediaType() astiav.MediaType {
+	return output.sinkContext.MediaType()
+}
+
+func (output *FilterOutput) FrameRate() astiav.Rational {
+	return output.sinkContext.FrameRate()
+}
+
+func (output *FilterOutput) TimeBase() astiav.Rational {
+	return output.sinkContext.TimeBase()
+}
+
+func (output *FilterOutput) Height() int {
+	return output.sinkContext.Height()
+}
+
+func (output *FilterOutput) Width() int {
+	return output.sinkContext.Width()
+}
+
+func (output *FilterOutput) PixelFormat() astiav.PixelFormat {
+	return output.sinkContext.PixelFormat()
+}
+
+func (output *FilterOutput) SampleAspectRatio() astiav.Rational {
+	return output.sinkContext.SampleAspectRatio()
+}
+
+func (output *FilterOutput) ColorSpace() astiav.ColorSpace {
+	return output.sinkContext.ColorSpace()
+}
+
+func (output *FilterOutput) ColorRange() astiav.ColorRange {
+	return output.sinkContext.ColorRange()
+}
+
+func (output *FilterOutput) SampleRate() int {
+	return output.sinkContext.SampleRate()
+}
+
+func (output *FilterOutput) SampleFormat() astiav.SampleFormat {
+	return output.sinkContext.SampleFormat()
+}
+
+func (output *FilterOutput) ChannelLayout() astiav.ChannelLayout {
+	return output.sinkContext.ChannelLayout()
+}
@@ -0,0 +1,344 @@
+package transcode
+
+import (
+	"errors"
+
+	"github.com/asticode/go-astiav"
+)
+
+const (
+	h264NALUTypeIDR = 5
+	h264NALUTypeSPS = 7
+	h264NALUTypePPS = 8
+)
+
+var annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+var errorBitstreamTooShort = errors.New("bitstream too short")
+
+// splitAnnexB returns the NAL units of an Annex-B byte stream without their start codes. Both 3 and 4 byte start codes
+// are accepted.
+func splitAnnexB(data []byte) [][]byte {
+	var (
+		nalus [][]byte
+		start = -1
+	)
+
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+
+		if start >= 0 {
+			end := i
+			if end > start && data[end-1] == 0 {
+				end-- // NOTE: 4 BYTE START CODE
+			}
+			nalus = append(nalus, data[start:end])
+		}
+		start = i + 3
+		i += 2
+	}
+
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	}
+
+	return nalus
+}
+
+func h264NALUType(nalu []byte) byte {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return nalu[0] & 0x1F
+}
+
+// removeEmulationPrevention strips the 0x03 bytes inserted after every 0x0000 pair in a NAL unit payload.
+func removeEmulationPrevention(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+
+	return rbsp
+}
+
+// bitReader reads the exp-Golomb coded fields of a raw byte sequence payload.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) readBit() (uint32, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errorBitstreamTooShort
+	}
+	bit := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 0x01
+	r.pos++
+	return uint32(bit), nil
+}
+
+func (r *bitReader) readBits(n int) (uint32, error) {
+	var value uint32
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | bit
+	}
+	return value, nil
+}
+
+func (r *bitReader) readUE() (uint32, error) {
+	zeros := 0
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errors.New("invalid exp-golomb code")
+		}
+	}
+
+	value, err := r.readBits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << uint(zeros)) - 1 + value, nil
+}
+
+func (r *bitReader) readSE() (int32, error) {
+	value, err := r.readUE()
+	if err != nil {
+		return 0, err
+	}
+	if value%2 == 0 {
+		return -int32(value / 2), nil
+	}
+	return int32(value/2) + 1, nil
+}
+
+// h264SPS holds the fields of a sequence parameter set needed to describe the stream without decoding it.
+type h264SPS struct {
+	profile         int
+	level           int
+	chromaFormatIDC uint32
+	bitDepth        uint32
+	width           int
+	height          int
+}
+
+func (sps h264SPS) pixelFormat() astiav.PixelFormat {
+	switch {
+	case sps.chromaFormatIDC == 0:
+		return astiav.PixelFormatGray8
+	case sps.chromaFormatIDC == 2:
+		return astiav.PixelFormatYuv422P
+	case sps.chromaFormatIDC == 3:
+		return astiav.PixelFormatYuv444P
+	case sps.bitDepth == 10:
+		return astiav.PixelFormatYuv420P10Le
+	default:
+		return astiav.PixelFormatYuv420P
+	}
+}
+
+// parseH264SPS parses a sequence parameter set NAL unit (including its one byte header) as laid out in ITU-T H.264
+// section 7.3.2.1.1. VUI parameters are not parsed.
+func parseH264SPS(nalu []byte) (h264SPS, error) {
+	var sps h264SPS
+
+	if h264NALUType(nalu) != h264NALUTypeSPS || len(nalu) < 4 {
+		return sps, errors.New("not a h264 sps")
+	}
+
+	r := &bitReader{data: removeEmulationPrevention(nalu[1:])}
+	sps.profile = int(r.data[0])
+	sps.level = int(r.data[2])
+	sps.chromaFormatIDC = 1
+	sps.bitDepth = 8
+	r.pos = 24
+
+	if _, err := r.readUE(); err != nil { // seq_parameter_set_id
+		return sps, err
+	}
+
+	separateColourPlane := uint32(0)
+	switch sps.profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		var err error
+		if sps.chromaFormatIDC, err = r.readUE(); err != nil {
+			return sps, err
+		}
+		if sps.chromaFormatIDC == 3 {
+			if separateColourPlane, err = r.readBit(); err != nil {
+				return sps, err
+			}
+		}
+		bitDepthLuma, err := r.readUE()
+		if err != nil {
+			return sps, err
+		}
+		sps.bitDepth = bitDepthLuma + 8
+		if _, err = r.readUE(); err != nil { // bit_depth_chroma_minus8
+			return sps, err
+		}
+		if _, err = r.readBit(); err != nil { // qpprime_y_zero_transform_bypass_flag
+			return sps, err
+		}
+		scalingMatrixPresent, err := r.readBit()
+		if err != nil {
+			return sps, err
+		}
+		if scalingMatrixPresent == 1 {
+			lists := 8
+			if sps.chromaFormatIDC == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				present, err := r.readBit()
+				if err != nil {
+					return sps, err
+				}
+				if present == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				if err := r.skipScalingList(size); err != nil {
+					return sps, err
+				}
+			}
+		}
+	}
+
+	if _, err := r.readUE(); err != nil { // log2_max_frame_num_minus4
+		return sps, err
+	}
+
+	picOrderCntType, err := r.readUE()
+	if err != nil {
+		return sps, err
+	}
+	switch picOrderCntType {
+	case 0:
+		if _, err := r.readUE(); err != nil { // log2_max_pic_order_cnt_lsb_minus4
+			return sps, err
+		}
+	case 1:
+		if _, err := r.readBit(); err != nil { // delta_pic_order_always_zero_flag
+			return sps, err
+		}
+		if _, err := r.readSE(); err != nil { // offset_for_non_ref_pic
+			return sps, err
+		}
+		if _, err := r.readSE(); err != nil { // offset_for_top_to_bottom_field
+			return sps, err
+		}
+		cycle, err := r.readUE()
+		if err != nil {
+			return sps, err
+		}
+		for i := uint32(0); i < cycle; i++ {
+			if _, err := r.readSE(); err != nil {
+				return sps, err
+			}
+		}
+	}
+
+	if _, err := r.readUE(); err != nil { // max_num_ref_frames
+		return sps, err
+	}
+	if _, err := r.readBit(); err != nil { // gaps_in_frame_num_value_allowed_flag
+		return sps, err
+	}
+
+	widthInMbs, err := r.readUE()
+	if err != nil {
+		return sps, err
+	}
+	heightInMapUnits, err := r.readUE()
+	if err != nil {
+		return sps, err
+	}
+	frameMbsOnly, err := r.readBit()
+	if err != nil {
+		return sps, err
+	}
+	if frameMbsOnly == 0 {
+		if _, err := r.readBit(); err != nil { // mb_adaptive_frame_field_flag
+			return sps, err
+		}
+	}
+	if _, err := r.readBit(); err != nil { // direct_8x8_inference_flag
+		return sps, err
+	}
+
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	cropping, err := r.readBit()
+	if err != nil {
+		return sps, err
+	}
+	if cropping == 1 {
+		for _, crop := range []*uint32{&cropLeft, &cropRight, &cropTop, &cropBottom} {
+			if *crop, err = r.readUE(); err != nil {
+				return sps, err
+			}
+		}
+	}
+
+	cropUnitX, cropUnitY := uint32(1), 2-frameMbsOnly
+	if sps.chromaFormatIDC != 0 && separateColourPlane == 0 {
+		subWidthC, subHeightC := uint32(2), uint32(2)
+		switch sps.chromaFormatIDC {
+		case 2:
+			subHeightC = 1
+		case 3:
+			subWidthC, subHeightC = 1, 1
+		}
+		cropUnitX = subWidthC
+		cropUnitY = subHeightC * (2 - frameMbsOnly)
+	}
+
+	sps.width = int((widthInMbs+1)*16 - cropUnitX*(cropLeft+cropRight))
+	sps.height = int((2-frameMbsOnly)*(heightInMapUnits+1)*16 - cropUnitY*(cropTop+cropBottom))
+
+	return sps, nil
+}
+
+func (r *bitReader) skipScalingList(size int) error {
+	lastScale, nextScale := int32(8), int32(8)
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			delta, err := r.readSE()
+			if err != nil {
+				return err
+			}
+			nextScale = (lastScale + delta + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+	return nil
+}
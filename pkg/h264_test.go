@@ -0,0 +1,210 @@
+package transcode
+
+import (
+	"testing"
+
+	"github.com/asticode/go-astiav"
+)
+
+// bitWriter writes the fields of a parameter set for the parser tests.
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *bitWriter) writeBits(value uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>uint(i)&1 == 1 {
+			w.data[len(w.data)-1] |= 1 << uint(7-w.bits%8)
+		}
+		w.bits++
+	}
+}
+
+func (w *bitWriter) writeUE(value uint32) {
+	value++
+	n := 0
+	for v := value; v > 1; v >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(value, n+1)
+}
+
+func (w *bitWriter) writeSE(value int32) {
+	if value > 0 {
+		w.writeUE(uint32(2*value - 1))
+		return
+	}
+	w.writeUE(uint32(-2 * value))
+}
+
+// rbsp ends the payload with the stop bit and inserts the emulation prevention bytes.
+func (w *bitWriter) rbsp() []byte {
+	w.writeBits(1, 1)
+
+	escaped := make([]byte, 0, len(w.data))
+	zeros := 0
+	for _, b := range w.data {
+		if zeros >= 2 && b <= 3 {
+			escaped = append(escaped, 3)
+			zeros = 0
+		}
+		escaped = append(escaped, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return escaped
+}
+
+type testH264SPS struct {
+	profile          uint32
+	level            uint32
+	chromaFormatIDC  uint32
+	bitDepthLuma     uint32
+	picOrderCntType  uint32
+	widthInMbs       uint32
+	heightInMapUnits uint32
+	frameMbsOnly     uint32
+	crop             [4]uint32 // NOTE: LEFT, RIGHT, TOP, BOTTOM
+}
+
+func (sps testH264SPS) nalu() []byte {
+	w := &bitWriter{}
+	w.writeBits(sps.profile, 8)
+	w.writeBits(0, 8) // constraint flags
+	w.writeBits(sps.level, 8)
+	w.writeUE(0) // seq_parameter_set_id
+
+	if sps.profile == 100 || sps.profile == 110 || sps.profile == 122 {
+		w.writeUE(sps.chromaFormatIDC)
+		w.writeUE(sps.bitDepthLuma - 8)
+		w.writeUE(sps.bitDepthLuma - 8)
+		w.writeBits(0, 1) // qpprime_y_zero_transform_bypass_flag
+		w.writeBits(0, 1) // seq_scaling_matrix_present_flag
+	}
+
+	w.writeUE(0) // log2_max_frame_num_minus4
+	w.writeUE(sps.picOrderCntType)
+	switch sps.picOrderCntType {
+	case 0:
+		w.writeUE(2)
+	case 1:
+		w.writeBits(0, 1)
+		w.writeSE(-2)
+		w.writeSE(1)
+		w.writeUE(2)
+		w.writeSE(3)
+		w.writeSE(-4)
+	}
+
+	w.writeUE(1)      // max_num_ref_frames
+	w.writeBits(0, 1) // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(sps.widthInMbs - 1)
+	w.writeUE(sps.heightInMapUnits - 1)
+	w.writeBits(sps.frameMbsOnly, 1)
+	if sps.frameMbsOnly == 0 {
+		w.writeBits(0, 1) // mb_adaptive_frame_field_flag
+	}
+	w.writeBits(1, 1) // direct_8x8_inference_flag
+
+	if sps.crop == [4]uint32{} {
+		w.writeBits(0, 1)
+	} else {
+		w.writeBits(1, 1)
+		for _, crop := range sps.crop {
+			w.writeUE(crop)
+		}
+	}
+	w.writeBits(0, 1) // vui_parameters_present_flag
+
+	return append([]byte{0x67}, w.rbsp()...)
+}
+
+func TestParseH264SPS(t *testing.T) {
+	tests := []struct {
+		name        string
+		sps         testH264SPS
+		width       int
+		height      int
+		pixelFormat astiav.PixelFormat
+	}{
+		{
+			name:        "baseline 720p",
+			sps:         testH264SPS{profile: 66, level: 31, widthInMbs: 80, heightInMapUnits: 45, frameMbsOnly: 1},
+			width:       1280,
+			height:      720,
+			pixelFormat: astiav.PixelFormatYuv420P,
+		},
+		{
+			name:        "high 1080p cropped",
+			sps:         testH264SPS{profile: 100, level: 40, chromaFormatIDC: 1, bitDepthLuma: 8, widthInMbs: 120, heightInMapUnits: 68, frameMbsOnly: 1, crop: [4]uint32{0, 0, 0, 4}},
+			width:       1920,
+			height:      1080,
+			pixelFormat: astiav.PixelFormatYuv420P,
+		},
+		{
+			name:        "interlaced with picture order count type 1",
+			sps:         testH264SPS{profile: 77, level: 30, picOrderCntType: 1, widthInMbs: 45, heightInMapUnits: 18, frameMbsOnly: 0, crop: [4]uint32{0, 0, 0, 0}},
+			width:       720,
+			height:      576,
+			pixelFormat: astiav.PixelFormatYuv420P,
+		},
+		{
+			name:        "high 4:2:2 10 bit",
+			sps:         testH264SPS{profile: 122, level: 41, chromaFormatIDC: 2, bitDepthLuma: 10, widthInMbs: 40, heightInMapUnits: 30, frameMbsOnly: 1, crop: [4]uint32{2, 2, 0, 0}},
+			width:       632,
+			height:      480,
+			pixelFormat: astiav.PixelFormatYuv422P,
+		},
+		{
+			name:        "high 10 bit",
+			sps:         testH264SPS{profile: 110, level: 51, chromaFormatIDC: 1, bitDepthLuma: 10, widthInMbs: 240, heightInMapUnits: 135, frameMbsOnly: 1},
+			width:       3840,
+			height:      2160,
+			pixelFormat: astiav.PixelFormatYuv420P10Le,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sps, err := parseH264SPS(test.sps.nalu())
+			if err != nil {
+				t.Fatalf("Failed to parse sps: %v", err)
+			}
+
+			if sps.profile != int(test.sps.profile) || sps.level != int(test.sps.level) {
+				t.Fatalf("got profile %d level %d, want %d %d", sps.profile, sps.level, test.sps.profile, test.sps.level)
+			}
+			if sps.width != test.width || sps.height != test.height {
+				t.Fatalf("got %dx%d, want %dx%d", sps.width, sps.height, test.width, test.height)
+			}
+			if sps.pixelFormat() != test.pixelFormat {
+				t.Fatalf("got pixel format %s, want %s", sps.pixelFormat(), test.pixelFormat)
+			}
+		})
+	}
+}
+
+func TestParseH264SPSRejectsOtherNALUs(t *testing.T) {
+	if _, err := parseH264SPS([]byte{0x68, 0xce, 0x38, 0x80}); err == nil {
+		t.Fatalf("parsed a pps as sps")
+	}
+	if _, err := parseH264SPS([]byte{0x67, 0x42}); err == nil {
+		t.Fatalf("parsed a truncated sps")
+	}
+}
+
+func TestRemoveEmulationPrevention(t *testing.T) {
+	got := removeEmulationPrevention([]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x03})
+	want := []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03}
+	if string(got) != string(want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package transcode
+
+import (
+	"errors"
+)
+
+// hevcSPS holds the fields of a sequence parameter set needed to write an HEVCDecoderConfigurationRecord.
+type hevcSPS struct {
+	profileTierLevel   [12]byte // NOTE: general_profile_space UP TO general_level_idc, AS LAID OUT IN hvcC
+	maxSubLayersMinus1 uint8
+	temporalIDNesting  uint8
+	chromaFormatIDC    uint32
+	bitDepthLuma       uint32
+	bitDepthChroma     uint32
+}
+
+// parseHEVCSPS parses a sequence parameter set NAL unit (including its two byte header) as laid out in ITU-T H.265
+// section 7.3.2.2, up to the bit depths.
+func parseHEVCSPS(nalu []byte) (hevcSPS, error) {
+	var sps hevcSPS
+
+	if len(nalu) < 2 || hevcNALUType(nalu) != hevcNALUTypeSPS {
+		return sps, errors.New("not a hevc sps")
+	}
+
+	r := &bitReader{data: removeEmulationPrevention(nalu[2:])}
+	if len(r.data) < 13 {
+		return sps, errorBitstreamTooShort
+	}
+
+	sps.maxSubLayersMinus1 = (r.data[0] >> 1) & 0x07
+	sps.temporalIDNesting = r.data[0] & 0x01
+	copy(sps.profileTierLevel[:], r.data[1:13])
+	r.pos = 13 * 8
+
+	var profilePresent, levelPresent [8]uint32
+	for i := 0; i < int(sps.maxSubLayersMinus1); i++ {
+		var err error
+		if profilePresent[i], err = r.readBit(); err != nil {
+			return sps, err
+		}
+		if levelPresent[i], err = r.readBit(); err != nil {
+			return sps, err
+		}
+	}
+	if sps.maxSubLayersMinus1 > 0 {
+		if _, err := r.readBits(2 * (8 - int(sps.maxSubLayersMinus1))); err != nil { // reserved_zero_2bits
+			return sps, err
+		}
+	}
+	for i := 0; i < int(sps.maxSubLayersMinus1); i++ {
+		if profilePresent[i] == 1 {
+			r.pos += 88
+		}
+		if levelPresent[i] == 1 {
+			r.pos += 8
+		}
+	}
+
+	if _, err := r.readUE(); err != nil { // sps_seq_parameter_set_id
+		return sps, err
+	}
+
+	var err error
+	if sps.chromaFormatIDC, err = r.readUE(); err != nil {
+		return sps, err
+	}
+	if sps.chromaFormatIDC == 3 {
+		if _, err := r.readBit(); err != nil { // separate_colour_plane_flag
+			return sps, err
+		}
+	}
+	if _, err := r.readUE(); err != nil { // pic_width_in_luma_samples
+		return sps, err
+	}
+	if _, err := r.readUE(); err != nil { // pic_height_in_luma_samples
+		return sps, err
+	}
+
+	conformanceWindow, err := r.readBit()
+	if err != nil {
+		return sps, err
+	}
+	if conformanceWindow == 1 {
+		for i := 0; i < 4; i++ {
+			if _, err := r.readUE(); err != nil {
+				return sps, err
+			}
+		}
+	}
+
+	bitDepthLuma, err := r.readUE()
+	if err != nil {
+		return sps, err
+	}
+	bitDepthChroma, err := r.readUE()
+	if err != nil {
+		return sps, err
+	}
+	sps.bitDepthLuma = bitDepthLuma + 8
+	sps.bitDepthChroma = bitDepthChroma + 8
+
+	return sps, nil
+}
@@ -0,0 +1,133 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+)
+
+type testHEVCSPS struct {
+	maxSubLayersMinus1 uint32
+	temporalIDNesting  uint32
+	profileTierLevel   [12]byte
+	subLayerProfile    bool // NOTE: EVERY SUB-LAYER CARRIES ITS PROFILE AND LEVEL
+	chromaFormatIDC    uint32
+	conformanceWindow  [4]uint32
+	bitDepthLuma       uint32
+	bitDepthChroma     uint32
+}
+
+func (sps testHEVCSPS) nalu() []byte {
+	w := &bitWriter{}
+	w.writeBits(0, 4) // sps_video_parameter_set_id
+	w.writeBits(sps.maxSubLayersMinus1, 3)
+	w.writeBits(sps.temporalIDNesting, 1)
+	for _, b := range sps.profileTierLevel {
+		w.writeBits(uint32(b), 8)
+	}
+
+	present := uint32(0)
+	if sps.subLayerProfile {
+		present = 1
+	}
+	for i := uint32(0); i < sps.maxSubLayersMinus1; i++ {
+		w.writeBits(present, 1) // sub_layer_profile_present_flag
+		w.writeBits(present, 1) // sub_layer_level_present_flag
+	}
+	if sps.maxSubLayersMinus1 > 0 {
+		w.writeBits(0, 2*(8-int(sps.maxSubLayersMinus1)))
+	}
+	for i := uint32(0); i < sps.maxSubLayersMinus1 && sps.subLayerProfile; i++ {
+		w.writeBits(0, 32)
+		w.writeBits(0, 32)
+		w.writeBits(0, 24)
+		w.writeBits(0xFF, 8) // sub_layer_level_idc
+	}
+
+	w.writeUE(0) // sps_seq_parameter_set_id
+	w.writeUE(sps.chromaFormatIDC)
+	if sps.chromaFormatIDC == 3 {
+		w.writeBits(0, 1) // separate_colour_plane_flag
+	}
+	w.writeUE(1920) // pic_width_in_luma_samples
+	w.writeUE(1088) // pic_height_in_luma_samples
+
+	if sps.conformanceWindow == [4]uint32{} {
+		w.writeBits(0, 1)
+	} else {
+		w.writeBits(1, 1)
+		for _, offset := range sps.conformanceWindow {
+			w.writeUE(offset)
+		}
+	}
+	w.writeUE(sps.bitDepthLuma - 8)
+	w.writeUE(sps.bitDepthChroma - 8)
+
+	return append([]byte{0x42, 0x01}, w.rbsp()...)
+}
+
+// testHEVCMain is general_profile_idc 1 (Main) with only the Main compatibility flag, at level 4.1.
+var testHEVCMain = [12]byte{0x01, 0x40, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 123}
+
+func TestParseHEVCSPS(t *testing.T) {
+	tests := []struct {
+		name string
+		sps  testHEVCSPS
+	}{
+		{
+			name: "main 4:2:0 8 bit",
+			sps:  testHEVCSPS{temporalIDNesting: 1, profileTierLevel: testHEVCMain, chromaFormatIDC: 1, conformanceWindow: [4]uint32{0, 0, 0, 4}, bitDepthLuma: 8, bitDepthChroma: 8},
+		},
+		{
+			name: "main 10 without conformance window",
+			sps:  testHEVCSPS{profileTierLevel: [12]byte{0x02, 0x20, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 150}, chromaFormatIDC: 1, bitDepthLuma: 10, bitDepthChroma: 10},
+		},
+		{
+			name: "4:4:4 with a separate colour plane flag",
+			sps:  testHEVCSPS{profileTierLevel: testHEVCMain, chromaFormatIDC: 3, bitDepthLuma: 12, bitDepthChroma: 10},
+		},
+		{
+			name: "sub-layers without their profiles",
+			sps:  testHEVCSPS{maxSubLayersMinus1: 2, temporalIDNesting: 1, profileTierLevel: testHEVCMain, chromaFormatIDC: 1, bitDepthLuma: 8, bitDepthChroma: 8},
+		},
+		{
+			name: "sub-layers with their profiles",
+			sps:  testHEVCSPS{maxSubLayersMinus1: 3, profileTierLevel: testHEVCMain, subLayerProfile: true, chromaFormatIDC: 2, conformanceWindow: [4]uint32{1, 2, 3, 4}, bitDepthLuma: 8, bitDepthChroma: 8},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sps, err := parseHEVCSPS(test.sps.nalu())
+			if err != nil {
+				t.Fatalf("Failed to parse sps: %v", err)
+			}
+
+			if sps.profileTierLevel != test.sps.profileTierLevel {
+				t.Fatalf("got profile tier level %x, want %x", sps.profileTierLevel, test.sps.profileTierLevel)
+			}
+			if uint32(sps.maxSubLayersMinus1) != test.sps.maxSubLayersMinus1 || uint32(sps.temporalIDNesting) != test.sps.temporalIDNesting {
+				t.Fatalf("got %d sub-layers nesting %d, want %d %d", sps.maxSubLayersMinus1, sps.temporalIDNesting, test.sps.maxSubLayersMinus1, test.sps.temporalIDNesting)
+			}
+			if sps.chromaFormatIDC != test.sps.chromaFormatIDC {
+				t.Fatalf("got chroma format %d, want %d", sps.chromaFormatIDC, test.sps.chromaFormatIDC)
+			}
+			if sps.bitDepthLuma != test.sps.bitDepthLuma || sps.bitDepthChroma != test.sps.bitDepthChroma {
+				t.Fatalf("got bit depths %d/%d, want %d/%d", sps.bitDepthLuma, sps.bitDepthChroma, test.sps.bitDepthLuma, test.sps.bitDepthChroma)
+			}
+		})
+	}
+}
+
+func TestParseHEVCSPSRejectsInvalidData(t *testing.T) {
+	nalu := testHEVCSPS{profileTierLevel: testHEVCMain, chromaFormatIDC: 1, bitDepthLuma: 8, bitDepthChroma: 8}.nalu()
+
+	if _, err := parseHEVCSPS(testHEVCPPSNALU); err == nil {
+		t.Fatalf("parsed a pps as sps")
+	}
+	if _, err := parseHEVCSPS(nalu[:10]); !errors.Is(err, errorBitstreamTooShort) {
+		t.Fatalf("got %v for a truncated profile tier level, want %v", err, errorBitstreamTooShort)
+	}
+	if _, err := parseHEVCSPS(nalu[:16]); err == nil {
+		t.Fatalf("parsed a truncated sps")
+	}
+}
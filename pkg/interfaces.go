@@ -2,6 +2,7 @@ package transcode
 
 import (
 	"context"
+	"time"
 
 	"github.com/asticode/go-astiav"
 
@@ -16,6 +17,26 @@ type CanSetDemuxerInputFormat interface {
 	SetInputFormat(*astiav.InputFormat)
 }
 
+type CanSetDemuxerReconnect interface {
+	SetReconnectConfig(ReconnectConfig) error
+}
+
+type CanSetDemuxerDropWhenFull interface {
+	SetDropWhenFull(bool)
+}
+
+type CanSelectDemuxerStream interface {
+	AddStreamSelector(StreamSelector)
+}
+
+type CanSetMuxerOutputOption interface {
+	SetOutputOption(key, value string, flags astiav.DictionaryFlags) error
+}
+
+type CanSetMuxerOutputFormat interface {
+	SetOutputFormat(formatName string)
+}
+
 type CanSetBuffer[T any] interface {
 	SetBuffer(buffer buffer.BufferWithGenerator[T])
 }
@@ -117,10 +138,39 @@ type Decoder interface {
 	CanProduceMediaFrame
 }
 
+type CanSetVideoOutput interface {
+	SetVideoOutput(ctx context.Context, output VideoOutput) error
+}
+
+type CanSendFilterCommand interface {
+	SendCommand(target, cmd, arg string) (string, error)
+	QueueCommand(at time.Duration, target, cmd, arg string) error
+}
+
+type CanSetErrorHandler interface {
+	SetErrorHandler(func(error))
+}
+
+type CanSetOutputDropWhenFull interface {
+	SetOutputDropWhenFull(label string) error
+}
+
+type CanAddFilterUpdator interface {
+	AddUpdator(FilterUpdator)
+}
+
 type CanAddToFilterContent interface {
 	AddToFilterContent(string)
 }
 
+type CanSetInputSyncDelay interface {
+	SetInputSyncDelay(time.Duration)
+}
+
+type CanAddFilterNode interface {
+	AddFilterNode(...*FilterNode)
+}
+
 type Filter interface {
 	Ctx() context.Context
 	Start()
@@ -133,10 +183,30 @@ type CanPauseUnPauseEncoder interface {
 	UnPauseEncoding() error
 }
 
+type CanForceKeyFrame interface {
+	ForceKeyFrame() error
+}
+
+type CanSetKeyFrameRequestInterval interface {
+	SetKeyFrameRequestInterval(time.Duration)
+}
+
+type CanSetBitstreamFormat interface {
+	SetBitstreamFormat(BitstreamFormat)
+}
+
+type CanRepeatParameterSets interface {
+	SetRepeatParameterSets(bool)
+}
+
 type CanGetParameterSets interface {
 	GetParameterSets() (sps, pps []byte, err error)
 }
 
+type CanGetCodecParameterSets interface {
+	GetCodecParameterSets() (ParameterSets, error)
+}
+
 type Encoder interface {
 	Ctx() context.Context
 	Start()
@@ -144,6 +214,23 @@ type Encoder interface {
 	CanProduceMediaPacket
 }
 
+type BitstreamFilter interface {
+	Ctx() context.Context
+	Start()
+	Stop()
+	CanProduceMediaPacket
+}
+
+type Muxer interface {
+	Ctx() context.Context
+	Start()
+	Stop()
+}
+
+type CanSetPassthrough interface {
+	SetPassthrough(bool) error
+}
+
 type CanSetEncoderCodecSettings interface {
 	SetEncoderCodecSettings(codecSettings) error
 }
@@ -152,12 +239,21 @@ type CanUpdateBitrate interface {
 	UpdateBitrate(int64) error
 }
 
+type CanReconfigure interface {
+	CanReconfigure() bool
+	Reconfigure(RateControlConfig) error
+}
+
 type CanGetCurrentBitrate interface {
 	GetCurrentBitrate() (int64, error)
 }
 
 type UpdateBitrateCallBack func(bps int64) error
 
+type CanGetBitrateBounds interface {
+	BitrateBounds() (minBitrate, maxBitrate int64)
+}
+
 type CanGetUpdateBitrateCallBack interface {
 	OnUpdateBitrate() UpdateBitrateCallBack
 }
@@ -0,0 +1,52 @@
+package transcode
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultKeyFrameRequestInterval = 500 * time.Millisecond
+
+// keyFrameRequests coalesces keyframe requests (PLI/FIR from WebRTC receivers). A request made within the minimum
+// interval of the last forced keyframe is not dropped; it is held and served once the interval has passed, so a burst
+// of requests results in at most one keyframe per interval.
+type keyFrameRequests struct {
+	minInterval time.Duration
+	pending     bool
+	last        time.Time
+	mux         sync.Mutex
+}
+
+func newKeyFrameRequests(minInterval time.Duration) *keyFrameRequests {
+	return &keyFrameRequests{
+		minInterval: minInterval,
+	}
+}
+
+func (r *keyFrameRequests) request() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.pending = true
+}
+
+// take reports whether the next frame needs to be a keyframe, and consumes the pending request if so.
+func (r *keyFrameRequests) take(now time.Time) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if !r.pending || now.Sub(r.last) < r.minInterval {
+		return false
+	}
+
+	r.pending = false
+	r.last = now
+	return true
+}
+
+func (r *keyFrameRequests) setMinInterval(minInterval time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.minInterval = minInterval
+}
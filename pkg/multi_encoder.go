@@ -2,6 +2,7 @@ package transcode
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -54,12 +55,14 @@ func NewMultiConfig(minBitrate, maxBitrate int64, steps uint8) MultiConfig {
 
 type dummyMediaFrameProducer struct {
 	buffer buffer.BufferWithGenerator[astiav.Frame]
+	eos    *endOfStream[astiav.Frame]
 	CanDescribeMediaFrame
 }
 
 func newDummyMediaFrameProducer(buffer buffer.BufferWithGenerator[astiav.Frame], describer CanDescribeMediaFrame) *dummyMediaFrameProducer {
 	return &dummyMediaFrameProducer{
 		buffer:                buffer,
+		eos:                   newEndOfStream[astiav.Frame](),
 		CanDescribeMediaFrame: describer,
 	}
 }
@@ -68,8 +71,12 @@ func (p *dummyMediaFrameProducer) pushFrame(ctx context.Context, frame *astiav.F
 	return p.buffer.Push(ctx, frame)
 }
 
+func (p *dummyMediaFrameProducer) pushEndOfStream(ctx context.Context) error {
+	return p.eos.push(ctx, p.buffer)
+}
+
 func (p *dummyMediaFrameProducer) GetFrame(ctx context.Context) (*astiav.Frame, error) {
-	return p.buffer.Pop(ctx)
+	return p.eos.pop(ctx, p.buffer)
 }
 
 func (p *dummyMediaFrameProducer) Generate() *astiav.Frame {
@@ -83,24 +90,42 @@ func (p *dummyMediaFrameProducer) PutBack(frame *astiav.Frame) {
 type splitEncoder struct {
 	encoder  *GeneralEncoder
 	producer *dummyMediaFrameProducer
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
-func newSplitEncoder(encoder *GeneralEncoder, producer *dummyMediaFrameProducer) *splitEncoder {
+func newSplitEncoder(ctx context.Context, encoder *GeneralEncoder, producer *dummyMediaFrameProducer) *splitEncoder {
+	ctx2, cancel := context.WithCancel(ctx)
 	return &splitEncoder{
 		encoder:  encoder,
 		producer: producer,
+		ctx:      ctx2,
+		cancel:   cancel,
 	}
 }
 
+func (s *splitEncoder) stop() {
+	s.encoder.Stop()
+	s.cancel()
+}
+
 type MultiUpdateEncoder struct {
 	encoders []*splitEncoder
 	active   atomic.Pointer[splitEncoder]
 	config   MultiConfig
 	bitrates []int64
 	producer CanProduceMediaFrame
+	builder  *GeneralEncoderBuilder
+	ladder   *ladderState
+	buffer   buffer.BufferWithGenerator[astiav.Packet]
+	eos      *endOfStream[astiav.Packet]
 	ctx      context.Context
 	cancel   context.CancelFunc
 
+	switching     encoderSwitch
+	parameterSets chan struct{}
+	switchMux     sync.Mutex
+
 	paused   atomic.Bool
 	resume   chan struct{}
 	pauseMux sync.Mutex
@@ -117,14 +142,18 @@ func NewMultiUpdateEncoder(ctx context.Context, config MultiConfig, builder *Gen
 		config:   config,
 		bitrates: config.getBitrates(),
 		producer: builder.producer,
+		builder:  builder,
+		buffer:   buffer.CreateChannelBuffer(ctx2, 90, internal.CreatePacketPool()),
+		eos:      newEndOfStream[astiav.Packet](),
 		ctx:      ctx2,
 		cancel:   cancel,
 		resume:   make(chan struct{}),
+
+		parameterSets: make(chan struct{}, 1),
 	}
 
-	describer, ok := encoder.producer.(CanDescribeMediaFrame)
-	if !ok {
-		return nil, ErrorInterfaceMismatch
+	if config.Ladder != nil {
+		encoder.ladder = newLadderState(config.Ladder)
 	}
 
 	initialBitrate, err := builder.GetCurrentBitrate()
@@ -132,24 +161,41 @@ func NewMultiUpdateEncoder(ctx context.Context, config MultiConfig, builder *Gen
 		initialBitrate = encoder.bitrates[0]
 	}
 
-	for _, bitrate := range encoder.bitrates {
-		producer := newDummyMediaFrameProducer(buffer.CreateChannelBuffer(ctx2, 90, internal.CreateFramePool()), describer)
+	encoders, err := encoder.buildEncoders()
+	if err != nil {
+		return nil, err
+	}
+	encoder.encoders = encoders
+
+	encoder.switchEncoder(encoder.findBestEncoderIndex(initialBitrate))
 
-		if err := builder.UpdateBitrate(bitrate); err != nil {
+	return encoder, nil
+}
+
+// buildEncoders builds one split encoder per bitrate step, each fed by its own copy of the producer's frames.
+func (u *MultiUpdateEncoder) buildEncoders() ([]*splitEncoder, error) {
+	describer, ok := u.producer.(CanDescribeMediaFrame)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+
+	encoders := make([]*splitEncoder, 0, len(u.bitrates))
+	for _, bitrate := range u.bitrates {
+		producer := newDummyMediaFrameProducer(buffer.CreateChannelBuffer(u.ctx, 90, internal.CreateFramePool()), describer)
+
+		if err := u.builder.UpdateBitrate(bitrate); err != nil {
 			return nil, err
 		}
 
-		e, err := builder.BuildWithProducer(ctx2, producer)
+		e, err := u.builder.BuildWithProducer(u.ctx, producer)
 		if err != nil {
 			return nil, err
 		}
 
-		encoder.encoders = append(encoder.encoders, newSplitEncoder(e.(*GeneralEncoder), producer))
+		encoders = append(encoders, newSplitEncoder(u.ctx, e.(*GeneralEncoder), producer))
 	}
 
-	encoder.switchEncoder(encoder.findBestEncoderIndex(initialBitrate))
-
-	return encoder, nil
+	return encoders, nil
 }
 
 func (u *MultiUpdateEncoder) Ctx() context.Context {
@@ -162,14 +208,18 @@ func (u *MultiUpdateEncoder) Start() {
 	}
 
 	go u.loop()
+
+	for _, encoder := range u.encoders {
+		go u.forwardLoop(encoder)
+	}
 }
 
 func (u *MultiUpdateEncoder) GetPacket(ctx context.Context) (*astiav.Packet, error) {
-	return u.active.Load().encoder.GetPacket(ctx)
+	return u.eos.pop(ctx, u.buffer)
 }
 
 func (u *MultiUpdateEncoder) PutBack(packet *astiav.Packet) {
-	u.active.Load().encoder.PutBack(packet)
+	u.buffer.PutBack(packet)
 }
 
 func (u *MultiUpdateEncoder) Stop() {
@@ -183,12 +233,82 @@ func (u *MultiUpdateEncoder) UpdateBitrate(bps int64) error {
 
 	bps = u.cutoff(bps)
 
+	if u.ladder != nil {
+		if rung, changed := u.ladder.next(bps, time.Now()); changed {
+			return u.adapt(rung, bps)
+		}
+	}
+
 	bestIndex := u.findBestEncoderIndex(bps)
 	u.switchEncoder(bestIndex)
 
 	return nil
 }
 
+// adapt moves the producer to the video output of the rung and replaces every split encoder with one built at the new
+// size. The consumer moves to the new encoder for the bitrate through the deferred switch: the active encoder gets no
+// more frames and is drained, and the switch completes at the first keyframe of the target or, at the latest, when the
+// active encoder has ended. The new parameter sets are sent in-band; frames of the wrong size are dropped by either.
+func (u *MultiUpdateEncoder) adapt(rung AdaptationRung, bps int64) error {
+	s, ok := u.producer.(CanSetVideoOutput)
+	if !ok {
+		u.ladder.revert()
+		return ErrorInterfaceMismatch
+	}
+
+	if err := u.setVideoOutput(s, rung); err != nil {
+		u.ladder.revert()
+		return err
+	}
+
+	encoders, err := u.buildEncoders()
+	if err != nil {
+		_ = u.setVideoOutput(s, u.ladder.revert())
+		return err
+	}
+
+	for _, encoder := range encoders {
+		encoder.encoder.Start()
+		go u.forwardLoop(encoder)
+	}
+
+	u.switchMux.Lock()
+	u.cancelSwitch()
+	old, active := u.encoders, u.active.Load()
+	u.encoders = encoders
+	u.switching.target = encoders[u.findBestEncoderIndex(bps)]
+	u.switching.retiring = true
+	u.switchMux.Unlock()
+
+	for _, encoder := range old {
+		if encoder != active {
+			encoder.stop()
+		}
+	}
+
+	// NOTE: THE LOOP NO LONGER FEEDS THE ACTIVE ENCODER; ITS END OF STREAM COMPLETES THE SWITCH ONCE IT IS DRAINED
+	go func() {
+		_ = active.producer.pushEndOfStream(active.ctx)
+	}()
+
+	return nil
+}
+
+func (u *MultiUpdateEncoder) setVideoOutput(s CanSetVideoOutput, rung AdaptationRung) error {
+	ctx, cancel := context.WithTimeout(u.ctx, ladderApplyTimeout)
+	defer cancel()
+
+	return s.SetVideoOutput(ctx, rung.output())
+}
+
+// splitEncoders returns the current split encoders, which adapt may replace at any time.
+func (u *MultiUpdateEncoder) splitEncoders() []*splitEncoder {
+	u.switchMux.Lock()
+	defer u.switchMux.Unlock()
+
+	return u.encoders
+}
+
 func (u *MultiUpdateEncoder) findBestEncoderIndex(targetBps int64) int {
 	bestIndex := 0
 	for i, bitrate := range u.bitrates {
@@ -202,11 +322,9 @@ func (u *MultiUpdateEncoder) findBestEncoderIndex(targetBps int64) int {
 	return bestIndex
 }
 
-func (u *MultiUpdateEncoder) switchEncoder(index int) {
-	if index < len(u.encoders) {
-		fmt.Printf("swapping to %d encoder with bitrate %d\n", index, u.bitrates[index])
-		u.active.Swap(u.encoders[index])
-	}
+// BitrateBounds returns the bitrate bounds of the UpdateConfig, so a BandwidthEstimator gives it estimates within them.
+func (u *MultiUpdateEncoder) BitrateBounds() (int64, int64) {
+	return u.config.MinBitrate, u.config.MaxBitrate
 }
 
 func (u *MultiUpdateEncoder) cutoff(bps int64) int64 {
@@ -251,10 +369,52 @@ func (u *MultiUpdateEncoder) UnPauseEncoding() error {
 	return nil
 }
 
+// ForceKeyFrame forces a keyframe on the active encoder and on the target of a pending switch, so the consumer gets
+// one whichever of the two it ends up on; the other encoders keep their GOP.
+func (u *MultiUpdateEncoder) ForceKeyFrame() error {
+	u.switchMux.Lock()
+	active, target := u.active.Load(), u.switching.target
+	u.switchMux.Unlock()
+
+	if target != nil {
+		if err := target.encoder.ForceKeyFrame(); err != nil {
+			return err
+		}
+	}
+
+	return active.encoder.ForceKeyFrame()
+}
+
 func (u *MultiUpdateEncoder) GetParameterSets() (sps []byte, pps []byte, err error) {
 	return u.active.Load().encoder.GetParameterSets()
 }
 
+func (u *MultiUpdateEncoder) GetCodecParameterSets() (ParameterSets, error) {
+	return u.active.Load().encoder.GetCodecParameterSets()
+}
+
+// ## CanDescribeMediaPacket; FORWARDED FROM THE ACTIVE ENCODER
+
+func (u *MultiUpdateEncoder) MediaType() astiav.MediaType {
+	return u.active.Load().encoder.MediaType()
+}
+
+func (u *MultiUpdateEncoder) CodecID() astiav.CodecID {
+	return u.active.Load().encoder.CodecID()
+}
+
+func (u *MultiUpdateEncoder) GetCodecParameters() *astiav.CodecParameters {
+	return u.active.Load().encoder.GetCodecParameters()
+}
+
+func (u *MultiUpdateEncoder) FrameRate() astiav.Rational {
+	return u.active.Load().encoder.FrameRate()
+}
+
+func (u *MultiUpdateEncoder) TimeBase() astiav.Rational {
+	return u.active.Load().encoder.TimeBase()
+}
+
 func (u *MultiUpdateEncoder) loop() {
 	defer u.close()
 
@@ -265,10 +425,14 @@ func (u *MultiUpdateEncoder) loop() {
 		default:
 			frame, err := u.getFrame()
 			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					u.endOfStream()
+					return
+				}
 				continue
 			}
 
-			for _, encoder := range u.encoders {
+			for _, encoder := range u.splitEncoders() {
 				if err := u.pushFrame(encoder, frame); err != nil {
 					continue
 				}
@@ -279,6 +443,17 @@ func (u *MultiUpdateEncoder) loop() {
 	}
 }
 
+// endOfStream forwards the end of stream to every split encoder, each of which drains on its own.
+func (u *MultiUpdateEncoder) endOfStream() {
+	for _, encoder := range u.splitEncoders() {
+		if err := encoder.producer.pushEndOfStream(u.ctx); err != nil {
+			return
+		}
+	}
+
+	<-u.ctx.Done()
+}
+
 func (u *MultiUpdateEncoder) getFrame() (*astiav.Frame, error) {
 	ctx, cancel := context.WithTimeout(u.ctx, 50*time.Millisecond)
 	defer cancel()
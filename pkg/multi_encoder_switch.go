@@ -0,0 +1,248 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// maxHeldSwitchPackets bounds how many packets of the target encoder are held while waiting for the active encoder to
+// reach the switch point. If the active encoder stalls (or ended) the switch completes anyway.
+const maxHeldSwitchPackets = 60
+
+// encoderSwitch is the state of a pending switch between split encoders. All split encoders encode the same frames,
+// so the first keyframe of the target encoder marks a PTS at which the consumer can move over without decoding
+// artefacts: the active encoder keeps being forwarded up to that PTS, the target encoder from it.
+type encoderSwitch struct {
+	target   *splitEncoder
+	found    bool
+	pts      int64
+	held     []*astiav.Packet
+	sent     ParameterSets
+	retiring bool // NOTE: THE ACTIVE ENCODER WAS REPLACED BY adapt; IT IS STOPPED WHEN THE SWITCH COMPLETES
+}
+
+// switchEncoder requests a switch to the encoder at index. The switch is deferred until the target encoder produces a
+// keyframe; one is forced on it so the switch does not have to wait for the end of its GOP.
+func (u *MultiUpdateEncoder) switchEncoder(index int) {
+	u.switchMux.Lock()
+	defer u.switchMux.Unlock()
+
+	if index >= len(u.encoders) {
+		return
+	}
+
+	next := u.encoders[index]
+	active := u.active.Load()
+
+	if active == nil {
+		u.active.Store(next)
+		u.switching.sent = next.encoder.parameterSets
+		return
+	}
+
+	if next == u.switching.target {
+		return
+	}
+
+	u.cancelSwitch()
+	if next == active {
+		return
+	}
+
+	fmt.Printf("swapping to %d encoder with bitrate %d\n", index, u.bitrates[index])
+	u.switching.target = next
+	_ = next.encoder.ForceKeyFrame()
+}
+
+// ParameterSetsChanged is signalled when the consumer starts receiving packets from an encoder whose parameter sets
+// (SPS/PPS, and VPS for HEVC) differ from the previous one. The new parameter sets are also sent in-band, in front of the first keyframe.
+func (u *MultiUpdateEncoder) ParameterSetsChanged() <-chan struct{} {
+	return u.parameterSets
+}
+
+func (u *MultiUpdateEncoder) forwardLoop(encoder *splitEncoder) {
+	for {
+		select {
+		case <-encoder.ctx.Done():
+			return
+		default:
+			packet, err := u.getPacket(encoder)
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					u.endOfStreamFrom(encoder)
+					return
+				}
+				continue
+			}
+
+			u.route(encoder, packet)
+		}
+	}
+}
+
+func (u *MultiUpdateEncoder) getPacket(encoder *splitEncoder) (*astiav.Packet, error) {
+	ctx, cancel := context.WithTimeout(encoder.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return encoder.encoder.GetPacket(ctx)
+}
+
+// route decides what happens to a packet of a split encoder: it is forwarded to the consumer, held until a pending
+// switch completes, or dropped.
+func (u *MultiUpdateEncoder) route(encoder *splitEncoder, packet *astiav.Packet) {
+	u.switchMux.Lock()
+	defer u.switchMux.Unlock()
+
+	switch encoder {
+	case u.active.Load():
+		if u.switching.target != nil && u.switching.found && packet.Pts() >= u.switching.pts {
+			// NOTE: THE ACTIVE ENCODER REACHED THE SWITCH POINT; EVERYTHING BEFORE IT HAS BEEN FORWARDED
+			encoder.encoder.PutBack(packet)
+			u.completeSwitch()
+			return
+		}
+		u.forward(encoder, packet)
+	case u.switching.target:
+		if !u.switching.found {
+			if !packet.Flags().Has(astiav.PacketFlagKey) {
+				encoder.encoder.PutBack(packet)
+				return
+			}
+			u.switching.found = true
+			u.switching.pts = packet.Pts()
+		}
+
+		u.switching.held = append(u.switching.held, packet)
+		if len(u.switching.held) > maxHeldSwitchPackets {
+			u.completeSwitch()
+		}
+	default:
+		encoder.encoder.PutBack(packet)
+	}
+}
+
+// completeSwitch makes the target the active encoder and forwards its held packets. Needs switchMux.
+func (u *MultiUpdateEncoder) completeSwitch() {
+	target := u.switching.target
+	held := u.switching.held
+
+	if u.switching.retiring {
+		u.active.Load().stop()
+		u.switching.retiring = false
+	}
+
+	u.active.Store(target)
+	u.switching.target = nil
+	u.switching.found = false
+	u.switching.held = nil
+
+	for _, packet := range held {
+		u.forward(target, packet)
+	}
+}
+
+// cancelSwitch drops a pending switch and its held packets. A retiring active encoder stays retiring; its end of
+// stream completes the next switch. Needs switchMux.
+func (u *MultiUpdateEncoder) cancelSwitch() {
+	if u.switching.target == nil {
+		return
+	}
+
+	for _, packet := range u.switching.held {
+		u.switching.target.encoder.PutBack(packet)
+	}
+
+	u.switching.target = nil
+	u.switching.found = false
+	u.switching.held = nil
+}
+
+// forward copies the packet into the output buffer and returns the original to its encoder. The first keyframe after
+// a change of parameter sets carries them in-band and as new extradata side data. Needs switchMux, which also keeps
+// the output in order.
+func (u *MultiUpdateEncoder) forward(encoder *splitEncoder, packet *astiav.Packet) {
+	defer encoder.encoder.PutBack(packet)
+
+	out := u.buffer.Generate()
+
+	var err error
+	if packet.Flags().Has(astiav.PacketFlagKey) && u.parameterSetsChanged(encoder) {
+		err = u.withParameterSets(encoder, packet, out)
+	} else {
+		err = out.Ref(packet)
+	}
+	if err != nil {
+		u.buffer.PutBack(out)
+		return
+	}
+
+	if err := u.pushPacket(out); err != nil {
+		u.buffer.PutBack(out)
+	}
+}
+
+func (u *MultiUpdateEncoder) parameterSetsChanged(encoder *splitEncoder) bool {
+	return !encoder.encoder.parameterSets.Equal(u.switching.sent)
+}
+
+func (u *MultiUpdateEncoder) withParameterSets(encoder *splitEncoder, packet, out *astiav.Packet) error {
+	sets := encoder.encoder.parameterSets
+
+	if err := out.FromData(encoder.encoder.inBand(packet.Data())); err != nil {
+		return err
+	}
+	if err := out.CopyProperties(packet); err != nil {
+		return err
+	}
+	if err := out.SideData().Add(astiav.PacketSideDataTypeNewExtradata, encoder.encoder.codecParameters.ExtraData()); err != nil {
+		return err
+	}
+
+	u.switching.sent = sets
+
+	select {
+	case u.parameterSets <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (u *MultiUpdateEncoder) pushPacket(packet *astiav.Packet) error {
+	ctx, cancel := context.WithTimeout(u.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return u.buffer.Push(ctx, packet)
+}
+
+// endOfStreamFrom handles a split encoder that is fully drained. Only the end of the encoder the consumer is on is
+// forwarded; a pending switch whose keyframe was already found, or that replaces a retiring encoder, completes first.
+func (u *MultiUpdateEncoder) endOfStreamFrom(encoder *splitEncoder) {
+	u.switchMux.Lock()
+
+	if encoder == u.switching.target {
+		u.cancelSwitch()
+		u.switchMux.Unlock()
+		return
+	}
+
+	if encoder != u.active.Load() {
+		u.switchMux.Unlock()
+		return
+	}
+
+	if u.switching.target != nil && (u.switching.found || u.switching.retiring) {
+		u.completeSwitch() // NOTE: THE TARGET'S OWN END OF STREAM FOLLOWS
+		u.switchMux.Unlock()
+		return
+	}
+
+	u.cancelSwitch()
+	u.switchMux.Unlock()
+
+	_ = u.eos.push(u.ctx, u.buffer)
+}
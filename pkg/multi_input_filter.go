@@ -0,0 +1,498 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+const (
+	defaultInputSyncDelay = 200 * time.Millisecond
+	multiFilterInputName  = "in"
+	multiFilterOutputName = "out"
+)
+
+// FilterInput is a producer feeding the buffer source labelled Label of a MultiInputFilter graph. The producer needs
+// to describe its frames (CanDescribeMediaFrame), e.g. a GeneralDecoder or another filter.
+type FilterInput struct {
+	Label    string
+	Producer CanProduceMediaFrame
+}
+
+type filterSource struct {
+	label        string
+	producer     CanProduceMediaFrame
+	filter       *astiav.Filter
+	params       *astiav.BuffersrcFilterContextParameters
+	context      *astiav.BuffersrcFilterContext
+	pending      *astiav.Frame // NOTE: READ AHEAD TO ORDER THE INPUTS BY TIMESTAMP
+	pendingAt    time.Time
+	missingSince time.Time // NOTE: ZERO WHILE THE INPUT HAS A FRAME PENDING
+	ended        bool
+}
+
+// receive makes the frame the pending frame of the input; an input that was missing is waited for again.
+func (source *filterSource) receive(frame *astiav.Frame, now time.Time) {
+	source.pending, source.pendingAt = frame, now
+	source.missingSince = time.Time{}
+}
+
+// stalled reports whether a live input without a pending frame has been missing for at least delay. The other inputs
+// no longer wait for a stalled input, until it sends again.
+func (source *filterSource) stalled(now time.Time, delay time.Duration) bool {
+	if source.missingSince.IsZero() {
+		source.missingSince = now
+	}
+	return now.Sub(source.missingSince) >= delay
+}
+
+// time is the timestamp of the pending frame in microseconds; frames without one go first.
+func (source *filterSource) time() int64 {
+	if source.pending.Pts() == astiav.NoPtsValue {
+		return math.MinInt64
+	}
+	return astiav.RescaleQ(source.pending.Pts(), source.params.TimeBase(), astiav.NewRational(1, int(time.Second/time.Microsecond)))
+}
+
+// MultiInputFilter runs a filter graph with several buffer sources and one or more buffersinks. With several inputs it
+// can overlay a second camera for picture-in-picture or amix a microphone with a commentary track; with several outputs
+// it can split one decode into renditions for different encoders. Inputs and outputs are referenced by their labels in
+// the graph:
+//
+//	NewFilterGraphBuilder(
+//		NewFilterChain(NewFilterNode("scale").Set("w", 320).Set("h", -1)).From("pip").To("small"),
+//		NewFilterChain(NewFilterNode("overlay").Set("x", 16).Set("y", 16)).From("main", "small").To("out"),
+//	)
+//
+// Frames are fed in timestamp order across the inputs. An input that falls behind is waited for up to the input sync
+// delay (see WithFilterInputSyncDelay), after which the others go on without it. An input that stops sending without
+// an end of stream is no longer waited for once it has been missing for the sync delay, until it sends again.
+//
+// Every output is a FilterOutput with its own buffer; see Output. The filter waits for a consumer that falls behind,
+// unless its output is set with WithFilterOutputDropWhenFull. The MultiInputFilter itself produces and describes
+// the frames of its first output, so a single output graph is used like a GeneralFilter; WithFilterBufferSize sizes
+// the buffer of that output. The outputs have the media type of the first input.
+type MultiInputFilter struct {
+	*FilterOutput // NOTE: THE FIRST OUTPUT
+
+	content   *FilterGraphBuilder
+	sources   []*filterSource
+	outputs   []*FilterOutput
+	graph     *astiav.FilterGraph
+	input     *astiav.FilterInOut
+	output    *astiav.FilterInOut
+	syncDelay time.Duration
+	onError   func(error)
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// CreateMultiInputFilter creates a MultiInputFilter with several inputs and one output, labelled "out" in the graph.
+// The output can also be left unlabelled.
+func CreateMultiInputFilter(ctx context.Context, inputs []FilterInput, content *FilterGraphBuilder, options ...FilterOption) (*MultiInputFilter, error) {
+	return CreateMultiInputFilterWithOutputs(ctx, inputs, []string{multiFilterOutputName}, content, options...)
+}
+
+// CreateMultiOutputFilter creates a MultiInputFilter with one input, labelled "in" in the graph, and an output per
+// label, e.g. for a 720p and a 1080p rendition of one camera:
+//
+//	NewFilterGraphBuilder(
+//		NewFilterChain(NewFilterNode("split").Arg(2)).From("in").To("hd", "full"),
+//		NewFilterChain(NewFilterNode("scale").Set("w", 1280).Set("h", 720)).From("hd").To("webrtc"),
+//		NewFilterChain(NewFilterNode("null")).From("full").To("record"),
+//	)
+func CreateMultiOutputFilter(ctx context.Context, producer CanProduceMediaFrame, outputs []string, content *FilterGraphBuilder, options ...FilterOption) (*MultiInputFilter, error) {
+	return CreateMultiInputFilterWithOutputs(ctx, []FilterInput{{Label: multiFilterInputName, Producer: producer}}, outputs, content, options...)
+}
+
+// CreateMultiInputFilterWithOutputs creates a MultiInputFilter with an input per FilterInput and an output per label.
+func CreateMultiInputFilterWithOutputs(ctx context.Context, inputs []FilterInput, outputs []string, content *FilterGraphBuilder, options ...FilterOption) (*MultiInputFilter, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("%w: no inputs", ErrorInvalidFilterInput)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("%w: no outputs", ErrorInvalidFilterOutput)
+	}
+	if content == nil {
+		return nil, WarnNoFilterContent
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	filter := &MultiInputFilter{
+		content:   content,
+		syncDelay: defaultInputSyncDelay,
+		ctx:       ctx2,
+		cancel:    cancel,
+	}
+
+	for _, input := range inputs {
+		source, err := filter.newSource(input)
+		if err != nil {
+			filter.close()
+			return nil, err
+		}
+		filter.sources = append(filter.sources, source)
+	}
+
+	sink := videoBufferSinkFilterName
+	if filter.sources[0].filter.Name() == audioBufferFilterName.String() {
+		sink = audioBufferSinkFilterName
+	}
+	filterSink := astiav.FindFilterByName(sink.String())
+	if filterSink == nil {
+		filter.close()
+		return nil, ErrorNoFilterName
+	}
+
+	for _, label := range outputs {
+		if err := filter.checkLabel(label); err != nil {
+			filter.close()
+			return nil, err
+		}
+		filter.outputs = append(filter.outputs, newFilterOutput(ctx2, label, filterSink))
+	}
+	filter.FilterOutput = filter.outputs[0]
+
+	for _, option := range options {
+		if err := option(filter); err != nil {
+			filter.close()
+			return nil, err
+		}
+	}
+
+	for _, output := range filter.outputs {
+		output.setDefaultBuffer(ctx)
+	}
+
+	if err := filter.buildGraph(); err != nil {
+		filter.close()
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// checkLabel makes sure a label of an input or output is valid and used once.
+func (filter *MultiInputFilter) checkLabel(label string) error {
+	if !validFilterLabel(label) {
+		return fmt.Errorf("%w: '%s'", ErrorInvalidFilterLabel, label)
+	}
+	for _, source := range filter.sources {
+		if source.label == label {
+			return fmt.Errorf("%w: duplicate label '%s'", ErrorInvalidFilterLabel, label)
+		}
+	}
+	for _, output := range filter.outputs {
+		if output.label == label {
+			return fmt.Errorf("%w: duplicate label '%s'", ErrorInvalidFilterLabel, label)
+		}
+	}
+	return nil
+}
+
+// Output returns the output with the label, or nil if there is none.
+func (filter *MultiInputFilter) Output(label string) *FilterOutput {
+	for _, output := range filter.outputs {
+		if output.label == label {
+			return output
+		}
+	}
+	return nil
+}
+
+// SetOutputDropWhenFull makes the output with the label drop the frames its consumer has no room for.
+func (filter *MultiInputFilter) SetOutputDropWhenFull(label string) error {
+	output := filter.Output(label)
+	if output == nil {
+		return fmt.Errorf("%w: no output '%s'", ErrorInvalidFilterOutput, label)
+	}
+	output.SetDropWhenFull(true)
+	return nil
+}
+
+func (filter *MultiInputFilter) Outputs() []*FilterOutput {
+	return filter.outputs
+}
+
+func (filter *MultiInputFilter) newSource(input FilterInput) (*filterSource, error) {
+	if err := filter.checkLabel(input.Label); err != nil {
+		return nil, err
+	}
+
+	describe, ok := input.Producer.(CanDescribeMediaFrame)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+
+	source := &filterSource{
+		label:    input.Label,
+		producer: input.Producer,
+		params:   astiav.AllocBuffersrcFilterContextParameters(),
+	}
+	source.params.SetTimeBase(describe.TimeBase())
+
+	switch describe.MediaType() {
+	case astiav.MediaTypeVideo:
+		source.filter = astiav.FindFilterByName(videoBufferFilterName.String())
+		source.params.SetFramerate(describe.FrameRate())
+		source.params.SetHeight(describe.Height())
+		source.params.SetWidth(describe.Width())
+		source.params.SetPixelFormat(describe.PixelFormat())
+		source.params.SetSampleAspectRatio(describe.SampleAspectRatio())
+		source.params.SetColorSpace(describe.ColorSpace())
+		source.params.SetColorRange(describe.ColorRange())
+	case astiav.MediaTypeAudio:
+		source.filter = astiav.FindFilterByName(audioBufferFilterName.String())
+		source.params.SetChannelLayout(describe.ChannelLayout())
+		source.params.SetSampleFormat(describe.SampleFormat())
+		source.params.SetSampleRate(describe.SampleRate())
+	default:
+		source.params.Free()
+		return nil, fmt.Errorf("%w: input '%s' is %s", ErrorInvalidFilterInput, input.Label, describe.MediaType().String())
+	}
+
+	if source.filter == nil {
+		source.params.Free()
+		return nil, ErrorNoFilterName
+	}
+
+	return source, nil
+}
+
+// buildGraph creates a buffer source per input and a buffersink per output, named after their labels, and links them
+// through the graph content.
+func (filter *MultiInputFilter) buildGraph() error {
+	if err := filter.content.Validate(); err != nil {
+		return err
+	}
+
+	filter.graph = astiav.AllocFilterGraph()
+
+	for i := len(filter.sources) - 1; i >= 0; i-- {
+		source := filter.sources[i]
+
+		srcContext, err := filter.graph.NewBuffersrcFilterContext(source.filter, source.label)
+		if err != nil {
+			return ErrorAllocSrcContext
+		}
+		if err = srcContext.SetParameters(source.params); err != nil {
+			return ErrorSrcContextSetParameter
+		}
+		if err = srcContext.Initialize(astiav.NewDictionary()); err != nil {
+			return ErrorSrcContextInitialise
+		}
+		source.context = srcContext
+
+		output := astiav.AllocFilterInOut()
+		output.SetName(source.label)
+		output.SetFilterContext(srcContext.FilterContext())
+		output.SetPadIdx(0)
+		output.SetNext(filter.output)
+		filter.output = output
+	}
+
+	for i := len(filter.outputs) - 1; i >= 0; i-- {
+		output := filter.outputs[i]
+
+		sinkContext, err := filter.graph.NewBuffersinkFilterContext(output.filterSink, output.label)
+		if err != nil {
+			return ErrorAllocSinkContext
+		}
+		output.sinkContext = sinkContext
+
+		input := astiav.AllocFilterInOut()
+		input.SetName(output.label)
+		input.SetFilterContext(sinkContext.FilterContext())
+		input.SetPadIdx(0)
+		input.SetNext(filter.input)
+		filter.input = input
+	}
+
+	if err := filter.graph.Parse(filter.content.String(), filter.input, filter.output); err != nil {
+		return ErrorGraphParse
+	}
+
+	if err := filter.graph.Configure(); err != nil {
+		return ErrorGraphConfigure
+	}
+
+	return nil
+}
+
+func (filter *MultiInputFilter) Start() {
+	go filter.loop()
+}
+
+func (filter *MultiInputFilter) Stop() {
+	filter.cancel()
+}
+
+func (filter *MultiInputFilter) loop() {
+	defer filter.close()
+
+	for {
+		select {
+		case <-filter.ctx.Done():
+			return
+		default:
+			filter.readInputs()
+
+			source := filter.next(time.Now())
+			if source == nil {
+				if filter.ended() {
+					filter.drain()
+					return
+				}
+				continue
+			}
+
+			if err := source.context.AddFrame(source.pending, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+				filter.reportError(fmt.Errorf("%w: adding a frame from '%s': %v", ErrorInvalidFilterInput, source.label, err))
+			}
+			source.producer.PutBack(source.pending)
+			source.pending = nil
+
+			filter.pull()
+		}
+	}
+}
+
+// readInputs reads ahead one frame from every input that has none pending. The inputs share the usual 50ms of
+// waiting, so a stalled input does not hold up the others for longer than that.
+func (filter *MultiInputFilter) readInputs() {
+	waiting := make([]*filterSource, 0, len(filter.sources))
+	for _, source := range filter.sources {
+		if source.pending == nil && !source.ended {
+			waiting = append(waiting, source)
+		}
+	}
+
+	for _, source := range waiting {
+		ctx, cancel := context.WithTimeout(filter.ctx, 50*time.Millisecond/time.Duration(len(waiting)))
+		frame, err := source.producer.GetFrame(ctx)
+		cancel()
+
+		if err != nil {
+			if errors.Is(err, ErrorEndOfStream) {
+				source.ended = true
+				if err := source.context.AddFrame(nil, astiav.NewBuffersrcFlags()); err != nil {
+					filter.reportError(fmt.Errorf("%w: closing '%s': %v", ErrorInvalidFilterInput, source.label, err))
+				}
+			}
+			continue
+		}
+
+		source.receive(frame, time.Now())
+	}
+}
+
+// next returns the input with the earliest pending frame once every live input that is not stalled has one, or once
+// that frame has waited for longer than the sync delay.
+func (filter *MultiInputFilter) next(now time.Time) *filterSource {
+	var (
+		earliest *filterSource
+		complete = true
+	)
+
+	for _, source := range filter.sources {
+		if source.pending == nil {
+			if !source.ended && !source.stalled(now, filter.syncDelay) {
+				complete = false
+			}
+			continue
+		}
+		if earliest == nil || source.time() < earliest.time() {
+			earliest = source
+		}
+	}
+
+	if earliest == nil {
+		return nil
+	}
+	if complete || now.Sub(earliest.pendingAt) >= filter.syncDelay {
+		return earliest
+	}
+	return nil
+}
+
+func (filter *MultiInputFilter) ended() bool {
+	for _, source := range filter.sources {
+		if !source.ended || source.pending != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// pull pushes every frame the outputs have ready.
+func (filter *MultiInputFilter) pull() {
+	for _, output := range filter.outputs {
+		output.pull(filter.reportError)
+	}
+}
+
+// drain pushes the frames left in the graph once every input is closed and signals the end of stream on every output.
+// The frames are taken from the graph first; the outputs then finish side by side, so a consumer that stopped reading
+// does not hold up the others. It then waits for the filter to be stopped, so the outputs stay describable until then.
+func (filter *MultiInputFilter) drain() {
+	var wg sync.WaitGroup
+
+	for _, output := range filter.outputs {
+		frames := output.remaining()
+
+		wg.Add(1)
+		go func(output *FilterOutput) {
+			defer wg.Done()
+			_ = output.finish(frames)
+		}(output)
+	}
+	wg.Wait()
+
+	<-filter.ctx.Done()
+}
+
+// Ctx is the context of the filter; it is done when the filter is stopped.
+func (filter *MultiInputFilter) Ctx() context.Context {
+	return filter.ctx
+}
+
+func (filter *MultiInputFilter) SetInputSyncDelay(delay time.Duration) {
+	filter.syncDelay = delay
+}
+
+func (filter *MultiInputFilter) SetErrorHandler(handler func(error)) {
+	filter.onError = handler
+}
+
+// reportError passes an error of the filtering loop to the handler of WithFilterErrorHandler, if there is one.
+func (filter *MultiInputFilter) reportError(err error) {
+	if filter.onError != nil {
+		filter.onError(err)
+	}
+}
+
+func (filter *MultiInputFilter) close() {
+	for _, source := range filter.sources {
+		if source.pending != nil {
+			source.producer.PutBack(source.pending)
+			source.pending = nil
+		}
+		source.params.Free()
+	}
+
+	if filter.graph != nil {
+		filter.graph.Free()
+	}
+	if filter.input != nil {
+		filter.input.Free()
+	}
+	if filter.output != nil {
+		filter.output.Free() // NOTE: FREES THE WHOLE LIST OF SOURCE OUTPUTS LEFT BY PARSE
+	}
+	filter.cancel()
+}
@@ -0,0 +1,138 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+func testFilterSource(t *testing.T, label string) *filterSource {
+	params := astiav.AllocBuffersrcFilterContextParameters()
+	params.SetTimeBase(astiav.NewRational(1, 1000))
+	t.Cleanup(params.Free)
+
+	return &filterSource{label: label, params: params}
+}
+
+func testFilterFrame(t *testing.T, pts int64) *astiav.Frame {
+	frame := astiav.AllocFrame()
+	frame.SetPts(pts)
+	t.Cleanup(frame.Free)
+
+	return frame
+}
+
+func TestMultiFilterStalledInput(t *testing.T) {
+	const (
+		interval = time.Second / 30
+		duration = 2 * time.Second
+	)
+
+	live := testFilterSource(t, "main")
+	silent := testFilterSource(t, "pip") // NOTE: STOPS SENDING WITHOUT AN END OF STREAM
+	filter := &MultiInputFilter{sources: []*filterSource{live, silent}, syncDelay: defaultInputSyncDelay}
+
+	start := time.Now()
+	now := start
+	released := 0
+
+	for elapsed := time.Duration(0); elapsed < duration; elapsed += interval {
+		now = start.Add(elapsed)
+		if live.pending == nil {
+			live.receive(testFilterFrame(t, elapsed.Milliseconds()), now)
+		}
+
+		if source := filter.next(now); source != nil {
+			if source != live {
+				t.Fatalf("released input '%s', want 'main'", source.label)
+			}
+			source.pending = nil
+			released++
+		}
+	}
+
+	// NOTE: ONLY THE FRAMES OF THE FIRST SYNC DELAY WAIT FOR THE SILENT INPUT
+	if rate := float64(released) / duration.Seconds(); rate < 25 {
+		t.Fatalf("got %.1f frames per second with a stalled input, want at least 25", rate)
+	}
+
+	// NOTE: AN INPUT THAT SENDS AGAIN IS WAITED FOR AGAIN
+	pts := duration.Milliseconds()
+	if live.pending == nil {
+		live.receive(testFilterFrame(t, pts), now)
+	}
+	silent.receive(testFilterFrame(t, pts+10), now)
+	if source := filter.next(now); source != live {
+		t.Fatalf("got %v, want the earlier frame of 'main'", source)
+	}
+	live.pending = nil
+
+	live.receive(testFilterFrame(t, pts+20), now)
+	if source := filter.next(now); source != silent {
+		t.Fatalf("got %v, want the frame of 'pip' that was just sent", source)
+	}
+	silent.pending = nil
+
+	if source := filter.next(now.Add(interval)); source != nil {
+		t.Fatalf("released '%s' without waiting for 'pip', which sent again", source.label)
+	}
+	if source := filter.next(now.Add(interval + defaultInputSyncDelay)); source != live {
+		t.Fatalf("got %v after the sync delay, want 'main'", source)
+	}
+}
+
+// testFrameBuffer is a channel backed frame buffer with no pool behind it.
+type testFrameBuffer struct {
+	channel chan *astiav.Frame
+}
+
+func (b *testFrameBuffer) Push(ctx context.Context, frame *astiav.Frame) error {
+	select {
+	case b.channel <- frame:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *testFrameBuffer) Pop(ctx context.Context) (*astiav.Frame, error) {
+	select {
+	case frame := <-b.channel:
+		return frame, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *testFrameBuffer) Size() int                      { return len(b.channel) }
+func (b *testFrameBuffer) Generate() *astiav.Frame        { return astiav.AllocFrame() }
+func (b *testFrameBuffer) PutBack(frame *astiav.Frame)    { frame.Free() }
+func (b *testFrameBuffer) GetChannel() chan *astiav.Frame { return b.channel }
+
+func TestFilterOutputDropWhenFull(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// NOTE: BY DEFAULT A FULL OUTPUT IS WAITED FOR
+	output := newFilterOutput(ctx, "record", nil)
+	output.SetBuffer(&testFrameBuffer{channel: make(chan *astiav.Frame, 1)})
+	if err := output.pushFrame(testFilterFrame(t, 0)); err != nil {
+		t.Fatalf("got %v, want the first frame pushed", err)
+	}
+	if err := output.pushFrame(testFilterFrame(t, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the push to wait until the output is stopped", err)
+	}
+
+	preview := newFilterOutput(context.Background(), "preview", nil)
+	preview.SetBuffer(&testFrameBuffer{channel: make(chan *astiav.Frame, 1)})
+	preview.SetDropWhenFull(true)
+	if err := preview.pushFrame(testFilterFrame(t, 0)); err != nil {
+		t.Fatalf("got %v, want the first frame pushed", err)
+	}
+	if err := preview.pushFrame(testFilterFrame(t, 1)); !errors.Is(err, ErrorFilterOutputBufferFull) {
+		t.Fatalf("got %v, want %v", err, ErrorFilterOutputBufferFull)
+	}
+}
@@ -0,0 +1,197 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+type GeneralMuxer struct {
+	formatContext    *astiav.FormatContext
+	ioContext        *astiav.IOContext
+	outputOptions    *astiav.Dictionary
+	formatName       string
+	containerAddress string
+	stream           *astiav.Stream
+	producer         CanProduceMediaPacket
+	timeBase         astiav.Rational
+	started          bool
+	onError          func(error)
+	done             chan struct{}
+	ctx              context.Context
+	cancel           context.CancelFunc
+}
+
+func CreateGeneralMuxer(ctx context.Context, canProduceMediaPacket CanProduceMediaPacket, containerAddress string, options ...MuxerOption) (*GeneralMuxer, error) {
+	var err error
+
+	ctx2, cancel := context.WithCancel(ctx)
+	muxer := &GeneralMuxer{
+		producer:         canProduceMediaPacket,
+		containerAddress: containerAddress,
+		outputOptions:    astiav.NewDictionary(),
+		done:             make(chan struct{}),
+		ctx:              ctx2,
+		cancel:           cancel,
+	}
+
+	if muxer.outputOptions == nil {
+		cancel()
+		return nil, ErrorGeneralAllocate
+	}
+
+	canDescribeMediaPacket, ok := canProduceMediaPacket.(CanDescribeMediaPacket)
+	if !ok {
+		return muxer.fail(ErrorInterfaceMismatch)
+	}
+	muxer.timeBase = canDescribeMediaPacket.TimeBase()
+
+	for _, option := range options {
+		if err = option(muxer); err != nil {
+			return muxer.fail(err)
+		}
+	}
+
+	if muxer.formatContext, err = astiav.AllocOutputFormatContext(nil, muxer.formatName, containerAddress); err != nil {
+		return muxer.fail(ErrorAllocateOutputFormatContext)
+	}
+	if muxer.formatContext == nil {
+		return muxer.fail(ErrorAllocateOutputFormatContext)
+	}
+
+	if muxer.stream = muxer.formatContext.NewStream(nil); muxer.stream == nil {
+		return muxer.fail(ErrorAllocateStream)
+	}
+
+	// NOTE: COPIES THE EXTRADATA (SPS/PPS FOR H264) FROM THE PRODUCER INTO THE STREAM HEADER
+	if err = canDescribeMediaPacket.GetCodecParameters().Copy(muxer.stream.CodecParameters()); err != nil {
+		return muxer.fail(ErrorFillCodecContext)
+	}
+	muxer.stream.CodecParameters().SetCodecTag(0)
+	muxer.stream.SetTimeBase(muxer.timeBase)
+	if canDescribeMediaPacket.MediaType() == astiav.MediaTypeVideo {
+		muxer.stream.SetAvgFrameRate(canDescribeMediaPacket.FrameRate())
+	}
+
+	if !muxer.formatContext.OutputFormat().Flags().Has(astiav.IOFormatFlagNofile) {
+		if muxer.ioContext, err = astiav.OpenIOContext(containerAddress, astiav.NewIOContextFlags(astiav.IOContextFlagWrite), nil, nil); err != nil {
+			return muxer.fail(ErrorOpenOutputContainer)
+		}
+		muxer.formatContext.SetPb(muxer.ioContext)
+	}
+
+	if err = muxer.formatContext.WriteHeader(muxer.outputOptions); err != nil {
+		return muxer.fail(err)
+	}
+
+	return muxer, nil
+}
+
+func (muxer *GeneralMuxer) Ctx() context.Context {
+	return muxer.ctx
+}
+
+func (muxer *GeneralMuxer) Start() {
+	muxer.started = true
+	go muxer.loop()
+}
+
+// Stop cancels the muxer and, if it was started, blocks until the trailer is written and the container is closed.
+func (muxer *GeneralMuxer) Stop() {
+	muxer.cancel()
+	if muxer.started {
+		<-muxer.done
+	}
+}
+
+// Done is closed once the trailer is written and the container is closed, either after Stop or after the producer
+// reached the end of stream.
+func (muxer *GeneralMuxer) Done() <-chan struct{} {
+	return muxer.done
+}
+
+func (muxer *GeneralMuxer) loop() {
+	defer close(muxer.done)
+	defer muxer.close()
+
+	for {
+		select {
+		case <-muxer.ctx.Done():
+			return
+		default:
+			packet, err := muxer.getPacket()
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					return
+				}
+				continue
+			}
+
+			packet.SetStreamIndex(muxer.stream.Index())
+			packet.RescaleTs(muxer.timeBase, muxer.stream.TimeBase())
+			packet.SetPos(-1)
+
+			if err := muxer.formatContext.WriteInterleavedFrame(packet); err != nil {
+				muxer.reportError(fmt.Errorf("%w: %v", ErrorWritePacket, err))
+			}
+			muxer.producer.PutBack(packet)
+		}
+	}
+}
+
+func (muxer *GeneralMuxer) getPacket() (*astiav.Packet, error) {
+	ctx, cancel := context.WithTimeout(muxer.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return muxer.producer.GetPacket(ctx)
+}
+
+// fail frees what CreateGeneralMuxer allocated so far; no header was written, so no trailer is written either.
+func (muxer *GeneralMuxer) fail(err error) (*GeneralMuxer, error) {
+	muxer.free()
+	muxer.cancel()
+	return nil, err
+}
+
+func (muxer *GeneralMuxer) close() {
+	if muxer.formatContext != nil {
+		if err := muxer.formatContext.WriteTrailer(); err != nil {
+			muxer.reportError(fmt.Errorf("%w: %v", ErrorWriteTrailer, err))
+		}
+	}
+	muxer.free()
+}
+
+func (muxer *GeneralMuxer) free() {
+	if muxer.ioContext != nil {
+		_ = muxer.ioContext.Close()
+	}
+	if muxer.formatContext != nil {
+		muxer.formatContext.Free()
+	}
+	if muxer.outputOptions != nil {
+		muxer.outputOptions.Free()
+	}
+}
+
+func (muxer *GeneralMuxer) SetErrorHandler(handler func(error)) {
+	muxer.onError = handler
+}
+
+// reportError passes an error of the muxing loop to the handler of WithMuxerErrorHandler, if there is one.
+func (muxer *GeneralMuxer) reportError(err error) {
+	if muxer.onError != nil {
+		muxer.onError(err)
+	}
+}
+
+func (muxer *GeneralMuxer) SetOutputOption(key, value string, flags astiav.DictionaryFlags) error {
+	return muxer.outputOptions.Set(key, value, flags)
+}
+
+func (muxer *GeneralMuxer) SetOutputFormat(formatName string) {
+	muxer.formatName = formatName
+}
@@ -0,0 +1,83 @@
+package transcode
+
+type MuxerOption = func(muxer Muxer) error
+
+func WithMP4MuxerOption(muxer Muxer) error {
+	s, ok := muxer.(CanSetMuxerOutputFormat)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetOutputFormat("mp4")
+
+	o, ok := muxer.(CanSetMuxerOutputOption)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	// NOTE: MOVES THE MOOV ATOM TO THE FRONT ON TRAILER SO THE FILE IS PLAYABLE WHILE DOWNLOADING
+	if err := o.SetOutputOption("movflags", "+faststart", 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func WithFragmentedMP4MuxerOption(muxer Muxer) error {
+	s, ok := muxer.(CanSetMuxerOutputFormat)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetOutputFormat("mp4")
+
+	o, ok := muxer.(CanSetMuxerOutputOption)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	// NOTE: FRAGMENTED MP4 STAYS PLAYABLE EVEN IF THE TRAILER IS NEVER WRITTEN (CRASH, POWER LOSS)
+	if err := o.SetOutputOption("movflags", "frag_keyframe+empty_moov+default_base_moof", 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func WithMatroskaMuxerOption(muxer Muxer) error {
+	s, ok := muxer.(CanSetMuxerOutputFormat)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetOutputFormat("matroska")
+	return nil
+}
+
+func WithMPEGTSMuxerOption(muxer Muxer) error {
+	s, ok := muxer.(CanSetMuxerOutputFormat)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	s.SetOutputFormat("mpegts")
+	return nil
+}
+
+func WithMuxerOutputOption(key, value string) MuxerOption {
+	return func(muxer Muxer) error {
+		s, ok := muxer.(CanSetMuxerOutputOption)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		return s.SetOutputOption(key, value, 0)
+	}
+}
+
+// WithMuxerErrorHandler receives the errors of writing packets and the trailer to the container, like a full disk or
+// an invalid timestamp. The packet is dropped and muxing goes on. The handler is called from the muxing loop and
+// should not block.
+func WithMuxerErrorHandler(handler func(error)) MuxerOption {
+	return func(muxer Muxer) error {
+		s, ok := muxer.(CanSetErrorHandler)
+		if !ok {
+			return ErrorInterfaceMismatch
+		}
+		s.SetErrorHandler(handler)
+		return nil
+	}
+}
@@ -1,245 +1,357 @@
 package transcode
 
-//
-// import (
-// 	"context"
-// 	"errors"
-// 	"fmt"
-// 	"sync"
-// 	"time"
-//
-// 	"github.com/aler9/gomavlib"
-// 	"github.com/aler9/gomavlib/pkg/dialects/ardupilotmega"
-// 	"github.com/aler9/gomavlib/pkg/dialects/common"
-// 	"github.com/asticode/go-astiav"
-// )
-//
-// type Propeller string
-//
-// func (prop Propeller) String() string {
-// 	return string(prop)
-// }
-//
-// const (
-// 	PropellerOne   Propeller = "propeller0"
-// 	PropellerTwo   Propeller = "propeller1"
-// 	PropellerThree Propeller = "propeller2"
-// 	PropellerFour  Propeller = "propeller3"
-// 	PropellerFive  Propeller = "propeller4"
-// 	PropellerSix   Propeller = "propeller5"
-// 	PropellerSeven Propeller = "propeller6"
-// 	PropellerEight Propeller = "propeller7"
-// )
-//
-// type Updator interface {
-// 	Start(*GeneralFilter)
-// }
-//
-// func WithUpdateFilter(updator Updator) FilterOption {
-// 	return func(filter *GeneralFilter) error {
-// 		filter.updators = append(filter.updators, updator)
-// 		return nil
-// 	}
-// }
-//
-// type notch struct {
-// 	prop        Propeller
-// 	harmonics   uint8
-// 	frequencies []float32
-// 	nBlades     uint8
-// }
-//
-// func createNotch(prop Propeller, fundamental float32, harmonics, nBlades uint8) *notch {
-// 	n := &notch{
-// 		prop:        prop,
-// 		harmonics:   harmonics,
-// 		frequencies: make([]float32, harmonics),
-// 		nBlades:     nBlades,
-// 	}
-//
-// 	for i := uint8(0); i < n.harmonics; i++ {
-// 		n.frequencies[i] = fundamental * float32(i+1)
-// 	}
-//
-// 	return n
-// }
-//
-// func (notch *notch) update(rpm float32) {
-// 	fundamental := rpm * float32(notch.nBlades) / 60.0
-// 	for i := uint8(0); i < notch.harmonics; i++ {
-// 		notch.frequencies[i] = (notch.frequencies[i] + fundamental*float32(i+1)) / 2.0
-// 	}
-// }
-//
-// type PropNoiseFilterUpdator struct {
-// 	notches  []*notch
-// 	node     *gomavlib.Node
-// 	interval time.Duration
-// 	mux      sync.RWMutex
-// 	flags    astiav.FilterCommandFlags
-// 	ctx      context.Context
-// }
-//
-// func CreatePropNoiseFilterUpdator(ctx context.Context, mavlinkSerial string, baudrate int, interval time.Duration) (*PropNoiseFilterUpdator, error) {
-// 	updater := &PropNoiseFilterUpdator{
-// 		notches:  make([]*notch, 0),
-// 		flags:    astiav.NewFilterCommandFlags(astiav.FilterCommandFlagFast, astiav.FilterCommandFlagOne),
-// 		interval: interval,
-// 		ctx:      ctx,
-// 	}
-//
-// 	config := gomavlib.NodeConf{
-// 		Endpoints: []gomavlib.EndpointConf{
-// 			gomavlib.EndpointSerial{
-// 				Device: mavlinkSerial,
-// 				Baud:   baudrate,
-// 			},
-// 		},
-// 		Dialect:     ardupilotmega.Dialect,
-// 		OutVersion:  gomavlib.V2,
-// 		OutSystemID: 10,
-// 	}
-//
-// 	node, err := gomavlib.NewNode(config)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	updater.node = node
-//
-// 	return updater, nil
-// }
-//
-// func (update *PropNoiseFilterUpdator) AddNotchFilter(id Propeller, frequency float32, harmonics uint8, nBlades uint8) {
-// 	update.mux.Lock()
-//
-// 	// rpm nBlades will have RPM to rpm conversion with number of blades (Nb / 60)
-// 	update.notches = append(update.notches, createNotch(id, frequency, harmonics, nBlades))
-//
-// 	update.mux.Unlock()
-// }
-//
-// func (update *PropNoiseFilterUpdator) loop1() {
-// 	ticker := time.NewTicker(update.interval)
-// 	defer ticker.Stop()
-//
-// 	for {
-// 		select {
-// 		case <-update.ctx.Done():
-// 			return
-// 		case <-ticker.C:
-// 			update.node.WriteMessageAll(&ardupilotmega.MessageCommandLong{
-// 				TargetSystem:    1,
-// 				TargetComponent: 0,
-// 				Command:         common.MAV_CMD_REQUEST_MESSAGE,
-// 				Confirmation:    0,
-// 				Param1:          float32((&ardupilotmega.MessageEscTelemetry_1To_4{}).GetID()),
-// 				Param2:          0,
-// 				Param3:          0,
-// 				Param4:          0,
-// 				Param5:          0,
-// 				Param6:          0,
-// 				Param7:          0,
-// 			})
-//
-// 			update.node.WriteMessageAll(&ardupilotmega.MessageCommandLong{
-// 				TargetSystem:    1,
-// 				TargetComponent: 0,
-// 				Command:         common.MAV_CMD_REQUEST_MESSAGE,
-// 				Confirmation:    0,
-// 				Param1:          float32((&ardupilotmega.MessageEscTelemetry_5To_8{}).GetID()),
-// 				Param2:          0,
-// 				Param3:          0,
-// 				Param4:          0,
-// 				Param5:          0,
-// 				Param6:          0,
-// 				Param7:          0,
-// 			})
-// 		}
-// 	}
-// }
-//
-// func (update *PropNoiseFilterUpdator) loop2() {
-// 	eventChan := update.node.Events()
-//
-// loop:
-// 	for {
-// 		select {
-// 		case <-update.ctx.Done():
-// 			return
-// 		case event, ok := <-eventChan:
-// 			if !ok {
-// 				return
-// 			}
-//
-// 			if frm, ok := event.(*gomavlib.EventFrame); ok {
-// 				switch msg := frm.Message().(type) {
-// 				case *ardupilotmega.MessageEscTelemetry_1To_4:
-// 					update.mux.Lock()
-//
-// 					length := min(len(update.notches), 4)
-// 					if length <= 0 {
-// 						continue loop
-// 					}
-// 					for i := 0; i < length; i++ {
-// 						update.notches[i].update(float32(msg.Rpm[i]))
-// 					}
-//
-// 					update.mux.Unlock()
-// 				case *ardupilotmega.MessageEscTelemetry_5To_8:
-// 					update.mux.Lock()
-//
-// 					length := min(len(update.notches)-4, 4)
-// 					if length <= 0 {
-// 						continue loop
-// 					}
-// 					for i := 0; i < length; i++ {
-// 						update.notches[i+4].update(float32(msg.Rpm[i]))
-// 					}
-//
-// 					update.mux.Unlock()
-// 				}
-// 			}
-// 		}
-// 	}
-// }
-//
-// func (update *PropNoiseFilterUpdator) loop3(filter *GeneralFilter) {
-// 	ticker := time.NewTicker(update.interval)
-// 	defer ticker.Stop()
-//
-// 	for {
-// 		select {
-// 		case <-update.ctx.Done():
-// 			return
-// 		case <-ticker.C:
-// 			if err := update.update(filter); err != nil {
-// 				fmt.Printf("Error updating notch filter: %v\n", err)
-// 			}
-// 		}
-// 	}
-// }
-//
-// func (update *PropNoiseFilterUpdator) Start(filter *GeneralFilter) {
-// 	go update.loop1()
-// 	go update.loop2()
-// 	go update.loop3(filter)
-// }
-//
-// func (update *PropNoiseFilterUpdator) update(filter *GeneralFilter) error {
-// 	if filter == nil {
-// 		return errors.New("filter is nil")
-// 	}
-// 	filter.mux.Lock()
-// 	defer filter.mux.Unlock()
-//
-// 	for index, notch := range update.notches {
-// 		target := fmt.Sprintf("%s%d", notch.prop.String(), index)
-// 		for _, frequency := range notch.frequencies {
-// 			if _, err := filter.graph.SendCommand(target, "frequency", fmt.Sprintf("%.2f", frequency), update.flags); err != nil {
-// 				return err
-// 			}
-// 		}
-// 	}
-//
-// 	return nil
-// }
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/pkg/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/pkg/dialects/common"
+)
+
+const (
+	defaultNotchUpdateInterval      = 100 * time.Millisecond
+	defaultTelemetryRequestInterval = 200 * time.Millisecond
+	defaultNotchSmoothing           = 0.5
+	defaultNotchMinChange           = 0.5
+	defaultMAVLinkSystemID          = 10
+	defaultMAVLinkTargetSystem      = 1
+	maxESCs                         = 8
+)
+
+// MAVLinkEndpoint is where the updater talks MAVLink to the flight controller or to a router in front of it.
+type MAVLinkEndpoint = gomavlib.EndpointConf
+
+// MAVLinkSerial reads MAVLink from a serial device, e.g. MAVLinkSerial("/dev/ttyACM0", 115200).
+func MAVLinkSerial(device string, baud int) MAVLinkEndpoint {
+	return gomavlib.EndpointSerial{Device: device, Baud: baud}
+}
+
+// MAVLinkUDPServer listens for MAVLink on a UDP address, e.g. MAVLinkUDPServer("0.0.0.0:14550").
+func MAVLinkUDPServer(address string) MAVLinkEndpoint {
+	return gomavlib.EndpointUDPServer{Address: address}
+}
+
+// MAVLinkUDPClient sends MAVLink to a UDP address and reads the replies.
+func MAVLinkUDPClient(address string) MAVLinkEndpoint {
+	return gomavlib.EndpointUDPClient{Address: address}
+}
+
+// MAVLinkTCPServer accepts MAVLink connections on a TCP address.
+func MAVLinkTCPServer(address string) MAVLinkEndpoint {
+	return gomavlib.EndpointTCPServer{Address: address}
+}
+
+// MAVLinkTCPClient connects to a MAVLink TCP server, e.g. MAVLinkTCPClient("127.0.0.1:5760") for a SITL instance.
+func MAVLinkTCPClient(address string) MAVLinkEndpoint {
+	return gomavlib.EndpointTCPClient{Address: address}
+}
+
+// FilterUpdator changes a running filter from outside the media pipeline. See WithFilterUpdator.
+type FilterUpdator interface {
+	Start(CanSendFilterCommand)
+	Stop()
+}
+
+type notch struct {
+	esc         uint8
+	id          string
+	nBlades     uint8
+	frequencies []float32
+	sent        []float32
+}
+
+func createNotch(esc uint8, id string, fundamental float32, harmonics, nBlades uint8) *notch {
+	n := &notch{
+		esc:         esc,
+		id:          id,
+		nBlades:     nBlades,
+		frequencies: make([]float32, harmonics),
+		sent:        make([]float32, harmonics),
+	}
+
+	for i := range n.frequencies {
+		n.frequencies[i] = fundamental * float32(i+1)
+		n.sent[i] = n.frequencies[i]
+	}
+
+	return n
+}
+
+// update moves the harmonics towards the blade passing frequency of the rpm; smoothing is the weight of the new value.
+func (notch *notch) update(rpm float32, smoothing float32) {
+	if rpm <= 0 {
+		return // NOTE: MOTOR STOPPED OR NO RPM SENSOR; THERE IS NO NOISE TO FOLLOW
+	}
+
+	fundamental := rpm * float32(notch.nBlades) / 60.0
+	for i := range notch.frequencies {
+		notch.frequencies[i] += smoothing * (fundamental*float32(i+1) - notch.frequencies[i])
+	}
+}
+
+// target is the filter instance added by WithAudioNotchHarmonicsFilterContent for the harmonic.
+func (notch *notch) target(harmonic int) string {
+	return fmt.Sprintf("bandreject@%s%d", notch.id, harmonic)
+}
+
+// PropNoiseFilterUpdator keeps the bandreject notches of WithAudioNotchHarmonicsFilterContent on the propeller noise.
+// It requests the ESC telemetry of the flight controller over MAVLink, turns the RPM of each motor into the blade
+// passing frequency and its harmonics, smooths them and retunes the notches of the filter with SendCommand.
+type PropNoiseFilterUpdator struct {
+	endpoints       []MAVLinkEndpoint
+	notches         []*notch
+	node            *gomavlib.Node
+	systemID        byte
+	targetSystem    byte
+	interval        time.Duration
+	requestInterval time.Duration
+	smoothing       float32
+	minChange       float32
+	onError         func(error)
+	closeOnce       sync.Once
+	mux             sync.RWMutex
+	ctx             context.Context
+	cancel          context.CancelFunc
+}
+
+func CreatePropNoiseFilterUpdator(ctx context.Context, endpoints []MAVLinkEndpoint, options ...PropNoiseFilterUpdatorOption) (*PropNoiseFilterUpdator, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrorNoMAVLinkEndpoint
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	updator := &PropNoiseFilterUpdator{
+		endpoints:       endpoints,
+		notches:         make([]*notch, 0),
+		systemID:        defaultMAVLinkSystemID,
+		targetSystem:    defaultMAVLinkTargetSystem,
+		interval:        defaultNotchUpdateInterval,
+		requestInterval: defaultTelemetryRequestInterval,
+		smoothing:       defaultNotchSmoothing,
+		minChange:       defaultNotchMinChange,
+		ctx:             ctx2,
+		cancel:          cancel,
+	}
+
+	for _, option := range options {
+		if err := option(updator); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints:   updator.endpoints,
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemID: updator.systemID,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	updator.node = node
+
+	return updator, nil
+}
+
+// AddNotchFilter follows the motor on ESC esc (0 to 7) with the notches added by
+// WithAudioNotchHarmonicsFilterContent(id, fundamental, harmonics, ...). nBlades is the number of blades of its
+// propeller; the fundamental is RPM * nBlades / 60.
+func (update *PropNoiseFilterUpdator) AddNotchFilter(esc uint8, id string, fundamental float32, harmonics uint8, nBlades uint8) error {
+	if esc >= maxESCs {
+		return fmt.Errorf("esc index needs to be less than %d", maxESCs)
+	}
+	if harmonics == 0 || nBlades == 0 {
+		return fmt.Errorf("notch needs at least one harmonic and one blade")
+	}
+
+	update.mux.Lock()
+	defer update.mux.Unlock()
+
+	update.notches = append(update.notches, createNotch(esc, id, fundamental, harmonics, nBlades))
+	return nil
+}
+
+// Frequencies returns the current smoothed frequencies of the notches with the id.
+func (update *PropNoiseFilterUpdator) Frequencies(id string) []float32 {
+	update.mux.RLock()
+	defer update.mux.RUnlock()
+
+	for _, notch := range update.notches {
+		if notch.id == id {
+			return append([]float32(nil), notch.frequencies...)
+		}
+	}
+	return nil
+}
+
+func (update *PropNoiseFilterUpdator) Ctx() context.Context {
+	return update.ctx
+}
+
+// Start requests telemetry and retunes the notches of filter until Stop or until the context is done.
+func (update *PropNoiseFilterUpdator) Start(filter CanSendFilterCommand) {
+	go update.requestLoop()
+	go update.telemetryLoop()
+	go update.updateLoop(filter)
+}
+
+func (update *PropNoiseFilterUpdator) SetErrorHandler(handler func(error)) {
+	update.onError = handler
+}
+
+func (update *PropNoiseFilterUpdator) Stop() {
+	update.cancel()
+	update.close()
+}
+
+func (update *PropNoiseFilterUpdator) close() {
+	update.closeOnce.Do(func() {
+		if update.node != nil {
+			update.node.Close()
+		}
+	})
+}
+
+func (update *PropNoiseFilterUpdator) requestLoop() {
+	ticker := time.NewTicker(update.requestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-update.ctx.Done():
+			return
+		case <-ticker.C:
+			update.requestTelemetry()
+		}
+	}
+}
+
+// requestTelemetry asks for the ESC telemetry messages that carry the motors of the notches.
+func (update *PropNoiseFilterUpdator) requestTelemetry() {
+	update.mux.RLock()
+	first, second := false, false
+	for _, notch := range update.notches {
+		first = first || notch.esc < 4
+		second = second || notch.esc >= 4
+	}
+	update.mux.RUnlock()
+
+	if first {
+		update.node.WriteMessageAll(update.requestMessage((&ardupilotmega.MessageEscTelemetry_1To_4{}).GetID()))
+	}
+	if second {
+		update.node.WriteMessageAll(update.requestMessage((&ardupilotmega.MessageEscTelemetry_5To_8{}).GetID()))
+	}
+}
+
+func (update *PropNoiseFilterUpdator) requestMessage(id uint32) *ardupilotmega.MessageCommandLong {
+	return &ardupilotmega.MessageCommandLong{
+		TargetSystem:    update.targetSystem,
+		TargetComponent: 0,
+		Command:         common.MAV_CMD_REQUEST_MESSAGE,
+		Confirmation:    0,
+		Param1:          float32(id),
+	}
+}
+
+func (update *PropNoiseFilterUpdator) telemetryLoop() {
+	defer update.close()
+
+	events := update.node.Events()
+
+	for {
+		select {
+		case <-update.ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			frame, ok := event.(*gomavlib.EventFrame)
+			if !ok {
+				continue
+			}
+
+			switch msg := frame.Message().(type) {
+			case *ardupilotmega.MessageEscTelemetry_1To_4:
+				update.updateRPM(0, msg.Rpm[:])
+			case *ardupilotmega.MessageEscTelemetry_5To_8:
+				update.updateRPM(4, msg.Rpm[:])
+			}
+		}
+	}
+}
+
+// updateRPM updates the notches of the ESCs from first to first+len(rpm)-1.
+func (update *PropNoiseFilterUpdator) updateRPM(first uint8, rpm []uint16) {
+	update.mux.Lock()
+	defer update.mux.Unlock()
+
+	for _, notch := range update.notches {
+		if notch.esc < first || int(notch.esc-first) >= len(rpm) {
+			continue
+		}
+		notch.update(float32(rpm[notch.esc-first]), update.smoothing)
+	}
+}
+
+func (update *PropNoiseFilterUpdator) updateLoop(filter CanSendFilterCommand) {
+	ticker := time.NewTicker(update.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-update.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := update.update(filter); err != nil && update.onError != nil {
+				update.onError(err)
+			}
+		}
+	}
+}
+
+type notchCommand struct {
+	notch     *notch
+	harmonic  int
+	frequency float32
+}
+
+// update sends the frequencies that moved by more than minChange since they were last sent. The commands are
+// collected first; SendCommand blocks until the filtering loop runs them.
+func (update *PropNoiseFilterUpdator) update(filter CanSendFilterCommand) error {
+	if filter == nil {
+		return ErrorNoFilterToUpdate
+	}
+
+	update.mux.RLock()
+	commands := make([]notchCommand, 0)
+	for _, notch := range update.notches {
+		for i, frequency := range notch.frequencies {
+			if math.Abs(float64(frequency-notch.sent[i])) < float64(update.minChange) {
+				continue
+			}
+			commands = append(commands, notchCommand{notch: notch, harmonic: i, frequency: frequency})
+		}
+	}
+	update.mux.RUnlock()
+
+	for _, command := range commands {
+		if _, err := filter.SendCommand(command.notch.target(command.harmonic), "frequency", fmt.Sprintf("%.2f", command.frequency)); err != nil {
+			return err
+		}
+
+		update.mux.Lock()
+		command.notch.sent[command.harmonic] = command.frequency
+		update.mux.Unlock()
+	}
+
+	return nil
+}
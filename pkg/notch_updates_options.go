@@ -0,0 +1,84 @@
+package transcode
+
+import (
+	"fmt"
+	"time"
+)
+
+type PropNoiseFilterUpdatorOption = func(*PropNoiseFilterUpdator) error
+
+// WithNotchUpdateInterval sets how often changed frequencies are sent to the filter.
+func WithNotchUpdateInterval(interval time.Duration) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		if interval <= 0 {
+			return fmt.Errorf("notch update interval needs to be more than 0")
+		}
+		updator.interval = interval
+		return nil
+	}
+}
+
+// WithTelemetryRequestInterval sets how often the ESC telemetry is requested from the flight controller.
+func WithTelemetryRequestInterval(interval time.Duration) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		if interval <= 0 {
+			return fmt.Errorf("telemetry request interval needs to be more than 0")
+		}
+		updator.requestInterval = interval
+		return nil
+	}
+}
+
+// WithNotchSmoothing sets the weight of a new RPM reading in the notch frequencies; 1 disables smoothing.
+func WithNotchSmoothing(smoothing float32) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		if smoothing <= 0 || smoothing > 1 {
+			return fmt.Errorf("notch smoothing needs to be in (0, 1]")
+		}
+		updator.smoothing = smoothing
+		return nil
+	}
+}
+
+// WithNotchMinChange sets how far, in Hz, a frequency needs to move before the notch is retuned.
+func WithNotchMinChange(hz float32) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		if hz < 0 {
+			return fmt.Errorf("notch minimum change cannot be negative")
+		}
+		updator.minChange = hz
+		return nil
+	}
+}
+
+// WithMAVLinkSystemID sets the system id the updater uses on the MAVLink network.
+func WithMAVLinkSystemID(id byte) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		updator.systemID = id
+		return nil
+	}
+}
+
+// WithMAVLinkTargetSystem sets the system id of the flight controller the telemetry is requested from.
+func WithMAVLinkTargetSystem(id byte) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		updator.targetSystem = id
+		return nil
+	}
+}
+
+// WithNotch adds a notch at construction; see PropNoiseFilterUpdator.AddNotchFilter.
+func WithNotch(esc uint8, id string, fundamental float32, harmonics uint8, nBlades uint8) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		return updator.AddNotchFilter(esc, id, fundamental, harmonics, nBlades)
+	}
+}
+
+// WithNotchUpdateErrorHandler receives the errors of sending the notch frequencies to the filter; they are retried on
+// the next update. The handler is called from the update loop and should not block.
+func WithNotchUpdateErrorHandler(handler func(error)) PropNoiseFilterUpdatorOption {
+	return func(updator *PropNoiseFilterUpdator) error {
+		updator.SetErrorHandler(handler)
+		return nil
+	}
+}
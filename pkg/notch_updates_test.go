@@ -0,0 +1,254 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aler9/gomavlib"
+	"github.com/aler9/gomavlib/pkg/dialects/ardupilotmega"
+	"github.com/aler9/gomavlib/pkg/dialects/common"
+)
+
+// flightController stands in for an ArduPilot autopilot: it answers MAV_CMD_REQUEST_MESSAGE for the ESC telemetry
+// with fixed RPMs.
+type flightController struct {
+	node *gomavlib.Node
+	rpm  [maxESCs]uint16
+}
+
+func startFlightController(t *testing.T, endpoint MAVLinkEndpoint, rpm [maxESCs]uint16) *flightController {
+	t.Helper()
+
+	node, err := gomavlib.NewNode(gomavlib.NodeConf{
+		Endpoints:   []gomavlib.EndpointConf{endpoint},
+		Dialect:     ardupilotmega.Dialect,
+		OutVersion:  gomavlib.V2,
+		OutSystemID: defaultMAVLinkTargetSystem,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create flight controller: %v", err)
+	}
+
+	fc := &flightController{node: node, rpm: rpm}
+	go fc.loop()
+	t.Cleanup(node.Close)
+
+	return fc
+}
+
+func (fc *flightController) loop() {
+	for event := range fc.node.Events() {
+		frame, ok := event.(*gomavlib.EventFrame)
+		if !ok {
+			continue
+		}
+
+		msg, ok := frame.Message().(*ardupilotmega.MessageCommandLong)
+		if !ok || msg.Command != common.MAV_CMD_REQUEST_MESSAGE {
+			continue
+		}
+
+		switch uint32(msg.Param1) {
+		case (&ardupilotmega.MessageEscTelemetry_1To_4{}).GetID():
+			fc.node.WriteMessageAll(&ardupilotmega.MessageEscTelemetry_1To_4{
+				Rpm: [4]uint16{fc.rpm[0], fc.rpm[1], fc.rpm[2], fc.rpm[3]},
+			})
+		case (&ardupilotmega.MessageEscTelemetry_5To_8{}).GetID():
+			fc.node.WriteMessageAll(&ardupilotmega.MessageEscTelemetry_5To_8{
+				Rpm: [4]uint16{fc.rpm[4], fc.rpm[5], fc.rpm[6], fc.rpm[7]},
+			})
+		}
+	}
+}
+
+// commandRecorder stands in for the GeneralFilter and keeps the last argument sent to every target.
+type commandRecorder struct {
+	args map[string]string
+	mux  sync.Mutex
+}
+
+func (recorder *commandRecorder) SendCommand(target, cmd, arg string) (string, error) {
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+
+	if cmd != "frequency" {
+		return "", fmt.Errorf("unexpected command %s", cmd)
+	}
+	recorder.args[target] = arg
+	return "", nil
+}
+
+func (recorder *commandRecorder) QueueCommand(time.Duration, string, string, string) error {
+	return nil
+}
+
+func (recorder *commandRecorder) frequency(target string) float64 {
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+
+	frequency, err := strconv.ParseFloat(recorder.args[target], 64)
+	if err != nil {
+		return 0
+	}
+	return frequency
+}
+
+func freeAddress(t *testing.T, network string) string {
+	t.Helper()
+
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to find a free port: %v", err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	default:
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to find a free port: %v", err)
+		}
+		defer listener.Close()
+		return listener.Addr().String()
+	}
+}
+
+func waitForFrequency(t *testing.T, recorder *commandRecorder, target string, want float64) {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if got := recorder.frequency(target); math.Abs(got-want) < 1 {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("%s: got frequency %.2f, want %.2f", target, recorder.frequency(target), want)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestNotchUpdate(t *testing.T) {
+	n := createNotch(0, "prop0", 100, 3, 2)
+
+	n.update(0, 0.5)
+	if n.frequencies[0] != 100 || n.frequencies[2] != 300 {
+		t.Fatalf("a stopped motor moved the notches: %v", n.frequencies)
+	}
+
+	// 6000 RPM with 2 blades is a blade passing frequency of 200 Hz.
+	n.update(6000, 0.5)
+	if n.frequencies[0] != 150 || n.frequencies[1] != 300 || n.frequencies[2] != 450 {
+		t.Fatalf("unexpected smoothed frequencies: %v", n.frequencies)
+	}
+
+	for i := 0; i < 32; i++ {
+		n.update(6000, 0.5)
+	}
+	if math.Abs(float64(n.frequencies[2]-600)) > 0.01 {
+		t.Fatalf("notches did not converge: %v", n.frequencies)
+	}
+
+	if n.target(2) != "bandreject@prop02" {
+		t.Fatalf("unexpected target %s", n.target(2))
+	}
+}
+
+func TestPropNoiseFilterUpdator(t *testing.T) {
+	rpm := [maxESCs]uint16{4500, 0, 0, 0, 0, 9000, 0, 0}
+
+	tests := []struct {
+		name    string
+		network string
+		server  func(string) MAVLinkEndpoint
+		client  func(string) MAVLinkEndpoint
+	}{
+		{name: "udp", network: "udp", server: MAVLinkUDPServer, client: MAVLinkUDPClient},
+		{name: "tcp", network: "tcp", server: MAVLinkTCPServer, client: MAVLinkTCPClient},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			address := freeAddress(t, test.network)
+			startFlightController(t, test.server(address), rpm)
+
+			updator, err := CreatePropNoiseFilterUpdator(ctx, []MAVLinkEndpoint{test.client(address)},
+				WithNotch(0, "front", 50, 2, 2),
+				WithNotch(5, "rear", 50, 2, 3),
+				WithTelemetryRequestInterval(20*time.Millisecond),
+				WithNotchUpdateInterval(20*time.Millisecond),
+				WithNotchSmoothing(0.5))
+			if err != nil {
+				t.Fatalf("Failed to create updator: %v", err)
+			}
+
+			recorder := &commandRecorder{args: make(map[string]string)}
+			updator.Start(recorder)
+			defer updator.Stop()
+
+			// 4500 RPM * 2 blades / 60 = 150 Hz and 9000 RPM * 3 blades / 60 = 450 Hz.
+			waitForFrequency(t, recorder, "bandreject@front0", 150)
+			waitForFrequency(t, recorder, "bandreject@front1", 300)
+			waitForFrequency(t, recorder, "bandreject@rear0", 450)
+			waitForFrequency(t, recorder, "bandreject@rear1", 900)
+		})
+	}
+}
+
+func TestPropNoiseFilterUpdatorOptions(t *testing.T) {
+	if _, err := CreatePropNoiseFilterUpdator(context.Background(), nil); err != ErrorNoMAVLinkEndpoint {
+		t.Fatalf("got %v, want %v", err, ErrorNoMAVLinkEndpoint)
+	}
+
+	endpoint := MAVLinkUDPServer(freeAddress(t, "udp"))
+	if _, err := CreatePropNoiseFilterUpdator(context.Background(), []MAVLinkEndpoint{endpoint}, WithNotch(maxESCs, "prop", 100, 1, 2)); err == nil {
+		t.Fatalf("accepted a notch on esc %d", maxESCs)
+	}
+	if _, err := CreatePropNoiseFilterUpdator(context.Background(), []MAVLinkEndpoint{endpoint}, WithNotchSmoothing(0)); err == nil {
+		t.Fatalf("accepted a smoothing of 0")
+	}
+}
+
+func TestPropNoiseFilterUpdatorErrorHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	updator, err := CreatePropNoiseFilterUpdator(ctx, []MAVLinkEndpoint{MAVLinkUDPServer(freeAddress(t, "udp"))},
+		WithNotch(0, "front", 50, 2, 2),
+		WithNotchUpdateInterval(20*time.Millisecond),
+		WithNotchUpdateErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create updator: %v", err)
+	}
+
+	updator.Start(nil)
+	defer updator.Stop()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrorNoFilterToUpdate) {
+			t.Fatalf("got %v, want %v", err, ErrorNoFilterToUpdate)
+		}
+	case <-ctx.Done():
+		t.Fatalf("the error handler was not called")
+	}
+}
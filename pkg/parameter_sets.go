@@ -0,0 +1,366 @@
+package transcode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/asticode/go-astiav"
+)
+
+const (
+	hevcNALUTypeVPS = 32
+	hevcNALUTypeSPS = 33
+	hevcNALUTypePPS = 34
+)
+
+var errorUnknownExtraData = errors.New("unknown extradata format")
+
+// ParameterSets are the parameter sets of an H.264 (SPS, PPS) or HEVC (VPS, SPS, PPS) stream, as NAL units without
+// start codes or length prefixes, or the sequence header OBU of an AV1 stream.
+type ParameterSets struct {
+	CodecID        astiav.CodecID
+	VPS            [][]byte
+	SPS            [][]byte
+	PPS            [][]byte
+	SequenceHeader []byte
+}
+
+// AnnexB returns the parameter sets in decoding order, each behind a 4 byte start code; ready to be sent in-band in
+// front of a keyframe.
+func (p ParameterSets) AnnexB() []byte {
+	var data []byte
+
+	for _, nalu := range p.nalus() {
+		data = append(append(data, annexBStartCode...), nalu...)
+	}
+
+	return data
+}
+
+// ConfigurationRecord returns the parameter sets as the decoder configuration record MP4 and Matroska carry as
+// extradata of length prefixed streams: an avcC for H.264 and an hvcC for HEVC.
+func (p ParameterSets) ConfigurationRecord() ([]byte, error) {
+	switch p.CodecID {
+	case astiav.CodecIDH264:
+		return p.avcC()
+	case astiav.CodecIDHevc:
+		return p.hvcC()
+	default:
+		return nil, ErrorBitstreamFormatNotSupported
+	}
+}
+
+// nalus returns the NAL units of the parameter sets in decoding order.
+func (p ParameterSets) nalus() [][]byte {
+	nalus := make([][]byte, 0, len(p.VPS)+len(p.SPS)+len(p.PPS))
+	for _, sets := range [][][]byte{p.VPS, p.SPS, p.PPS} {
+		nalus = append(nalus, sets...)
+	}
+
+	return nalus
+}
+
+func (p ParameterSets) Empty() bool {
+	return len(p.VPS) == 0 && len(p.SPS) == 0 && len(p.PPS) == 0 && len(p.SequenceHeader) == 0
+}
+
+func (p ParameterSets) Equal(other ParameterSets) bool {
+	return p.CodecID == other.CodecID && bytes.Equal(p.AnnexB(), other.AnnexB()) && bytes.Equal(p.SequenceHeader, other.SequenceHeader)
+}
+
+// inBand returns the Annex-B keyframe data with the parameter sets in front of it, unless it already carries them.
+func (p ParameterSets) inBand(data []byte) []byte {
+	if isAV1(p.CodecID) {
+		if p.carriedByOBUs(data) {
+			return data
+		}
+		return withAV1SequenceHeader(p.SequenceHeader, data)
+	}
+
+	if p.carriedBy(splitAnnexB(data)) {
+		return data
+	}
+
+	parameterSets := p.AnnexB()
+	return append(append(make([]byte, 0, len(parameterSets)+len(data)), parameterSets...), data...)
+}
+
+// lengthPrefixedInBand is inBand for length prefixed keyframe data.
+func (p ParameterSets) lengthPrefixedInBand(data []byte) []byte {
+	if isAV1(p.CodecID) {
+		return p.inBand(data) // NOTE: AV1 OBUS ARE THE SAME IN BOTH FORMATS
+	}
+
+	nalus, err := splitLengthPrefixed(data)
+	if err != nil || p.carriedBy(nalus) {
+		return data
+	}
+
+	parameterSets := lengthPrefixed(p.nalus())
+	return append(append(make([]byte, 0, len(parameterSets)+len(data)), parameterSets...), data...)
+}
+
+// carriedBy reports whether the NAL units of a packet already carry an SPS.
+func (p ParameterSets) carriedBy(nalus [][]byte) bool {
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		if p.CodecID == astiav.CodecIDH264 && h264NALUType(nalu) == h264NALUTypeSPS {
+			return true
+		}
+		if p.CodecID == astiav.CodecIDHevc && hevcNALUType(nalu) == hevcNALUTypeSPS {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p ParameterSets) carriedByOBUs(data []byte) bool {
+	obus, err := splitAV1OBUs(data)
+	if err != nil {
+		return false
+	}
+
+	for _, obu := range obus {
+		if obu.obuType == av1OBUSequenceHeader {
+			return true
+		}
+	}
+
+	return false
+}
+
+// avcC returns an AVCDecoderConfigurationRecord (ISO/IEC 14496-15, 5.3.3.1) with 4 byte NAL unit lengths.
+func (p ParameterSets) avcC() ([]byte, error) {
+	if len(p.SPS) == 0 || len(p.SPS[0]) < 4 {
+		return nil, errorBitstreamTooShort
+	}
+
+	sps := p.SPS[0]
+	record := []byte{1, sps[1], sps[2], sps[3], 0xFC | 3, 0xE0 | byte(len(p.SPS))}
+	for _, nalu := range p.SPS {
+		record = appendLengthPrefixed16(record, nalu)
+	}
+
+	record = append(record, byte(len(p.PPS)))
+	for _, nalu := range p.PPS {
+		record = appendLengthPrefixed16(record, nalu)
+	}
+
+	switch sps[1] {
+	case 100, 110, 122, 244:
+		// NOTE: THE HIGH PROFILES ALSO CARRY THEIR CHROMA FORMAT AND BIT DEPTHS
+		parsed, err := parseH264SPS(sps)
+		if err != nil {
+			return nil, err
+		}
+		bitDepth := byte(parsed.bitDepth - 8)
+		record = append(record, 0xFC|byte(parsed.chromaFormatIDC), 0xF8|bitDepth, 0xF8|bitDepth, 0)
+	}
+
+	return record, nil
+}
+
+// hvcC returns an HEVCDecoderConfigurationRecord (ISO/IEC 14496-15, 8.3.3.1) with 4 byte NAL unit lengths.
+func (p ParameterSets) hvcC() ([]byte, error) {
+	if len(p.SPS) == 0 {
+		return nil, errorBitstreamTooShort
+	}
+
+	sps, err := parseHEVCSPS(p.SPS[0])
+	if err != nil {
+		return nil, err
+	}
+
+	record := append([]byte{1}, sps.profileTierLevel[:]...)
+	record = append(record,
+		0xF0, 0x00, // min_spatial_segmentation_idc
+		0xFC, // parallelismType
+		0xFC|byte(sps.chromaFormatIDC),
+		0xF8|byte(sps.bitDepthLuma-8),
+		0xF8|byte(sps.bitDepthChroma-8),
+		0x00, 0x00, // avgFrameRate
+		(sps.maxSubLayersMinus1+1)<<3|sps.temporalIDNesting<<2|3,
+	)
+
+	var arrays [][]byte
+	for i, sets := range [][][]byte{p.VPS, p.SPS, p.PPS} {
+		if len(sets) == 0 {
+			continue
+		}
+
+		array := []byte{0x80 | byte(hevcNALUTypeVPS+i), 0, 0}
+		binary.BigEndian.PutUint16(array[1:], uint16(len(sets)))
+		for _, nalu := range sets {
+			array = appendLengthPrefixed16(array, nalu)
+		}
+		arrays = append(arrays, array)
+	}
+
+	record = append(record, byte(len(arrays)))
+	for _, array := range arrays {
+		record = append(record, array...)
+	}
+
+	return record, nil
+}
+
+func appendLengthPrefixed16(data, nalu []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(data, uint16(len(nalu))), nalu...)
+}
+
+// annexBFirst returns the first parameter set of sets behind a start code; the format GetParameterSets always had.
+func annexBFirst(sets [][]byte) []byte {
+	if len(sets) == 0 {
+		return nil
+	}
+	return append(append([]byte{}, annexBStartCode...), sets[0]...)
+}
+
+// parseParameterSets extracts the parameter sets of H.264 and HEVC extradata, which is either an Annex-B byte stream
+// (what the encoders produce with a global header) or an avcC/hvcC decoder configuration record (what MP4 and
+// Matroska demuxers produce), and the sequence header of AV1 extradata. Other codecs have no parameter sets and return
+// empty ones.
+func parseParameterSets(codecID astiav.CodecID, extraData []byte) (ParameterSets, error) {
+	sets := ParameterSets{CodecID: codecID}
+	if len(extraData) == 0 {
+		return sets, nil
+	}
+
+	if isAV1(codecID) {
+		sequenceHeader, err := parseAV1SequenceHeader(extraData)
+		sets.SequenceHeader = sequenceHeader
+		return sets, err
+	}
+
+	if codecID != astiav.CodecIDH264 && codecID != astiav.CodecIDHevc {
+		return sets, nil
+	}
+
+	var (
+		nalus [][]byte
+		err   error
+	)
+
+	switch {
+	case isAnnexB(extraData):
+		nalus = splitAnnexB(extraData)
+	case codecID == astiav.CodecIDH264:
+		nalus, err = parseAVCC(extraData)
+	default:
+		nalus, err = parseHVCC(extraData)
+	}
+	if err != nil {
+		return sets, err
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		if codecID == astiav.CodecIDH264 {
+			switch h264NALUType(nalu) {
+			case h264NALUTypeSPS:
+				sets.SPS = append(sets.SPS, nalu)
+			case h264NALUTypePPS:
+				sets.PPS = append(sets.PPS, nalu)
+			}
+			continue
+		}
+
+		switch hevcNALUType(nalu) {
+		case hevcNALUTypeVPS:
+			sets.VPS = append(sets.VPS, nalu)
+		case hevcNALUTypeSPS:
+			sets.SPS = append(sets.SPS, nalu)
+		case hevcNALUTypePPS:
+			sets.PPS = append(sets.PPS, nalu)
+		}
+	}
+
+	return sets, nil
+}
+
+func isAnnexB(data []byte) bool {
+	return bytes.HasPrefix(data, annexBStartCode) || bytes.HasPrefix(data, annexBStartCode[1:])
+}
+
+func hevcNALUType(nalu []byte) uint8 {
+	return (nalu[0] >> 1) & 0x3F
+}
+
+// parseAVCC returns the SPS and PPS NAL units of an AVCDecoderConfigurationRecord (ISO/IEC 14496-15, 5.3.3.1).
+func parseAVCC(data []byte) ([][]byte, error) {
+	if len(data) < 7 || data[0] != 1 {
+		return nil, errorUnknownExtraData
+	}
+
+	var nalus [][]byte
+	reader := bytes.NewReader(data[5:])
+
+	for _, countMask := range []byte{0x1F, 0xFF} {
+		count, err := reader.ReadByte()
+		if err != nil {
+			return nil, errorBitstreamTooShort
+		}
+
+		for i := 0; i < int(count&countMask); i++ {
+			nalu, err := readLengthPrefixed(reader)
+			if err != nil {
+				return nil, err
+			}
+			nalus = append(nalus, nalu)
+		}
+	}
+
+	return nalus, nil
+}
+
+// parseHVCC returns the NAL units of the arrays of an HEVCDecoderConfigurationRecord (ISO/IEC 14496-15, 8.3.3.1).
+func parseHVCC(data []byte) ([][]byte, error) {
+	if len(data) < 23 || data[0] != 1 {
+		return nil, errorUnknownExtraData
+	}
+
+	var nalus [][]byte
+	reader := bytes.NewReader(data[23:])
+
+	for arrays := int(data[22]); arrays > 0; arrays-- {
+		var header struct {
+			Type  uint8
+			Count uint16
+		}
+		if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+			return nil, errorBitstreamTooShort
+		}
+
+		for i := 0; i < int(header.Count); i++ {
+			nalu, err := readLengthPrefixed(reader)
+			if err != nil {
+				return nil, err
+			}
+			nalus = append(nalus, nalu)
+		}
+	}
+
+	return nalus, nil
+}
+
+func readLengthPrefixed(reader *bytes.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, errorBitstreamTooShort
+	}
+
+	nalu := make([]byte, length)
+	if _, err := io.ReadFull(reader, nalu); err != nil {
+		return nil, errorBitstreamTooShort
+	}
+
+	return nalu, nil
+}
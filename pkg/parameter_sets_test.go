@@ -0,0 +1,248 @@
+package transcode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/asticode/go-astiav"
+)
+
+var (
+	testH264SPSNALU = []byte{0x67, 0x42, 0xC0, 0x1F, 0xDA}
+	testH264PPSNALU = []byte{0x68, 0xCE, 0x3C, 0x80}
+	testHEVCVPSNALU = []byte{0x40, 0x01, 0x0C}
+	testHEVCSPSNALU = []byte{0x42, 0x01, 0x01}
+	testHEVCPPSNALU = []byte{0x44, 0x01, 0xC1}
+)
+
+func TestParseAVCC(t *testing.T) {
+	record := []byte{1, 0x42, 0xC0, 0x1F, 0xFF, 0xE1, 0x00, 0x05}
+	record = append(record, testH264SPSNALU...)
+	record = append(record, 0x01, 0x00, 0x04)
+	record = append(record, testH264PPSNALU...)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		nalus [][]byte
+		err   error
+	}{
+		{name: "sps and pps", data: record, nalus: [][]byte{testH264SPSNALU, testH264PPSNALU}},
+		{name: "no parameter sets", data: []byte{1, 0x42, 0xC0, 0x1F, 0xFF, 0xE0, 0x00}},
+		{name: "wrong version", data: append([]byte{0}, record[1:]...), err: errorUnknownExtraData},
+		{name: "too short", data: record[:6], err: errorUnknownExtraData},
+		{name: "truncated nal unit", data: record[:len(record)-1], err: errorBitstreamTooShort},
+		{name: "missing pps count", data: record[:8+len(testH264SPSNALU)], err: errorBitstreamTooShort},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nalus, err := parseAVCC(test.data)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && !reflect.DeepEqual(nalus, test.nalus) {
+				t.Fatalf("got %x, want %x", nalus, test.nalus)
+			}
+		})
+	}
+}
+
+func testHVCC(arrays ...[]byte) []byte {
+	record := make([]byte, 23)
+	record[0] = 1
+	record[22] = byte(len(arrays))
+	for _, array := range arrays {
+		record = append(record, array...)
+	}
+	return record
+}
+
+func testHVCCArray(nalus ...[]byte) []byte {
+	array := []byte{0x80 | hevcNALUType(nalus[0]), 0, byte(len(nalus))}
+	for _, nalu := range nalus {
+		array = appendLengthPrefixed16(array, nalu)
+	}
+	return array
+}
+
+func TestParseHVCC(t *testing.T) {
+	record := testHVCC(testHVCCArray(testHEVCVPSNALU), testHVCCArray(testHEVCSPSNALU), testHVCCArray(testHEVCPPSNALU))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		nalus [][]byte
+		err   error
+	}{
+		{name: "vps, sps and pps", data: record, nalus: [][]byte{testHEVCVPSNALU, testHEVCSPSNALU, testHEVCPPSNALU}},
+		{name: "several nal units in an array", data: testHVCC(testHVCCArray(testHEVCPPSNALU, testHEVCPPSNALU)), nalus: [][]byte{testHEVCPPSNALU, testHEVCPPSNALU}},
+		{name: "no arrays", data: testHVCC()},
+		{name: "wrong version", data: append([]byte{0}, record[1:]...), err: errorUnknownExtraData},
+		{name: "too short", data: record[:22], err: errorUnknownExtraData},
+		{name: "truncated array header", data: record[:24], err: errorBitstreamTooShort},
+		{name: "truncated nal unit", data: record[:len(record)-1], err: errorBitstreamTooShort},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nalus, err := parseHVCC(test.data)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && !reflect.DeepEqual(nalus, test.nalus) {
+				t.Fatalf("got %x, want %x", nalus, test.nalus)
+			}
+		})
+	}
+}
+
+func TestParseParameterSets(t *testing.T) {
+	annexB := append(append(append([]byte{}, annexBStartCode...), testH264SPSNALU...), annexBStartCode[1:]...)
+	annexB = append(annexB, testH264PPSNALU...)
+
+	sets, err := parseParameterSets(astiav.CodecIDH264, annexB)
+	if err != nil {
+		t.Fatalf("Failed to parse annex-b extradata: %v", err)
+	}
+	if !reflect.DeepEqual(sets.SPS, [][]byte{testH264SPSNALU}) || !reflect.DeepEqual(sets.PPS, [][]byte{testH264PPSNALU}) {
+		t.Fatalf("got sps %x pps %x", sets.SPS, sets.PPS)
+	}
+
+	hvcc := testHVCC(testHVCCArray(testHEVCVPSNALU), testHVCCArray(testHEVCSPSNALU), testHVCCArray(testHEVCPPSNALU))
+	sets, err = parseParameterSets(astiav.CodecIDHevc, hvcc)
+	if err != nil {
+		t.Fatalf("Failed to parse hvcC extradata: %v", err)
+	}
+	if len(sets.VPS) != 1 || len(sets.SPS) != 1 || len(sets.PPS) != 1 {
+		t.Fatalf("got vps %x sps %x pps %x", sets.VPS, sets.SPS, sets.PPS)
+	}
+
+	if sets, err := parseParameterSets(astiav.CodecIDVp8, []byte{1, 2, 3}); err != nil || !sets.Empty() {
+		t.Fatalf("got %+v, %v for a codec without parameter sets", sets, err)
+	}
+}
+
+func TestAVCC(t *testing.T) {
+	high := testH264SPS{profile: 100, level: 40, chromaFormatIDC: 1, bitDepthLuma: 8, widthInMbs: 120, heightInMapUnits: 68, frameMbsOnly: 1}.nalu()
+	high10 := testH264SPS{profile: 110, level: 51, chromaFormatIDC: 2, bitDepthLuma: 10, widthInMbs: 240, heightInMapUnits: 135, frameMbsOnly: 1}.nalu()
+
+	tests := []struct {
+		name    string
+		sets    ParameterSets
+		header  []byte
+		trailer []byte
+		err     error
+	}{
+		{
+			name:   "baseline",
+			sets:   ParameterSets{SPS: [][]byte{testH264SPSNALU}, PPS: [][]byte{testH264PPSNALU}},
+			header: []byte{1, 0x42, 0xC0, 0x1F, 0xFF, 0xE1},
+		},
+		{
+			name:   "several pps",
+			sets:   ParameterSets{SPS: [][]byte{testH264SPSNALU}, PPS: [][]byte{testH264PPSNALU, testH264PPSNALU}},
+			header: []byte{1, 0x42, 0xC0, 0x1F, 0xFF, 0xE1},
+		},
+		{
+			name:    "high carries chroma format and bit depth",
+			sets:    ParameterSets{SPS: [][]byte{high}, PPS: [][]byte{testH264PPSNALU}},
+			header:  []byte{1, 100, 0, 40, 0xFF, 0xE1},
+			trailer: []byte{0xFD, 0xF8, 0xF8, 0},
+		},
+		{
+			name:    "high 10 4:2:2",
+			sets:    ParameterSets{SPS: [][]byte{high10}, PPS: [][]byte{testH264PPSNALU}},
+			header:  []byte{1, 110, 0, 51, 0xFF, 0xE1},
+			trailer: []byte{0xFE, 0xFA, 0xFA, 0},
+		},
+		{name: "no sps", sets: ParameterSets{PPS: [][]byte{testH264PPSNALU}}, err: errorBitstreamTooShort},
+		{name: "truncated sps", sets: ParameterSets{SPS: [][]byte{{0x67, 0x42}}}, err: errorBitstreamTooShort},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record, err := test.sets.avcC()
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !bytes.HasPrefix(record, test.header) {
+				t.Fatalf("got header %x, want %x", record[:len(test.header)], test.header)
+			}
+			if !bytes.HasSuffix(record, test.trailer) {
+				t.Fatalf("got %x, want it to end with %x", record, test.trailer)
+			}
+
+			nalus, err := parseAVCC(record)
+			if err != nil {
+				t.Fatalf("Failed to parse the record back: %v", err)
+			}
+			if want := append(append([][]byte{}, test.sets.SPS...), test.sets.PPS...); !reflect.DeepEqual(nalus, want) {
+				t.Fatalf("got %x, want %x", nalus, want)
+			}
+		})
+	}
+}
+
+func TestHVCC(t *testing.T) {
+	mainSPS := testHEVCSPS{temporalIDNesting: 1, profileTierLevel: testHEVCMain, chromaFormatIDC: 1, bitDepthLuma: 8, bitDepthChroma: 8}.nalu()
+	main10SPS := testHEVCSPS{maxSubLayersMinus1: 1, profileTierLevel: testHEVCMain, chromaFormatIDC: 2, bitDepthLuma: 10, bitDepthChroma: 10}.nalu()
+
+	tests := []struct {
+		name   string
+		sets   ParameterSets
+		header []byte // NOTE: THE 10 BYTES AFTER general_level_idc
+		err    error
+	}{
+		{
+			name:   "main",
+			sets:   ParameterSets{VPS: [][]byte{testHEVCVPSNALU}, SPS: [][]byte{mainSPS}, PPS: [][]byte{testHEVCPPSNALU}},
+			header: []byte{0xF0, 0x00, 0xFC, 0xFD, 0xF8, 0xF8, 0x00, 0x00, 1<<3 | 1<<2 | 3, 3},
+		},
+		{
+			name:   "main 10 4:2:2 with sub-layers",
+			sets:   ParameterSets{VPS: [][]byte{testHEVCVPSNALU}, SPS: [][]byte{main10SPS}, PPS: [][]byte{testHEVCPPSNALU}},
+			header: []byte{0xF0, 0x00, 0xFC, 0xFE, 0xFA, 0xFA, 0x00, 0x00, 2<<3 | 3, 3},
+		},
+		{
+			name:   "no vps",
+			sets:   ParameterSets{SPS: [][]byte{mainSPS}, PPS: [][]byte{testHEVCPPSNALU, testHEVCPPSNALU}},
+			header: []byte{0xF0, 0x00, 0xFC, 0xFD, 0xF8, 0xF8, 0x00, 0x00, 1<<3 | 1<<2 | 3, 2},
+		},
+		{name: "no sps", sets: ParameterSets{VPS: [][]byte{testHEVCVPSNALU}}, err: errorBitstreamTooShort},
+		{name: "sps that does not parse", sets: ParameterSets{SPS: [][]byte{testHEVCSPSNALU}}, err: errorBitstreamTooShort},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record, err := test.sets.hvcC()
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if record[0] != 1 || !bytes.Equal(record[1:13], testHEVCMain[:]) {
+				t.Fatalf("got version and profile tier level %x", record[:13])
+			}
+			if !bytes.Equal(record[13:23], test.header) {
+				t.Fatalf("got %x, want %x", record[13:23], test.header)
+			}
+
+			nalus, err := parseHVCC(record)
+			if err != nil {
+				t.Fatalf("Failed to parse the record back: %v", err)
+			}
+			if want := test.sets.nalus(); !reflect.DeepEqual(nalus, want) {
+				t.Fatalf("got %x, want %x", nalus, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,479 @@
+package transcode
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+	"github.com/harshabose/tools/buffer/pkg"
+)
+
+// rtpFrame is the access unit being reassembled from the RTP packets sharing one timestamp.
+type rtpFrame struct {
+	data      []byte
+	timestamp uint32
+	keyFrame  bool
+	broken    bool
+	active    bool
+}
+
+func (frame *rtpFrame) reset() {
+	frame.data = frame.data[:0]
+	frame.keyFrame = false
+	frame.broken = false
+	frame.active = false
+}
+
+// RTPDemuxer is the RTP counterpart of GeneralDemuxer. RTP packets pushed with PushRTPPacket are reordered,
+// depacketized (H264 FU-A/STAP-A, VP8 partitions, Opus) and reassembled into one astiav.Packet per frame, with the
+// RTP timestamp as PTS in a 1/clock-rate time base. For H264 the codec parameters are synthesized from the in-band
+// SPS/PPS; call WaitForCodecParameters before handing the demuxer to CreateGeneralDecoder.
+type RTPDemuxer struct {
+	codecID         astiav.CodecID
+	mediaType       astiav.MediaType
+	clockRate       uint32
+	frameRate       astiav.Rational
+	codecParameters *astiav.CodecParameters
+	depacketizer    rtp.Depacketizer
+	reorder         *rtpReorderBuffer
+	frame           rtpFrame
+	sps             []byte
+	pps             []byte
+	lastTimestamp   uint32
+	extTimestamp    int64
+	timestampSet    bool
+	keyFrameSeen    bool
+	input           buffer.BufferWithGenerator[rtp.Packet]
+	inputEOS        *endOfStream[rtp.Packet]
+	buffer          buffer.BufferWithGenerator[astiav.Packet]
+	eos             *endOfStream[astiav.Packet]
+	ready           chan struct{}
+	readyOnce       sync.Once
+	mux             sync.RWMutex
+	ctx             context.Context
+	cancel          context.CancelFunc
+}
+
+func CreateRTPDemuxer(ctx context.Context, codecID astiav.CodecID, options ...RTPDemuxerOption) (*RTPDemuxer, error) {
+	ctx2, cancel := context.WithCancel(ctx)
+	demuxer := &RTPDemuxer{
+		codecID:         codecID,
+		frameRate:       astiav.NewRational(0, 1),
+		codecParameters: astiav.AllocCodecParameters(),
+		reorder:         newRTPReorderBuffer(defaultRTPReorderWindow),
+		inputEOS:        newEndOfStream[rtp.Packet](),
+		eos:             newEndOfStream[astiav.Packet](),
+		ready:           make(chan struct{}),
+		ctx:             ctx2,
+		cancel:          cancel,
+	}
+
+	if demuxer.codecParameters == nil {
+		return nil, ErrorGeneralAllocate
+	}
+
+	if err := demuxer.setDepacketizer(); err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		if err := option(demuxer); err != nil {
+			return nil, err
+		}
+	}
+
+	if demuxer.input == nil {
+		demuxer.input = buffer.CreateChannelBuffer(ctx2, 256, internal.CreateRTPPool())
+	}
+	if demuxer.buffer == nil {
+		demuxer.buffer = buffer.CreateChannelBuffer(ctx2, 256, internal.CreatePacketPool())
+	}
+
+	return demuxer, nil
+}
+
+func (demuxer *RTPDemuxer) setDepacketizer() error {
+	demuxer.codecParameters.SetCodecID(demuxer.codecID)
+
+	switch demuxer.codecID {
+	case astiav.CodecIDH264:
+		demuxer.depacketizer = &codecs.H264Packet{}
+		demuxer.mediaType = astiav.MediaTypeVideo
+		demuxer.clockRate = videoRTPClockRate
+	case astiav.CodecIDVp8:
+		demuxer.depacketizer = &codecs.VP8Packet{}
+		demuxer.mediaType = astiav.MediaTypeVideo
+		demuxer.clockRate = videoRTPClockRate
+	case astiav.CodecIDOpus:
+		demuxer.depacketizer = &codecs.OpusPacket{}
+		demuxer.mediaType = astiav.MediaTypeAudio
+		demuxer.clockRate = opusRTPClockRate
+	default:
+		return ErrorRTPCodecNotSupported
+	}
+
+	demuxer.codecParameters.SetMediaType(demuxer.mediaType)
+	if demuxer.mediaType == astiav.MediaTypeVideo {
+		demuxer.codecParameters.SetPixelFormat(astiav.PixelFormatYuv420P)
+		return nil
+	}
+
+	// NOTE: OPUS OVER RTP ALWAYS SIGNALS 48kHz STEREO (RFC 7587); THE DECODER DOWN-MIXES IF NEEDED
+	demuxer.codecParameters.SetSampleRate(opusRTPClockRate)
+	demuxer.codecParameters.SetChannelLayout(astiav.ChannelLayoutStereo)
+	demuxer.markReady()
+
+	return nil
+}
+
+func (demuxer *RTPDemuxer) Ctx() context.Context {
+	return demuxer.ctx
+}
+
+func (demuxer *RTPDemuxer) Start() {
+	go demuxer.loop()
+}
+
+func (demuxer *RTPDemuxer) Stop() {
+	demuxer.cancel()
+}
+
+// PushRTPPacket queues a copy of the packet for depacketization; the caller keeps ownership of the given packet.
+func (demuxer *RTPDemuxer) PushRTPPacket(ctx context.Context, packet *rtp.Packet) error {
+	return demuxer.input.Push(ctx, packet.Clone())
+}
+
+// EndOfStream tells the demuxer that no more RTP packets will be pushed. Held packets and the pending frame are
+// flushed before GetPacket starts returning ErrorEndOfStream.
+func (demuxer *RTPDemuxer) EndOfStream(ctx context.Context) error {
+	return demuxer.inputEOS.push(ctx, demuxer.input)
+}
+
+// WaitForCodecParameters blocks until the codec parameters are known; for H264 this is after the first SPS and PPS,
+// for Opus it returns immediately.
+func (demuxer *RTPDemuxer) WaitForCodecParameters(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-demuxer.ready:
+		return nil
+	}
+}
+
+func (demuxer *RTPDemuxer) markReady() {
+	demuxer.readyOnce.Do(func() {
+		close(demuxer.ready)
+	})
+}
+
+func (demuxer *RTPDemuxer) isReady() bool {
+	select {
+	case <-demuxer.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func (demuxer *RTPDemuxer) loop() {
+	defer demuxer.close()
+
+	for {
+		select {
+		case <-demuxer.ctx.Done():
+			return
+		default:
+			packet, err := demuxer.getRTPPacket()
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					demuxer.endOfStream()
+					return
+				}
+				demuxer.drainReorder() // NOTE: A GAP MAY HAVE OUTLIVED THE MAXIMUM WAIT WHILE NOTHING ARRIVED
+				continue
+			}
+
+			if !demuxer.reorder.push(packet) {
+				demuxer.input.PutBack(packet) // NOTE: LATE OR DUPLICATE
+				continue
+			}
+
+			demuxer.drainReorder()
+		}
+	}
+}
+
+func (demuxer *RTPDemuxer) drainReorder() {
+	now := time.Now()
+	for {
+		packet, lost := demuxer.reorder.pop(now)
+		if packet == nil {
+			return
+		}
+
+		demuxer.depacketize(packet, lost)
+		demuxer.input.PutBack(packet)
+	}
+}
+
+func (demuxer *RTPDemuxer) endOfStream() {
+	demuxer.drainReorder()
+	for _, released := range demuxer.reorder.flush() { // NOTE: GIVE UP ON THE GAPS; NOTHING MORE WILL ARRIVE
+		demuxer.depacketize(released.packet, released.lost)
+		demuxer.input.PutBack(released.packet)
+	}
+	demuxer.flushFrame()
+
+	if err := demuxer.eos.push(demuxer.ctx, demuxer.buffer); err != nil {
+		return
+	}
+	<-demuxer.ctx.Done()
+}
+
+func (demuxer *RTPDemuxer) depacketize(packet *rtp.Packet, lost bool) {
+	if lost {
+		// NOTE: THE MISSING PACKETS MAY BELONG TO THE CURRENT FRAME OR TO THE ONE THIS PACKET STARTS; DROP BOTH AND
+		// ANY FU-A FRAGMENTS HELD BY THE DEPACKETIZER
+		demuxer.frame.broken = true
+		if demuxer.codecID == astiav.CodecIDH264 {
+			demuxer.depacketizer = &codecs.H264Packet{}
+		}
+	}
+
+	if demuxer.frame.active && packet.Timestamp != demuxer.frame.timestamp {
+		demuxer.flushFrame() // NOTE: THE MARKER PACKET OF THE PREVIOUS FRAME NEVER ARRIVED
+	}
+	if !demuxer.frame.active {
+		demuxer.frame.active = true
+		demuxer.frame.timestamp = packet.Timestamp
+		demuxer.frame.broken = lost && demuxer.mediaType == astiav.MediaTypeVideo
+	}
+
+	payload, err := demuxer.depacketizer.Unmarshal(packet.Payload)
+	if err != nil {
+		demuxer.frame.broken = true
+	} else {
+		demuxer.inspect(payload)
+		demuxer.frame.data = append(demuxer.frame.data, payload...)
+	}
+
+	if packet.Marker || demuxer.mediaType == astiav.MediaTypeAudio {
+		demuxer.flushFrame()
+	}
+}
+
+// inspect looks for keyframes and parameter sets in a depacketized payload.
+func (demuxer *RTPDemuxer) inspect(payload []byte) {
+	switch depacketizer := demuxer.depacketizer.(type) {
+	case *codecs.H264Packet:
+		demuxer.inspectH264(payload)
+	case *codecs.VP8Packet:
+		if depacketizer.S == 1 && depacketizer.PID == 0 {
+			demuxer.inspectVP8(payload)
+		}
+	case *codecs.OpusPacket:
+		demuxer.frame.keyFrame = true
+	}
+}
+
+func (demuxer *RTPDemuxer) inspectH264(payload []byte) {
+	parametersChanged := false
+
+	for _, nalu := range splitAnnexB(payload) {
+		switch h264NALUType(nalu) {
+		case h264NALUTypeIDR:
+			demuxer.frame.keyFrame = true
+		case h264NALUTypeSPS:
+			if string(nalu) != string(demuxer.sps) {
+				demuxer.sps = append(demuxer.sps[:0], nalu...)
+				parametersChanged = true
+			}
+		case h264NALUTypePPS:
+			if string(nalu) != string(demuxer.pps) {
+				demuxer.pps = append(demuxer.pps[:0], nalu...)
+				parametersChanged = true
+			}
+		}
+	}
+
+	if parametersChanged && demuxer.sps != nil && demuxer.pps != nil {
+		demuxer.updateH264Parameters()
+	}
+}
+
+func (demuxer *RTPDemuxer) updateH264Parameters() {
+	sps, err := parseH264SPS(demuxer.sps)
+	if err != nil {
+		return
+	}
+
+	extraData := make([]byte, 0, 2*len(annexBStartCode)+len(demuxer.sps)+len(demuxer.pps))
+	extraData = append(append(extraData, annexBStartCode...), demuxer.sps...)
+	extraData = append(append(extraData, annexBStartCode...), demuxer.pps...)
+
+	demuxer.mux.Lock()
+	demuxer.codecParameters.SetWidth(sps.width)
+	demuxer.codecParameters.SetHeight(sps.height)
+	demuxer.codecParameters.SetPixelFormat(sps.pixelFormat())
+	demuxer.codecParameters.SetProfile(astiav.Profile(sps.profile))
+	demuxer.codecParameters.SetLevel(astiav.Level(sps.level))
+	err = demuxer.codecParameters.SetExtraData(extraData)
+	demuxer.mux.Unlock()
+
+	if err == nil {
+		demuxer.markReady()
+	}
+}
+
+// inspectVP8 reads the uncompressed data chunk at the start of a VP8 frame (RFC 6386 section 9.1). Keyframes carry
+// the frame dimensions.
+func (demuxer *RTPDemuxer) inspectVP8(payload []byte) {
+	if len(payload) == 0 || payload[0]&0x01 != 0 {
+		return
+	}
+	demuxer.frame.keyFrame = true
+
+	if len(payload) < 10 || payload[3] != 0x9d || payload[4] != 0x01 || payload[5] != 0x2a {
+		return
+	}
+
+	width := int(binary.LittleEndian.Uint16(payload[6:8]) & 0x3fff)
+	height := int(binary.LittleEndian.Uint16(payload[8:10]) & 0x3fff)
+
+	demuxer.mux.Lock()
+	demuxer.codecParameters.SetWidth(width)
+	demuxer.codecParameters.SetHeight(height)
+	demuxer.mux.Unlock()
+
+	demuxer.markReady()
+}
+
+// unwrapTimestamp extends the 32-bit RTP timestamp to 64 bits, relative to the first frame.
+func (demuxer *RTPDemuxer) unwrapTimestamp(timestamp uint32) int64 {
+	if !demuxer.timestampSet {
+		demuxer.timestampSet = true
+		demuxer.lastTimestamp = timestamp
+	}
+
+	demuxer.extTimestamp += int64(int32(timestamp - demuxer.lastTimestamp))
+	demuxer.lastTimestamp = timestamp
+
+	return demuxer.extTimestamp
+}
+
+func (demuxer *RTPDemuxer) flushFrame() {
+	defer demuxer.frame.reset()
+
+	if !demuxer.frame.active {
+		return
+	}
+
+	pts := demuxer.unwrapTimestamp(demuxer.frame.timestamp)
+
+	if demuxer.frame.broken || len(demuxer.frame.data) == 0 || !demuxer.isReady() {
+		return
+	}
+	if !demuxer.keyFrameSeen {
+		if !demuxer.frame.keyFrame {
+			return // NOTE: DECODING CAN ONLY START ON A KEYFRAME
+		}
+		demuxer.keyFrameSeen = true
+	}
+
+	packet := demuxer.buffer.Generate()
+	if err := packet.FromData(demuxer.frame.data); err != nil {
+		demuxer.buffer.PutBack(packet)
+		return
+	}
+
+	// NOTE: WEBRTC PROFILES HAVE NO B-FRAMES, SO DTS EQUALS PTS
+	packet.SetPts(pts)
+	packet.SetDts(pts)
+	if demuxer.frame.keyFrame {
+		packet.SetFlags(astiav.NewPacketFlags(astiav.PacketFlagKey))
+	}
+
+	if err := demuxer.pushPacket(packet); err != nil {
+		demuxer.buffer.PutBack(packet)
+	}
+}
+
+func (demuxer *RTPDemuxer) getRTPPacket() (*rtp.Packet, error) {
+	ctx, cancel := context.WithTimeout(demuxer.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return demuxer.inputEOS.pop(ctx, demuxer.input)
+}
+
+func (demuxer *RTPDemuxer) pushPacket(packet *astiav.Packet) error {
+	ctx, cancel := context.WithTimeout(demuxer.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return demuxer.buffer.Push(ctx, packet)
+}
+
+func (demuxer *RTPDemuxer) close() {
+	<-demuxer.ctx.Done()
+
+	demuxer.mux.Lock()
+	defer demuxer.mux.Unlock()
+
+	if demuxer.codecParameters != nil {
+		demuxer.codecParameters.Free()
+		demuxer.codecParameters = nil
+	}
+}
+
+func (demuxer *RTPDemuxer) SetBuffer(buffer buffer.BufferWithGenerator[astiav.Packet]) {
+	demuxer.buffer = buffer
+}
+
+func (demuxer *RTPDemuxer) SetReorderWindow(window int) {
+	demuxer.reorder.window = window
+}
+
+func (demuxer *RTPDemuxer) SetReorderMaxWait(maxWait time.Duration) {
+	demuxer.reorder.maxWait = maxWait
+}
+
+// ## CanProduceMediaPacket
+
+func (demuxer *RTPDemuxer) GetPacket(ctx context.Context) (*astiav.Packet, error) {
+	return demuxer.eos.pop(ctx, demuxer.buffer)
+}
+
+func (demuxer *RTPDemuxer) PutBack(packet *astiav.Packet) {
+	demuxer.buffer.PutBack(packet)
+}
+
+// ## CanDescribeMediaPacket
+
+func (demuxer *RTPDemuxer) GetCodecParameters() *astiav.CodecParameters {
+	demuxer.mux.RLock()
+	defer demuxer.mux.RUnlock()
+
+	return demuxer.codecParameters
+}
+
+func (demuxer *RTPDemuxer) MediaType() astiav.MediaType {
+	return demuxer.mediaType
+}
+
+func (demuxer *RTPDemuxer) CodecID() astiav.CodecID {
+	return demuxer.codecID
+}
+
+func (demuxer *RTPDemuxer) FrameRate() astiav.Rational {
+	return demuxer.frameRate
+}
+
+func (demuxer *RTPDemuxer) TimeBase() astiav.Rational {
+	return astiav.NewRational(1, int(demuxer.clockRate))
+}
@@ -0,0 +1,52 @@
+package transcode
+
+import (
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+type RTPDemuxerOption = func(*RTPDemuxer) error
+
+// WithRTPReorderWindow sets how many out-of-order packets are held while waiting for a missing one. Larger windows
+// survive more reordering at the cost of latency when packets are actually lost.
+func WithRTPReorderWindow(window int) RTPDemuxerOption {
+	return func(demuxer *RTPDemuxer) error {
+		demuxer.SetReorderWindow(window)
+		return nil
+	}
+}
+
+// WithRTPReorderMaxWait sets how long a missing packet is waited for before it is given up on as lost, even if the
+// reorder window is not full. 0 waits until the window is full.
+func WithRTPReorderMaxWait(maxWait time.Duration) RTPDemuxerOption {
+	return func(demuxer *RTPDemuxer) error {
+		demuxer.SetReorderMaxWait(maxWait)
+		return nil
+	}
+}
+
+// WithRTPDemuxerFrameRate sets the frame rate reported to the decoder; RTP itself does not carry one.
+func WithRTPDemuxerFrameRate(fps int) RTPDemuxerOption {
+	return func(demuxer *RTPDemuxer) error {
+		demuxer.frameRate = astiav.NewRational(fps, 1)
+		return nil
+	}
+}
+
+func WithRTPDemuxerClockRate(clockRate uint32) RTPDemuxerOption {
+	return func(demuxer *RTPDemuxer) error {
+		demuxer.clockRate = clockRate
+		return nil
+	}
+}
+
+func WithRTPDemuxerBufferSize(size int) RTPDemuxerOption {
+	return func(demuxer *RTPDemuxer) error {
+		demuxer.SetBuffer(buffer.CreateChannelBuffer(demuxer.Ctx(), size, internal.CreatePacketPool()))
+		return nil
+	}
+}
@@ -0,0 +1,222 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+	"github.com/harshabose/tools/buffer/pkg"
+)
+
+const (
+	rtpHeaderSize      = 12
+	defaultRTPMTU      = 1200
+	videoRTPClockRate  = 90000
+	opusRTPClockRate   = 48000
+	defaultPayloadType = 96
+)
+
+// RTPPacketizer turns the packets of any packet producer (Encoder, Transcoder, ...) into RTP packets, using the
+// payloader that matches the producer's codec and converting the producer's time base to the RTP clock rate.
+type RTPPacketizer struct {
+	producer        CanProduceMediaPacket
+	payloader       rtp.Payloader
+	sequencer       rtp.Sequencer
+	mediaType       astiav.MediaType
+	mtu             uint16
+	payloadType     uint8
+	ssrc            uint32
+	clockRate       uint32
+	timestampOffset uint32
+	timeBase        astiav.Rational
+	lastPts         int64
+	buffer          buffer.BufferWithGenerator[rtp.Packet]
+	eos             *endOfStream[rtp.Packet]
+	ctx             context.Context
+	cancel          context.CancelFunc
+}
+
+func CreateRTPPacketizer(ctx context.Context, canProduceMediaPacket CanProduceMediaPacket, options ...RTPPacketizerOption) (*RTPPacketizer, error) {
+	ctx2, cancel := context.WithCancel(ctx)
+	packetizer := &RTPPacketizer{
+		producer:        canProduceMediaPacket,
+		sequencer:       rtp.NewRandomSequencer(),
+		mtu:             defaultRTPMTU,
+		payloadType:     defaultPayloadType,
+		ssrc:            rand.Uint32(),
+		timestampOffset: rand.Uint32(),
+		eos:             newEndOfStream[rtp.Packet](),
+		ctx:             ctx2,
+		cancel:          cancel,
+	}
+
+	canDescribeMediaPacket, ok := canProduceMediaPacket.(CanDescribeMediaPacket)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+	packetizer.mediaType = canDescribeMediaPacket.MediaType()
+	packetizer.timeBase = canDescribeMediaPacket.TimeBase()
+
+	if err := packetizer.setPayloader(canDescribeMediaPacket); err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		if err := option(packetizer); err != nil {
+			return nil, err
+		}
+	}
+
+	if packetizer.mtu <= rtpHeaderSize {
+		return nil, ErrorRTPMTUTooSmall
+	}
+
+	if packetizer.buffer == nil {
+		packetizer.buffer = buffer.CreateChannelBuffer(ctx2, 256, internal.CreateRTPPool())
+	}
+
+	return packetizer, nil
+}
+
+func (packetizer *RTPPacketizer) setPayloader(describer CanDescribeMediaPacket) error {
+	switch describer.CodecID() {
+	case astiav.CodecIDH264:
+		if extraData := describer.GetCodecParameters().ExtraData(); len(extraData) > 0 && !isAnnexB(extraData) {
+			// NOTE: THE PAYLOADER SPLITS THE PACKETS ON START CODES
+			return fmt.Errorf("%w: rtp needs %s packets", ErrorBitstreamFormatNotSupported, BitstreamFormatAnnexB)
+		}
+		packetizer.payloader = &codecs.H264Payloader{}
+		packetizer.clockRate = videoRTPClockRate
+	case astiav.CodecIDVp8:
+		packetizer.payloader = &codecs.VP8Payloader{EnablePictureID: true}
+		packetizer.clockRate = videoRTPClockRate
+	case astiav.CodecIDVp9:
+		packetizer.payloader = &codecs.VP9Payloader{}
+		packetizer.clockRate = videoRTPClockRate
+	case astiav.CodecIDOpus:
+		packetizer.payloader = &codecs.OpusPayloader{}
+		packetizer.clockRate = opusRTPClockRate // NOTE: RFC 7587; ALWAYS 48kHz REGARDLESS OF THE INPUT SAMPLE RATE
+	default:
+		return ErrorRTPCodecNotSupported
+	}
+
+	return nil
+}
+
+func (packetizer *RTPPacketizer) Ctx() context.Context {
+	return packetizer.ctx
+}
+
+func (packetizer *RTPPacketizer) Start() {
+	go packetizer.loop()
+}
+
+func (packetizer *RTPPacketizer) Stop() {
+	packetizer.cancel()
+}
+
+func (packetizer *RTPPacketizer) loop() {
+	for {
+		select {
+		case <-packetizer.ctx.Done():
+			return
+		default:
+			packet, err := packetizer.getPacket()
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					if err := packetizer.eos.push(packetizer.ctx, packetizer.buffer); err == nil {
+						<-packetizer.ctx.Done()
+					}
+					return
+				}
+				continue
+			}
+
+			packetizer.packetize(packet.Data(), packetizer.rtpTimestamp(packet))
+			packetizer.producer.PutBack(packet)
+		}
+	}
+}
+
+func (packetizer *RTPPacketizer) packetize(data []byte, timestamp uint32) {
+	payloads := packetizer.payloader.Payload(packetizer.mtu-rtpHeaderSize, data)
+
+	for i, payload := range payloads {
+		packet := packetizer.buffer.Generate()
+		packet.Header = rtp.Header{
+			Version:        2,
+			Marker:         packetizer.mediaType == astiav.MediaTypeVideo && i == len(payloads)-1,
+			PayloadType:    packetizer.payloadType,
+			SequenceNumber: packetizer.sequencer.NextSequenceNumber(),
+			Timestamp:      timestamp,
+			SSRC:           packetizer.ssrc,
+		}
+		packet.Payload = payload
+		packet.PaddingSize = 0
+
+		if err := packetizer.pushPacket(packet); err != nil {
+			packetizer.buffer.PutBack(packet)
+		}
+	}
+}
+
+// rtpTimestamp converts the packet PTS from the producer time base to the RTP clock. Packets without a PTS reuse the
+// last known timestamp.
+func (packetizer *RTPPacketizer) rtpTimestamp(packet *astiav.Packet) uint32 {
+	pts := packet.Pts()
+	if pts == astiav.NoPtsValue {
+		pts = packet.Dts()
+	}
+	if pts == astiav.NoPtsValue {
+		pts = packetizer.lastPts
+	}
+	packetizer.lastPts = pts
+
+	ticks := astiav.RescaleQ(pts, packetizer.timeBase, astiav.NewRational(1, int(packetizer.clockRate)))
+	return packetizer.timestampOffset + uint32(ticks)
+}
+
+func (packetizer *RTPPacketizer) getPacket() (*astiav.Packet, error) {
+	ctx, cancel := context.WithTimeout(packetizer.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return packetizer.producer.GetPacket(ctx)
+}
+
+func (packetizer *RTPPacketizer) pushPacket(packet *rtp.Packet) error {
+	ctx, cancel := context.WithTimeout(packetizer.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return packetizer.buffer.Push(ctx, packet)
+}
+
+func (packetizer *RTPPacketizer) GetRTPPacket(ctx context.Context) (*rtp.Packet, error) {
+	return packetizer.eos.pop(ctx, packetizer.buffer)
+}
+
+func (packetizer *RTPPacketizer) PutBack(packet *rtp.Packet) {
+	packetizer.buffer.PutBack(packet)
+}
+
+func (packetizer *RTPPacketizer) SSRC() uint32 {
+	return packetizer.ssrc
+}
+
+func (packetizer *RTPPacketizer) PayloadType() uint8 {
+	return packetizer.payloadType
+}
+
+func (packetizer *RTPPacketizer) ClockRate() uint32 {
+	return packetizer.clockRate
+}
+
+func (packetizer *RTPPacketizer) SetBuffer(buffer buffer.BufferWithGenerator[rtp.Packet]) {
+	packetizer.buffer = buffer
+}
@@ -0,0 +1,46 @@
+package transcode
+
+import (
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+type RTPPacketizerOption = func(*RTPPacketizer) error
+
+func WithRTPMTU(mtu uint16) RTPPacketizerOption {
+	return func(packetizer *RTPPacketizer) error {
+		packetizer.mtu = mtu
+		return nil
+	}
+}
+
+func WithRTPPayloadType(payloadType uint8) RTPPacketizerOption {
+	return func(packetizer *RTPPacketizer) error {
+		packetizer.payloadType = payloadType
+		return nil
+	}
+}
+
+func WithRTPSSRC(ssrc uint32) RTPPacketizerOption {
+	return func(packetizer *RTPPacketizer) error {
+		packetizer.ssrc = ssrc
+		return nil
+	}
+}
+
+// WithRTPClockRate overrides the clock rate picked from the codec, e.g. for audio codecs whose RTP clock follows the
+// sample rate.
+func WithRTPClockRate(clockRate uint32) RTPPacketizerOption {
+	return func(packetizer *RTPPacketizer) error {
+		packetizer.clockRate = clockRate
+		return nil
+	}
+}
+
+func WithRTPPacketizerBufferSize(size int) RTPPacketizerOption {
+	return func(packetizer *RTPPacketizer) error {
+		packetizer.SetBuffer(buffer.CreateChannelBuffer(packetizer.Ctx(), size, internal.CreateRTPPool()))
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+package transcode
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	defaultRTPReorderWindow  = 64
+	defaultRTPReorderMaxWait = 100 * time.Millisecond
+	rtpReorderMaxLate        = 8    // NOTE: LATE PACKETS IN A ROW BEFORE THE BUFFER RESYNCS ON THEM
+	rtpReorderMaxBehind      = 1024 // NOTE: FURTHER BEHIND THAN THIS IS A NEW SEQUENCE, NOT A LATE PACKET
+)
+
+type rtpReleasedPacket struct {
+	packet *rtp.Packet
+	lost   bool
+}
+
+// rtpReorderBuffer puts RTP packets back in sequence number order. Packets are held until the missing ones arrive,
+// until more than window packets are waiting or until the gap is older than maxWait; the gap is then given up on and
+// reported as lost. A jump of the sequence numbers that no reordering explains, like a restarted sender, resyncs the
+// buffer on the new sequence numbers.
+type rtpReorderBuffer struct {
+	window       int
+	maxWait      time.Duration // NOTE: 0 WAITS UNTIL THE WINDOW IS FULL
+	packets      map[uint16]*rtp.Packet
+	released     []rtpReleasedPacket
+	next         uint16
+	started      bool
+	late         int
+	waitingSince time.Time
+	lost         bool
+}
+
+func newRTPReorderBuffer(window int) *rtpReorderBuffer {
+	return &rtpReorderBuffer{
+		window:   window,
+		maxWait:  defaultRTPReorderMaxWait,
+		packets:  make(map[uint16]*rtp.Packet),
+		released: make([]rtpReleasedPacket, 0),
+	}
+}
+
+// push stores the packet. It returns false for duplicates and for packets older than the ones already released; the
+// caller still owns those.
+func (r *rtpReorderBuffer) push(packet *rtp.Packet) bool {
+	if !r.started {
+		r.next = packet.SequenceNumber
+		r.started = true
+	}
+
+	distance := int(int16(packet.SequenceNumber - r.next))
+	switch {
+	case distance < 0 && -distance <= rtpReorderMaxBehind && r.late < rtpReorderMaxLate:
+		r.late++
+		return false
+	case distance < 0 || distance > r.window:
+		// NOTE: FAR BEHIND, LATE FOR TOO LONG OR FAR AHEAD; THE SEQUENCE NUMBERS STARTED OVER
+		r.resync(packet.SequenceNumber)
+	}
+	r.late = 0
+
+	if _, ok := r.packets[packet.SequenceNumber]; ok {
+		return false
+	}
+
+	r.packets[packet.SequenceNumber] = packet
+	return true
+}
+
+// resync releases the held packets and continues from sequenceNumber.
+func (r *rtpReorderBuffer) resync(sequenceNumber uint16) {
+	r.release()
+	if sequenceNumber != r.next {
+		r.next = sequenceNumber
+		r.lost = true
+	}
+}
+
+// pop returns the next packet in order, or nil if it has not arrived yet and neither the window is full nor the gap
+// older than maxWait. lost is true if packets were skipped to return this one.
+func (r *rtpReorderBuffer) pop(now time.Time) (packet *rtp.Packet, lost bool) {
+	if len(r.released) > 0 {
+		released := r.released[0]
+		r.released[0] = rtpReleasedPacket{}
+		r.released = r.released[1:]
+		return released.packet, released.lost
+	}
+
+	if packet, ok := r.packets[r.next]; ok {
+		delete(r.packets, r.next)
+		r.next++
+		r.waitingSince = time.Time{}
+		lost, r.lost = r.lost, false
+		return packet, lost
+	}
+
+	if len(r.packets) == 0 {
+		return nil, false
+	}
+
+	if r.waitingSince.IsZero() {
+		r.waitingSince = now
+	}
+	if len(r.packets) <= r.window && (r.maxWait <= 0 || now.Sub(r.waitingSince) < r.maxWait) {
+		return nil, false
+	}
+
+	r.next = r.oldest()
+	r.lost = true
+	return r.pop(now)
+}
+
+func (r *rtpReorderBuffer) oldest() uint16 {
+	oldest, distance := r.next, -1
+	for sequenceNumber := range r.packets {
+		if d := int(sequenceNumber - r.next); distance < 0 || d < distance {
+			oldest, distance = sequenceNumber, d
+		}
+	}
+	return oldest
+}
+
+// release queues every held packet in order, skipping the gaps, to be returned by pop.
+func (r *rtpReorderBuffer) release() {
+	lost := r.lost
+	for len(r.packets) > 0 {
+		packet, ok := r.packets[r.next]
+		if !ok {
+			r.next = r.oldest()
+			lost = true
+			continue
+		}
+		delete(r.packets, r.next)
+		r.released = append(r.released, rtpReleasedPacket{packet: packet, lost: lost})
+		lost = false
+		r.next++
+	}
+	r.lost = lost
+	r.waitingSince = time.Time{}
+}
+
+// flush returns every held packet in order, skipping the gaps. It is called at the end of the stream, when nothing
+// more will arrive.
+func (r *rtpReorderBuffer) flush() []rtpReleasedPacket {
+	r.release()
+
+	released := r.released
+	r.released = make([]rtpReleasedPacket, 0)
+	return released
+}
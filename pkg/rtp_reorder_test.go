@@ -0,0 +1,159 @@
+package transcode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+type reorderPush struct {
+	sequenceNumber uint16
+	after          time.Duration // NOTE: TIME SINCE THE START WHEN THE PACKET ARRIVES
+}
+
+type reorderPop struct {
+	sequenceNumber uint16
+	lost           bool
+}
+
+func TestRTPReorderBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		pushes []reorderPush
+		want   []reorderPop
+	}{
+		{
+			name:   "in order",
+			window: 4,
+			pushes: []reorderPush{{sequenceNumber: 10}, {sequenceNumber: 11}, {sequenceNumber: 12}},
+			want:   []reorderPop{{sequenceNumber: 10}, {sequenceNumber: 11}, {sequenceNumber: 12}},
+		},
+		{
+			name:   "reorder",
+			window: 4,
+			pushes: []reorderPush{{sequenceNumber: 10}, {sequenceNumber: 12}, {sequenceNumber: 13}, {sequenceNumber: 11}},
+			want:   []reorderPop{{sequenceNumber: 10}, {sequenceNumber: 11}, {sequenceNumber: 12}, {sequenceNumber: 13}},
+		},
+		{
+			name:   "duplicate and late",
+			window: 4,
+			pushes: []reorderPush{{sequenceNumber: 10}, {sequenceNumber: 11}, {sequenceNumber: 11}, {sequenceNumber: 10}, {sequenceNumber: 12}},
+			want:   []reorderPop{{sequenceNumber: 10}, {sequenceNumber: 11}, {sequenceNumber: 12}},
+		},
+		{
+			name:   "loss fills the window",
+			window: 2,
+			pushes: []reorderPush{{sequenceNumber: 10}, {sequenceNumber: 12}, {sequenceNumber: 13}, {sequenceNumber: 14}},
+			want:   []reorderPop{{sequenceNumber: 10}, {sequenceNumber: 12, lost: true}, {sequenceNumber: 13}, {sequenceNumber: 14}},
+		},
+		{
+			name:   "loss outlives the maximum wait",
+			window: 64,
+			pushes: []reorderPush{{sequenceNumber: 10}, {sequenceNumber: 12, after: time.Millisecond}, {sequenceNumber: 13, after: 200 * time.Millisecond}},
+			want:   []reorderPop{{sequenceNumber: 10}, {sequenceNumber: 12, lost: true}, {sequenceNumber: 13}},
+		},
+		{
+			name:   "wrap at 65535",
+			window: 4,
+			pushes: []reorderPush{{sequenceNumber: 65534}, {sequenceNumber: 0}, {sequenceNumber: 65535}, {sequenceNumber: 1}},
+			want:   []reorderPop{{sequenceNumber: 65534}, {sequenceNumber: 65535}, {sequenceNumber: 0}, {sequenceNumber: 1}},
+		},
+		{
+			name:   "sender restart far behind",
+			window: 4,
+			pushes: []reorderPush{{sequenceNumber: 30000}, {sequenceNumber: 30001}, {sequenceNumber: 5}, {sequenceNumber: 6}},
+			want:   []reorderPop{{sequenceNumber: 30000}, {sequenceNumber: 30001}, {sequenceNumber: 5, lost: true}, {sequenceNumber: 6}},
+		},
+		{
+			name:   "sender restart far ahead",
+			window: 4,
+			pushes: []reorderPush{{sequenceNumber: 100}, {sequenceNumber: 101}, {sequenceNumber: 9000}, {sequenceNumber: 9001}},
+			want:   []reorderPop{{sequenceNumber: 100}, {sequenceNumber: 101}, {sequenceNumber: 9000, lost: true}, {sequenceNumber: 9001}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRTPReorderBuffer(test.window)
+			start := time.Now()
+
+			got := make([]reorderPop, 0)
+			for _, push := range test.pushes {
+				r.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: push.sequenceNumber}})
+				for {
+					packet, lost := r.pop(start.Add(push.after))
+					if packet == nil {
+						break
+					}
+					got = append(got, reorderPop{sequenceNumber: packet.SequenceNumber, lost: lost})
+				}
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRTPReorderBufferResyncOnLatePackets(t *testing.T) {
+	r := newRTPReorderBuffer(64)
+	now := time.Now()
+
+	for sequenceNumber := uint16(500); sequenceNumber < 510; sequenceNumber++ {
+		r.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: sequenceNumber}})
+		if packet, _ := r.pop(now); packet == nil {
+			t.Fatalf("packet %d was held", sequenceNumber)
+		}
+	}
+
+	// NOTE: THE SENDER RESTARTED A LITTLE BEHIND; THE FIRST PACKETS LOOK LATE
+	accepted := 0
+	for sequenceNumber := uint16(400); sequenceNumber < 400+rtpReorderMaxLate+2; sequenceNumber++ {
+		if r.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: sequenceNumber}}) {
+			accepted++
+		}
+	}
+	if accepted != 2 {
+		t.Fatalf("accepted %d packets after the restart, want 2", accepted)
+	}
+
+	packet, lost := r.pop(now)
+	if packet == nil || packet.SequenceNumber != 400+rtpReorderMaxLate || !lost {
+		t.Fatalf("got %v lost %v, want %d lost", packet, lost, 400+rtpReorderMaxLate)
+	}
+}
+
+func TestRTPReorderBufferFlush(t *testing.T) {
+	r := newRTPReorderBuffer(64)
+	r.maxWait = 0
+	now := time.Now()
+
+	for _, sequenceNumber := range []uint16{1, 3, 4, 7} {
+		r.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: sequenceNumber}})
+	}
+	if packet, _ := r.pop(now); packet == nil || packet.SequenceNumber != 1 {
+		t.Fatalf("got %v, want 1", packet)
+	}
+	if packet, _ := r.pop(now); packet != nil {
+		t.Fatalf("got %d, want the gap to be held", packet.SequenceNumber)
+	}
+
+	want := []reorderPop{{sequenceNumber: 3, lost: true}, {sequenceNumber: 4}, {sequenceNumber: 7, lost: true}}
+	released := r.flush()
+	if len(released) != len(want) {
+		t.Fatalf("flushed %d packets, want %d", len(released), len(want))
+	}
+	for i, packet := range released {
+		if got := (reorderPop{sequenceNumber: packet.packet.SequenceNumber, lost: packet.lost}); got != want[i] {
+			t.Fatalf("flushed %v, want %v", got, want[i])
+		}
+	}
+}
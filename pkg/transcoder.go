@@ -2,6 +2,7 @@ package transcode
 
 import (
 	"context"
+	"time"
 
 	"github.com/asticode/go-astiav"
 )
@@ -11,6 +12,10 @@ type Transcoder struct {
 	decoder Decoder
 	filter  Filter
 	encoder Encoder
+	muxer   Muxer
+	copier  *streamCopy // NOTE: NIL UNLESS WithPassthrough IS USED
+
+	createMuxer func() (Muxer, error) // NOTE: SET BY WithGeneralMuxer; RUN AFTER ALL OPTIONS
 }
 
 func CreateTranscoder(options ...TranscoderOption) (*Transcoder, error) {
@@ -21,6 +26,14 @@ func CreateTranscoder(options ...TranscoderOption) (*Transcoder, error) {
 		}
 	}
 
+	if t.createMuxer != nil {
+		muxer, err := t.createMuxer()
+		if err != nil {
+			return nil, err
+		}
+		t.muxer = muxer
+	}
+
 	return t, nil
 }
 
@@ -33,26 +46,96 @@ func NewTranscoder(demuxer Demuxer, decoder Decoder, filter Filter, encoder Enco
 	}
 }
 
+// Start starts the stages; without a decoder, filter and encoder the Transcoder only copies.
 func (t *Transcoder) Start() {
 	t.demuxer.Start()
-	t.decoder.Start()
-	t.filter.Start()
-	t.encoder.Start()
+	if t.copier != nil {
+		t.copier.Start()
+	}
+	if t.decoder != nil {
+		t.decoder.Start()
+		t.filter.Start()
+		t.encoder.Start()
+	}
+	if t.muxer != nil {
+		t.muxer.Start()
+	}
 }
 
 func (t *Transcoder) Stop() {
-	t.encoder.Stop()
-	t.filter.Stop()
-	t.decoder.Stop()
+	if t.muxer != nil {
+		t.muxer.Stop()
+	}
+	if t.decoder != nil {
+		t.encoder.Stop()
+		t.filter.Stop()
+		t.decoder.Stop()
+	}
+	if t.copier != nil {
+		t.copier.Stop()
+	}
 	t.demuxer.Stop()
 }
 
+// packetSource is what the decoder reads: the demuxer, or the stream copy which feeds it while re-encoding.
+func (t *Transcoder) packetSource() CanProduceMediaPacket {
+	if t.copier != nil {
+		return t.copier.decoderFeed()
+	}
+	return t.demuxer
+}
+
+// output is what the consumer reads: the encoder, or the stream copy which switches between the demuxer and the
+// encoder.
+func (t *Transcoder) output() CanProduceMediaPacket {
+	if t.copier != nil {
+		return t.copier
+	}
+	return t.encoder
+}
+
+func (t *Transcoder) setEncoder(encoder Encoder) error {
+	if t.copier != nil {
+		if err := t.copier.setEncoder(encoder); err != nil {
+			return err
+		}
+	}
+
+	t.encoder = encoder
+	return nil
+}
+
+// GetPacket returns the next output packet. With a muxer the muxer is the only consumer, so it fails with
+// ErrorOutputConsumedByMuxer.
 func (t *Transcoder) GetPacket(ctx context.Context) (*astiav.Packet, error) {
-	return t.encoder.GetPacket(ctx)
+	if t.muxer != nil {
+		return nil, ErrorOutputConsumedByMuxer
+	}
+	return t.output().GetPacket(ctx)
 }
 
 func (t *Transcoder) PutBack(packet *astiav.Packet) {
-	t.encoder.PutBack(packet)
+	t.output().PutBack(packet)
+}
+
+// SetPassthrough switches between copying and re-encoding the demuxer packets, at the next keyframe. Bitrate updates
+// switch as well when the source bitrate is known.
+func (t *Transcoder) SetPassthrough(passthrough bool) error {
+	if t.copier == nil {
+		return ErrorInterfaceMismatch
+	}
+
+	return t.copier.setPassthrough(passthrough)
+}
+
+// ParameterSetsChanged is signalled when a switch between copying and re-encoding changes the parameter sets; nil
+// without passthrough.
+func (t *Transcoder) ParameterSetsChanged() <-chan struct{} {
+	if t.copier == nil {
+		return nil
+	}
+
+	return t.copier.ParameterSetsChanged()
 }
 
 func (t *Transcoder) PauseEncoding() error {
@@ -74,6 +157,14 @@ func (t *Transcoder) UnPauseEncoding() error {
 }
 
 func (t *Transcoder) GetParameterSets() (sps, pps []byte, err error) {
+	if t.copier != nil {
+		sets, err := t.copier.GetCodecParameterSets()
+		if err != nil {
+			return nil, nil, err
+		}
+		return annexBFirst(sets.SPS), annexBFirst(sets.PPS), nil
+	}
+
 	p, ok := t.encoder.(CanGetParameterSets)
 	if !ok {
 		return nil, nil, ErrorInterfaceMismatch
@@ -82,7 +173,61 @@ func (t *Transcoder) GetParameterSets() (sps, pps []byte, err error) {
 	return p.GetParameterSets()
 }
 
+func (t *Transcoder) GetCodecParameterSets() (ParameterSets, error) {
+	if t.copier != nil {
+		return t.copier.GetCodecParameterSets()
+	}
+
+	p, ok := t.encoder.(CanGetCodecParameterSets)
+	if !ok {
+		return ParameterSets{}, ErrorInterfaceMismatch
+	}
+
+	return p.GetCodecParameterSets()
+}
+
+func (t *Transcoder) SendCommand(target, cmd, arg string) (string, error) {
+	s, ok := t.filter.(CanSendFilterCommand)
+	if !ok {
+		return "", ErrorInterfaceMismatch
+	}
+
+	return s.SendCommand(target, cmd, arg)
+}
+
+func (t *Transcoder) QueueCommand(at time.Duration, target, cmd, arg string) error {
+	s, ok := t.filter.(CanSendFilterCommand)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+
+	return s.QueueCommand(at, target, cmd, arg)
+}
+
+// ForceKeyFrame fails while copying; the keyframes are those of the source then.
+func (t *Transcoder) ForceKeyFrame() error {
+	if t.copier != nil && t.copier.isCopying() {
+		return ErrorInterfaceMismatch
+	}
+
+	f, ok := t.encoder.(CanForceKeyFrame)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+
+	return f.ForceKeyFrame()
+}
+
+// UpdateBitrate also switches between copying and re-encoding, when passthrough is set up and the source bitrate is
+// known; the encoder only gets the bitrates it is used for.
 func (t *Transcoder) UpdateBitrate(bps int64) error {
+	if t.copier != nil {
+		passthrough, err := t.copier.switchFor(bps)
+		if err != nil || passthrough {
+			return err
+		}
+	}
+
 	u, ok := t.encoder.(CanUpdateBitrate)
 	if !ok {
 		return ErrorInterfaceMismatch
@@ -94,3 +239,48 @@ func (t *Transcoder) UpdateBitrate(bps int64) error {
 func (t *Transcoder) OnUpdateBitrate() UpdateBitrateCallBack {
 	return t.UpdateBitrate
 }
+
+// ## CanDescribeMediaPacket; FORWARDED FROM THE ENCODER, OR THE STREAM COPY WITH PASSTHROUGH
+
+func (t *Transcoder) describer() CanDescribeMediaPacket {
+	d, ok := t.output().(CanDescribeMediaPacket)
+	if !ok {
+		return nil
+	}
+	return d
+}
+
+func (t *Transcoder) MediaType() astiav.MediaType {
+	if d := t.describer(); d != nil {
+		return d.MediaType()
+	}
+	return astiav.MediaTypeUnknown
+}
+
+func (t *Transcoder) CodecID() astiav.CodecID {
+	if d := t.describer(); d != nil {
+		return d.CodecID()
+	}
+	return astiav.CodecIDNone
+}
+
+func (t *Transcoder) GetCodecParameters() *astiav.CodecParameters {
+	if d := t.describer(); d != nil {
+		return d.GetCodecParameters()
+	}
+	return nil
+}
+
+func (t *Transcoder) FrameRate() astiav.Rational {
+	if d := t.describer(); d != nil {
+		return d.FrameRate()
+	}
+	return astiav.Rational{}
+}
+
+func (t *Transcoder) TimeBase() astiav.Rational {
+	if d := t.describer(); d != nil {
+		return d.TimeBase()
+	}
+	return astiav.Rational{}
+}
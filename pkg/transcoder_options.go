@@ -20,9 +20,23 @@ func WithGeneralDemuxer(ctx context.Context, containerAddress string, options ..
 	}
 }
 
+// WithPassthrough passes the packets of the demuxer on without decoding them. It goes right after the demuxer; with a
+// decoder, filter and encoder after it, the Transcoder switches between copying and re-encoding.
+func WithPassthrough(ctx context.Context, config PassthroughConfig) TranscoderOption {
+	return func(transcoder *Transcoder) error {
+		copier, err := newStreamCopy(ctx, transcoder.demuxer, config)
+		if err != nil {
+			return err
+		}
+
+		transcoder.copier = copier
+		return nil
+	}
+}
+
 func WithGeneralDecoder(ctx context.Context, options ...DecoderOption) TranscoderOption {
 	return func(transcoder *Transcoder) error {
-		decoder, err := CreateGeneralDecoder(ctx, transcoder.demuxer, options...)
+		decoder, err := CreateGeneralDecoder(ctx, transcoder.packetSource(), options...)
 		if err != nil {
 			return err
 		}
@@ -51,33 +65,42 @@ func WithGeneralEncoder(ctx context.Context, codecID astiav.CodecID, options ...
 			return err
 		}
 
-		transcoder.encoder = encoder
-		return nil
+		return transcoder.setEncoder(encoder)
 	}
 }
 
-func WithBitrateControlEncoder(ctx context.Context, codecID astiav.CodecID, bitrateControlConfig UpdateConfig, settings codecSettings, bufferSize int) TranscoderOption {
+func WithBitrateControlEncoder(ctx context.Context, codecID astiav.CodecID, bitrateControlConfig UpdateConfig, settings codecSettings, bufferSize int, options ...EncoderOption) TranscoderOption {
 	return func(transcoder *Transcoder) error {
-		builder := NewEncoderBuilder(codecID, settings, bufferSize, transcoder.filter)
+		builder := NewEncoderBuilder(codecID, settings, bufferSize, transcoder.filter, options...)
 		updateEncoder, err := NewUpdateEncoder(ctx, bitrateControlConfig, builder)
 		if err != nil {
 			return err
 		}
 
-		transcoder.encoder = updateEncoder
-		return nil
+		return transcoder.setEncoder(updateEncoder)
 	}
 }
 
-func WithMultiEncoderBitrateControl(ctx context.Context, codecID astiav.CodecID, config MultiConfig, settings codecSettings, bufferSize int) TranscoderOption {
+func WithMultiEncoderBitrateControl(ctx context.Context, codecID astiav.CodecID, config MultiConfig, settings codecSettings, bufferSize int, options ...EncoderOption) TranscoderOption {
 	return func(transcoder *Transcoder) error {
-		builder := NewEncoderBuilder(codecID, settings, bufferSize, transcoder.filter)
+		builder := NewEncoderBuilder(codecID, settings, bufferSize, transcoder.filter, options...)
 		multiEncoder, err := NewMultiUpdateEncoder(ctx, config, builder)
 		if err != nil {
 			return err
 		}
 
-		transcoder.encoder = multiEncoder
+		return transcoder.setEncoder(multiEncoder)
+	}
+}
+
+// WithGeneralMuxer writes the output of the Transcoder to a container. The muxer is created by CreateTranscoder once
+// every other option is applied, so it can be given in any order. It becomes the only consumer of the output;
+// GetPacket then fails with ErrorOutputConsumedByMuxer.
+func WithGeneralMuxer(ctx context.Context, containerAddress string, options ...MuxerOption) TranscoderOption {
+	return func(transcoder *Transcoder) error {
+		transcoder.createMuxer = func() (Muxer, error) {
+			return CreateGeneralMuxer(ctx, transcoder.output(), containerAddress, options...)
+		}
 		return nil
 	}
 }
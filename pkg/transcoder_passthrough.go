@@ -0,0 +1,627 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+
+	"github.com/harshabose/tools/buffer/pkg"
+
+	"github.com/harshabose/simple_webrtc_comm/transcode/internal"
+)
+
+// PassthroughConfig configures the stream copy mode of a Transcoder, in which the packets of the demuxer are passed on
+// without decoding them. With a decoder, filter and encoder also set up, the Transcoder switches between copying and
+// re-encoding; the encoder then has to put out the codec and bitstream format of the source.
+type PassthroughConfig struct {
+	// SourceBitrate is the bitrate of the source. Bitrate updates of at least this much copy the source and lower ones
+	// re-encode it. Zero takes the bitrate from the codec parameters of the demuxer; if those have none either, the
+	// mode only changes through SetPassthrough.
+	SourceBitrate int64
+}
+
+// streamCopy passes the packets of the source on, or, while re-encoding, feeds them to the decoder and passes on the
+// packets of the encoder instead. Like the split encoders of MultiUpdateEncoder, a switch waits for a keyframe: the
+// output moves over at the first keyframe of the side taking over, the other side is forwarded up to its PTS.
+// Routing decisions are made under mux and only queue the output; it is pushed downstream after mux is released, so
+// a slow consumer or decoder does not block switching or the other loop.
+type streamCopy struct {
+	source        CanProduceMediaPacket
+	describer     CanDescribeMediaPacket
+	encoder       Encoder // NOTE: NIL WHEN THE TRANSCODER ONLY COPIES
+	sourceBitrate int64
+	sourceSets    ParameterSets
+	encoderSets   ParameterSets
+
+	feed    buffer.BufferWithGenerator[astiav.Packet] // NOTE: SOURCE PACKETS FOR THE DECODER WHILE RE-ENCODING
+	feedEOS *endOfStream[astiav.Packet]
+	feedGap bool // NOTE: A FEED PACKET WAS DROPPED; ONLY USED BY THE SOURCE LOOP
+	buffer  buffer.BufferWithGenerator[astiav.Packet]
+	eos     *endOfStream[astiav.Packet]
+
+	copying       bool // NOTE: THE OUTPUT IS FROM THE SOURCE
+	passthrough   bool // NOTE: THE MODE ASKED FOR
+	feeding       bool
+	switching     passthroughSwitch
+	sent          ParameterSets
+	parameterSets chan struct{}
+	output        []*astiav.Packet // NOTE: QUEUED UNDER mux, PUSHED IN ORDER BY flush
+	mux           sync.Mutex
+	outputMux     sync.Mutex // NOTE: ONE flush AT A TIME, SO THE OUTPUT STAYS IN ORDER
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// passthroughSwitch is a pending switch: the keyframe of the side taking over was found at pts, and its packets are
+// held until the other side gets there.
+type passthroughSwitch struct {
+	found bool
+	pts   int64
+	held  []*astiav.Packet
+}
+
+func newStreamCopy(ctx context.Context, source CanProduceMediaPacket, config PassthroughConfig) (*streamCopy, error) {
+	describer, ok := source.(CanDescribeMediaPacket)
+	if !ok {
+		return nil, ErrorInterfaceMismatch
+	}
+
+	sets, err := parseParameterSets(describer.CodecID(), describer.GetCodecParameters().ExtraData())
+	if err != nil {
+		return nil, err
+	}
+
+	sourceBitrate := config.SourceBitrate
+	if sourceBitrate == 0 {
+		sourceBitrate = describer.GetCodecParameters().BitRate()
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	return &streamCopy{
+		source:        source,
+		describer:     describer,
+		sourceBitrate: sourceBitrate,
+		sourceSets:    sets,
+		feed:          buffer.CreateChannelBuffer(ctx2, 90, internal.CreatePacketPool()),
+		feedEOS:       newEndOfStream[astiav.Packet](),
+		buffer:        buffer.CreateChannelBuffer(ctx2, 90, internal.CreatePacketPool()),
+		eos:           newEndOfStream[astiav.Packet](),
+		copying:       true,
+		passthrough:   true,
+		sent:          sets,
+		parameterSets: make(chan struct{}, 1),
+		ctx:           ctx2,
+		cancel:        cancel,
+	}, nil
+}
+
+// setEncoder makes the stream copy switchable. The encoder has to be set up before anything reads the output, as it
+// decides the time base of the output.
+func (c *streamCopy) setEncoder(encoder Encoder) error {
+	d, ok := encoder.(CanDescribeMediaPacket)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+	if d.CodecID() != c.describer.CodecID() {
+		return ErrorPassthroughCodecMismatch
+	}
+
+	c.encoder = encoder
+	return nil
+}
+
+func (c *streamCopy) Ctx() context.Context {
+	return c.ctx
+}
+
+func (c *streamCopy) Start() {
+	go c.sourceLoop()
+	if c.encoder != nil {
+		go c.encoderLoop()
+	}
+}
+
+func (c *streamCopy) Stop() {
+	c.cancel()
+}
+
+// setPassthrough asks for copying or re-encoding; the switch completes at the next keyframe. When re-encoding, the
+// encoder is asked for a keyframe, which it puts out for the first frame decoded from the next source keyframe.
+func (c *streamCopy) setPassthrough(passthrough bool) error {
+	if c.encoder == nil {
+		if passthrough {
+			return nil
+		}
+		return ErrorInterfaceMismatch
+	}
+
+	c.mux.Lock()
+	if passthrough == c.passthrough {
+		c.mux.Unlock()
+		return nil
+	}
+
+	c.cancelSwitch()
+	c.passthrough = passthrough
+	c.mux.Unlock()
+
+	c.flush()
+
+	if !passthrough {
+		if f, ok := c.encoder.(CanForceKeyFrame); ok {
+			_ = f.ForceKeyFrame()
+		}
+	}
+
+	return nil
+}
+
+// switchFor asks for the mode the bitrate calls for, and reports whether that is copying. Without a known source
+// bitrate the mode is left alone and the bitrate is for the encoder.
+func (c *streamCopy) switchFor(bps int64) (bool, error) {
+	if c.encoder == nil {
+		return true, ErrorInterfaceMismatch
+	}
+	if c.sourceBitrate <= 0 {
+		return false, nil
+	}
+
+	passthrough := bps >= c.sourceBitrate
+	return passthrough, c.setPassthrough(passthrough)
+}
+
+func (c *streamCopy) isCopying() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.copying
+}
+
+func (c *streamCopy) ParameterSetsChanged() <-chan struct{} {
+	return c.parameterSets
+}
+
+func (c *streamCopy) sourceLoop() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		default:
+			packet, err := c.getPacket(c.source)
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					c.sourceEnded()
+					return
+				}
+				continue
+			}
+
+			c.routeSource(packet)
+			c.source.PutBack(packet)
+		}
+	}
+}
+
+func (c *streamCopy) encoderLoop() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		default:
+			packet, err := c.getPacket(c.encoder)
+			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					c.encoderEnded()
+					return
+				}
+				continue
+			}
+
+			c.routeEncoder(packet)
+		}
+	}
+}
+
+func (c *streamCopy) getPacket(producer CanProduceMediaPacket) (*astiav.Packet, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return producer.GetPacket(ctx)
+}
+
+// routeSource forwards, holds and/or feeds a source packet to the decoder. Only the decision is made under mux; the
+// pushes happen after it is released. The caller puts the packet back.
+func (c *streamCopy) routeSource(packet *astiav.Packet) {
+	if feed := c.decideSource(packet); feed != nil {
+		c.feedDecoder(feed)
+	}
+	c.flush()
+}
+
+// decideSource decides what happens to a source packet: it is forwarded, held until a pending switch completes, and/or
+// fed to the decoder, in which case the copy for the decoder is returned.
+func (c *streamCopy) decideSource(packet *astiav.Packet) (feed *astiav.Packet) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	key := packet.Flags().Has(astiav.PacketFlagKey)
+
+	switch {
+	case c.copying && c.passthrough:
+		c.forwardSource(packet)
+	case c.copying:
+		// NOTE: SWITCHING TO RE-ENCODING; THE DECODER STARTS AT A KEYFRAME AND THE SOURCE IS HELD FROM THERE
+		if !c.switching.found {
+			if !key {
+				c.forwardSource(packet)
+				return nil
+			}
+			c.switching.found = true
+			c.switching.pts = c.outputPts(packet)
+			c.feeding = true
+		}
+
+		feed = c.feedCopy(packet)
+		c.hold(packet)
+		if len(c.switching.held) > maxHeldSwitchPackets {
+			// NOTE: THE ENCODER DID NOT COME UP; KEEP COPYING AND TRY AGAIN AT THE NEXT KEYFRAME
+			c.cancelSwitch()
+		}
+	case c.passthrough:
+		// NOTE: SWITCHING TO COPYING; THE ENCODER IS BEHIND, SO THE SOURCE IS HELD FROM ITS NEXT KEYFRAME
+		feed = c.feedCopy(packet)
+		if !c.switching.found {
+			if !key {
+				return feed
+			}
+			c.switching.found = true
+			c.switching.pts = c.outputPts(packet)
+		}
+
+		c.hold(packet)
+		if len(c.switching.held) > maxHeldSwitchPackets {
+			c.completeCopying()
+		}
+	default:
+		feed = c.feedCopy(packet)
+	}
+
+	return feed
+}
+
+// routeEncoder forwards an encoder packet, lets it complete a pending switch, or drops it. Only the decision is made
+// under mux; the pushes happen after it is released.
+func (c *streamCopy) routeEncoder(packet *astiav.Packet) {
+	c.decideEncoder(packet)
+	c.encoder.PutBack(packet)
+	c.flush()
+}
+
+// decideEncoder decides what happens to an encoder packet: it is forwarded, completes a pending switch, or is dropped.
+func (c *streamCopy) decideEncoder(packet *astiav.Packet) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	switch {
+	case c.copying && !c.passthrough:
+		if c.switching.found && packet.Flags().Has(astiav.PacketFlagKey) && packet.Pts() >= c.switching.pts {
+			c.completeEncoding()
+			c.forwardEncoder(packet)
+		}
+	case c.copying:
+		// NOTE: LEFTOVERS OF THE ENCODER FROM BEFORE COPYING
+	case c.passthrough && c.switching.found && packet.Pts() >= c.switching.pts:
+		c.completeCopying()
+	default:
+		c.forwardEncoder(packet)
+	}
+}
+
+// completeEncoding drops the held source packets; the encoder packets take over. Needs mux.
+func (c *streamCopy) completeEncoding() {
+	for _, packet := range c.switching.held {
+		c.buffer.PutBack(packet)
+	}
+
+	c.copying = false
+	c.switching = passthroughSwitch{}
+	if s, ok := c.encoder.(CanGetCodecParameterSets); ok {
+		if sets, err := s.GetCodecParameterSets(); err == nil {
+			c.encoderSets = sets
+		}
+	}
+}
+
+// completeCopying forwards the held source packets and stops feeding the decoder. Needs mux.
+func (c *streamCopy) completeCopying() {
+	held := c.switching.held
+
+	c.copying = true
+	c.feeding = false
+	c.switching = passthroughSwitch{}
+
+	for _, packet := range held {
+		c.queue(packet, c.sourceSets, c.describer.GetCodecParameters().ExtraData())
+	}
+}
+
+// cancelSwitch drops a pending switch. If the output is still on the source, the held source packets are forwarded and
+// the decoder is no longer fed. Needs mux.
+func (c *streamCopy) cancelSwitch() {
+	held := c.switching.held
+	c.switching = passthroughSwitch{}
+	if c.copying {
+		c.feeding = false
+	}
+
+	for _, packet := range held {
+		if c.copying {
+			c.queue(packet, c.sourceSets, c.describer.GetCodecParameters().ExtraData())
+			continue
+		}
+		c.buffer.PutBack(packet)
+	}
+}
+
+// hold keeps a copy of the source packet in the output time base. Needs mux.
+func (c *streamCopy) hold(packet *astiav.Packet) {
+	out, err := c.copySource(packet)
+	if err != nil {
+		return
+	}
+
+	c.switching.held = append(c.switching.held, out)
+}
+
+func (c *streamCopy) forwardSource(packet *astiav.Packet) {
+	out, err := c.copySource(packet)
+	if err != nil {
+		return
+	}
+
+	c.queue(out, c.sourceSets, c.describer.GetCodecParameters().ExtraData())
+}
+
+func (c *streamCopy) forwardEncoder(packet *astiav.Packet) {
+	out := c.buffer.Generate()
+	if err := out.Ref(packet); err != nil {
+		c.buffer.PutBack(out)
+		return
+	}
+
+	var extraData []byte
+	if d, ok := c.encoder.(CanDescribeMediaPacket); ok {
+		extraData = d.GetCodecParameters().ExtraData()
+	}
+
+	c.queue(out, c.encoderSets, extraData)
+}
+
+func (c *streamCopy) copySource(packet *astiav.Packet) (*astiav.Packet, error) {
+	out := c.buffer.Generate()
+	if err := out.Ref(packet); err != nil {
+		c.buffer.PutBack(out)
+		return nil, err
+	}
+
+	out.RescaleTs(c.describer.TimeBase(), c.TimeBase())
+	return out, nil
+}
+
+func (c *streamCopy) outputPts(packet *astiav.Packet) int64 {
+	return astiav.RescaleQ(packet.Pts(), c.describer.TimeBase(), c.TimeBase())
+}
+
+// queue queues a packet of the output buffer for flush. The first keyframe after a change of parameter sets carries
+// them in-band and as new extradata side data. Needs mux.
+func (c *streamCopy) queue(packet *astiav.Packet, sets ParameterSets, extraData []byte) {
+	if packet.Flags().Has(astiav.PacketFlagKey) && !sets.Equal(c.sent) {
+		if err := c.withParameterSets(packet, sets, extraData); err != nil {
+			c.buffer.PutBack(packet)
+			return
+		}
+	}
+
+	c.output = append(c.output, packet)
+}
+
+// flush pushes the queued output downstream in the order it was queued, without holding mux while pushing. Whichever
+// loop gets outputMux pushes what the other queued too.
+func (c *streamCopy) flush() {
+	c.outputMux.Lock()
+	defer c.outputMux.Unlock()
+
+	for {
+		c.mux.Lock()
+		if len(c.output) == 0 {
+			c.mux.Unlock()
+			return
+		}
+		packet := c.output[0]
+		c.output[0] = nil
+		c.output = c.output[1:]
+		c.mux.Unlock()
+
+		if err := c.pushPacket(packet); err != nil {
+			c.buffer.PutBack(packet)
+		}
+	}
+}
+
+func (c *streamCopy) withParameterSets(packet *astiav.Packet, sets ParameterSets, extraData []byte) error {
+	data := sets.inBand(packet.Data())
+	if len(extraData) > 0 && !isAnnexB(extraData) && !isAV1(sets.CodecID) {
+		data = sets.lengthPrefixedInBand(packet.Data())
+	}
+
+	if err := setPacketData(packet, data); err != nil {
+		return err
+	}
+	if err := packet.SideData().Add(astiav.PacketSideDataTypeNewExtradata, extraData); err != nil {
+		return err
+	}
+
+	c.sent = sets
+
+	select {
+	case c.parameterSets <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// feedCopy returns a copy of the source packet for the decoder, or nil if the decoder is not fed. Needs mux.
+func (c *streamCopy) feedCopy(packet *astiav.Packet) *astiav.Packet {
+	if !c.feeding {
+		return nil
+	}
+
+	in := c.feed.Generate()
+	if err := in.Ref(packet); err != nil {
+		c.feed.PutBack(in)
+		return nil
+	}
+
+	return in
+}
+
+// feedDecoder pushes a copy made by feedCopy to the decoder. Only the source loop feeds, so the feed stays in order.
+// Once a packet could not be pushed, the feed skips to the next keyframe, so the decoder never gets packets that
+// refer to the dropped one.
+func (c *streamCopy) feedDecoder(in *astiav.Packet) {
+	key := in.Flags().Has(astiav.PacketFlagKey)
+	if c.feedGap && !key {
+		c.feed.PutBack(in)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.feed.Push(ctx, in); err != nil {
+		c.feed.PutBack(in)
+		c.feedGap = true
+		return
+	}
+
+	c.feedGap = false
+}
+
+func (c *streamCopy) pushPacket(packet *astiav.Packet) error {
+	ctx, cancel := context.WithTimeout(c.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	return c.buffer.Push(ctx, packet)
+}
+
+// sourceEnded ends the decoder feed, and the output if it is on the source; otherwise the encoder ends it once it is
+// drained.
+func (c *streamCopy) sourceEnded() {
+	c.mux.Lock()
+	endsOutput := c.copying || (c.passthrough && c.switching.found)
+	if c.copying {
+		c.cancelSwitch()
+	} else if endsOutput {
+		c.completeCopying()
+	}
+	c.mux.Unlock()
+
+	c.flush()
+
+	if c.encoder != nil {
+		_ = c.feedEOS.push(c.ctx, c.feed)
+	}
+
+	if endsOutput {
+		_ = c.eos.push(c.ctx, c.buffer)
+	}
+}
+
+func (c *streamCopy) encoderEnded() {
+	c.mux.Lock()
+	copying := c.copying
+	c.mux.Unlock()
+
+	if copying {
+		return
+	}
+
+	_ = c.eos.push(c.ctx, c.buffer)
+}
+
+func (c *streamCopy) GetPacket(ctx context.Context) (*astiav.Packet, error) {
+	return c.eos.pop(ctx, c.buffer)
+}
+
+func (c *streamCopy) PutBack(packet *astiav.Packet) {
+	c.buffer.PutBack(packet)
+}
+
+// GetCodecParameterSets returns the parameter sets of the side the output is on.
+func (c *streamCopy) GetCodecParameterSets() (ParameterSets, error) {
+	if c.isCopying() {
+		return c.sourceSets, nil
+	}
+
+	s, ok := c.encoder.(CanGetCodecParameterSets)
+	if !ok {
+		return ParameterSets{}, ErrorInterfaceMismatch
+	}
+
+	return s.GetCodecParameterSets()
+}
+
+// ## CanDescribeMediaPacket; THE CODEC PARAMETERS ARE THOSE OF THE SIDE THE OUTPUT IS ON
+
+func (c *streamCopy) MediaType() astiav.MediaType {
+	return c.describer.MediaType()
+}
+
+func (c *streamCopy) CodecID() astiav.CodecID {
+	return c.describer.CodecID()
+}
+
+func (c *streamCopy) GetCodecParameters() *astiav.CodecParameters {
+	if d, ok := c.encoder.(CanDescribeMediaPacket); ok && !c.isCopying() {
+		return d.GetCodecParameters()
+	}
+
+	return c.describer.GetCodecParameters()
+}
+
+func (c *streamCopy) FrameRate() astiav.Rational {
+	return c.describer.FrameRate()
+}
+
+// TimeBase is the time base of the encoder if there is one; the source packets are rescaled to it.
+func (c *streamCopy) TimeBase() astiav.Rational {
+	if d, ok := c.encoder.(CanDescribeMediaPacket); ok {
+		return d.TimeBase()
+	}
+
+	return c.describer.TimeBase()
+}
+
+// decoderFeed is what the decoder of a switchable Transcoder reads instead of the demuxer.
+func (c *streamCopy) decoderFeed() *streamCopyFeed {
+	return &streamCopyFeed{CanDescribeMediaPacket: c.describer, copy: c}
+}
+
+// streamCopyFeed produces the source packets fed to the decoder while re-encoding, and describes the source.
+type streamCopyFeed struct {
+	CanDescribeMediaPacket
+	copy *streamCopy
+}
+
+func (f *streamCopyFeed) GetPacket(ctx context.Context) (*astiav.Packet, error) {
+	return f.copy.feedEOS.pop(ctx, f.copy.feed)
+}
+
+func (f *streamCopyFeed) PutBack(packet *astiav.Packet) {
+	f.copy.feed.PutBack(packet)
+}
@@ -17,6 +17,9 @@ import (
 type UpdateConfig struct {
 	MaxBitrate, MinBitrate  int64
 	CutVideoBelowMinBitrate bool
+
+	// Ladder, when set, also adapts the resolution and frame rate of the producing GeneralFilter to the bitrate.
+	Ladder *AdaptationLadder
 }
 
 func (c UpdateConfig) validate() error {
@@ -24,6 +27,10 @@ func (c UpdateConfig) validate() error {
 		return fmt.Errorf("minimum bitrate is higher than maximum bitrate in the update encoder config")
 	}
 
+	if c.Ladder != nil {
+		return c.Ladder.validate()
+	}
+
 	return nil
 }
 
@@ -32,6 +39,8 @@ type UpdateEncoder struct {
 	config  UpdateConfig
 	builder *GeneralEncoderBuilder
 	buffer  buffer.BufferWithGenerator[astiav.Packet]
+	eos     *endOfStream[astiav.Packet]
+	ladder  *ladderState
 	mux     sync.RWMutex
 	ctx     context.Context
 
@@ -46,6 +55,7 @@ func NewUpdateEncoder(ctx context.Context, config UpdateConfig, builder *General
 		builder: builder,
 		resume:  make(chan struct{}),
 		buffer:  buffer.CreateChannelBuffer(ctx, 30, internal.CreatePacketPool()),
+		eos:     newEndOfStream[astiav.Packet](),
 		ctx:     ctx,
 	}
 
@@ -53,6 +63,10 @@ func NewUpdateEncoder(ctx context.Context, config UpdateConfig, builder *General
 		return nil, err
 	}
 
+	if config.Ladder != nil {
+		updater.ladder = newLadderState(config.Ladder)
+	}
+
 	encoder, err := builder.Build(ctx)
 	if err != nil {
 		return nil, err
@@ -80,7 +94,7 @@ func (u *UpdateEncoder) Start() {
 }
 
 func (u *UpdateEncoder) GetPacket(ctx context.Context) (*astiav.Packet, error) {
-	return u.buffer.Pop(ctx)
+	return u.eos.pop(ctx, u.buffer)
 }
 
 func (u *UpdateEncoder) PutBack(packet *astiav.Packet) {
@@ -107,6 +121,12 @@ func (u *UpdateEncoder) UpdateBitrate(bps int64) error {
 
 	bps = u.cutoff(bps)
 
+	if u.ladder != nil {
+		if rung, changed := u.ladder.next(bps, time.Now()); changed {
+			return u.adapt(rung, bps)
+		}
+	}
+
 	g, ok := u.encoder.(CanGetCurrentBitrate)
 	if !ok {
 		return ErrorInterfaceMismatch
@@ -128,6 +148,66 @@ func (u *UpdateEncoder) UpdateBitrate(bps int64) error {
 		return err
 	}
 
+	if r, ok := u.encoder.(CanReconfigure); ok && r.CanReconfigure() {
+		if err := r.Reconfigure(u.builder.rateControl(bps)); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// NOTE: THE CODEC CANNOT CHANGE ITS RATE CONTROL WHILE OPEN; FALL BACK TO BUILDING A NEW ENCODER
+	if err := u.rebuild(); err != nil {
+		return err
+	}
+
+	// Print encoder update notification
+	fmt.Println()
+	fmt.Println("╔═══════════════════════════════════════╗")
+	fmt.Println("║        🎥 ENCODER UPDATED 🎥          ║")
+	fmt.Printf("║      New Bitrate: %6d kbps          ║\n", bps/1000)
+	fmt.Printf("║      Change: %6.2f                   ║\n", change)
+	fmt.Printf("║      Update time: %6d ms            ║\n", time.Since(start).Milliseconds())
+	fmt.Println("╚═══════════════════════════════════════╝")
+	fmt.Println()
+
+	return nil
+}
+
+// adapt moves the producing filter to the video output of the rung and rebuilds the encoder at the new size. The
+// encoder has to be rebuilt even when it could reconfigure its rate control, as the frame size is fixed once open.
+// If the encoder cannot be rebuilt, the filter is moved back to the previous rung.
+func (u *UpdateEncoder) adapt(rung AdaptationRung, bps int64) error {
+	if err := u.setVideoOutput(rung); err != nil {
+		u.ladder.revert()
+		return err
+	}
+
+	err := u.builder.UpdateBitrate(bps)
+	if err == nil {
+		err = u.rebuild()
+	}
+	if err != nil {
+		_ = u.setVideoOutput(u.ladder.revert())
+		return err
+	}
+
+	return nil
+}
+
+func (u *UpdateEncoder) setVideoOutput(rung AdaptationRung) error {
+	s, ok := u.builder.producer.(CanSetVideoOutput)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+
+	ctx, cancel := context.WithTimeout(u.ctx, ladderApplyTimeout)
+	defer cancel()
+
+	return s.SetVideoOutput(ctx, rung.output())
+}
+
+// rebuild builds a new encoder from the builder, starts it and stops the old one once it is swapped in.
+func (u *UpdateEncoder) rebuild() error {
 	newEncoder, err := u.builder.Build(u.ctx)
 	if err != nil {
 		return fmt.Errorf("build new encoder: %w", err)
@@ -144,16 +224,6 @@ func (u *UpdateEncoder) UpdateBitrate(bps int64) error {
 	u.encoder = newEncoder
 	u.mux.Unlock()
 
-	// Print encoder update notification
-	fmt.Println()
-	fmt.Println("╔═══════════════════════════════════════╗")
-	fmt.Println("║        🎥 ENCODER UPDATED 🎥          ║")
-	fmt.Printf("║      New Bitrate: %6d kbps          ║\n", bps/1000)
-	fmt.Printf("║      Change: %6.2f                   ║\n", change)
-	fmt.Printf("║      Update time: %6d ms            ║\n", time.Since(start).Milliseconds())
-	fmt.Println("╚═══════════════════════════════════════╝")
-	fmt.Println()
-
 	if oldEncoder != nil {
 		oldEncoder.Stop()
 	}
@@ -161,6 +231,11 @@ func (u *UpdateEncoder) UpdateBitrate(bps int64) error {
 	return nil
 }
 
+// BitrateBounds returns the bitrate bounds of the UpdateConfig, so a BandwidthEstimator gives it estimates within them.
+func (u *UpdateEncoder) BitrateBounds() (int64, int64) {
+	return u.config.MinBitrate, u.config.MaxBitrate
+}
+
 func (u *UpdateEncoder) cutoff(bps int64) int64 {
 	if bps > u.config.MaxBitrate {
 		bps = u.config.MaxBitrate
@@ -203,6 +278,20 @@ func (u *UpdateEncoder) UnPauseEncoding() error {
 	return nil
 }
 
+// ForceKeyFrame forwards the keyframe request to the current encoder. Encoders built by UpdateBitrate start with a
+// keyframe anyway.
+func (u *UpdateEncoder) ForceKeyFrame() error {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+
+	f, ok := u.encoder.(CanForceKeyFrame)
+	if !ok {
+		return ErrorInterfaceMismatch
+	}
+
+	return f.ForceKeyFrame()
+}
+
 func (u *UpdateEncoder) GetParameterSets() (sps []byte, pps []byte, err error) {
 	p, ok := u.encoder.(CanGetParameterSets)
 	if !ok {
@@ -212,6 +301,66 @@ func (u *UpdateEncoder) GetParameterSets() (sps []byte, pps []byte, err error) {
 	return p.GetParameterSets()
 }
 
+func (u *UpdateEncoder) GetCodecParameterSets() (ParameterSets, error) {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+
+	p, ok := u.encoder.(CanGetCodecParameterSets)
+	if !ok {
+		return ParameterSets{}, ErrorInterfaceMismatch
+	}
+
+	return p.GetCodecParameterSets()
+}
+
+// ## CanDescribeMediaPacket; FORWARDED FROM THE CURRENT ENCODER
+
+func (u *UpdateEncoder) describer() CanDescribeMediaPacket {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+
+	d, ok := u.encoder.(CanDescribeMediaPacket)
+	if !ok {
+		return nil
+	}
+	return d
+}
+
+func (u *UpdateEncoder) MediaType() astiav.MediaType {
+	if d := u.describer(); d != nil {
+		return d.MediaType()
+	}
+	return astiav.MediaTypeUnknown
+}
+
+func (u *UpdateEncoder) CodecID() astiav.CodecID {
+	if d := u.describer(); d != nil {
+		return d.CodecID()
+	}
+	return astiav.CodecIDNone
+}
+
+func (u *UpdateEncoder) GetCodecParameters() *astiav.CodecParameters {
+	if d := u.describer(); d != nil {
+		return d.GetCodecParameters()
+	}
+	return nil
+}
+
+func (u *UpdateEncoder) FrameRate() astiav.Rational {
+	if d := u.describer(); d != nil {
+		return d.FrameRate()
+	}
+	return astiav.Rational{}
+}
+
+func (u *UpdateEncoder) TimeBase() astiav.Rational {
+	if d := u.describer(); d != nil {
+		return d.TimeBase()
+	}
+	return astiav.Rational{}
+}
+
 func calculateBitrateChange(currentBps, newBps int64) (absoluteChange int64, percentageChange float64) {
 	absoluteChange = newBps - currentBps
 	if absoluteChange < 0 {
@@ -255,6 +404,12 @@ func (u *UpdateEncoder) loop() {
 		default:
 			p, err := u.getPacket()
 			if err != nil {
+				if errors.Is(err, ErrorEndOfStream) {
+					if err := u.eos.push(u.ctx, u.buffer); err == nil {
+						<-u.ctx.Done()
+					}
+					return
+				}
 				// fmt.Println("error getting packet from encoder; err:", err.Error())
 			}
 
@@ -0,0 +1,153 @@
+package transcode
+
+import (
+	"strconv"
+)
+
+// VPXRateControl is the rate control shared by VP8Settings and VP9Settings. Unlike the libvpx wrapper options, which
+// take bits per second, the rates are kept in kbps like the x264 settings.
+type VPXRateControl struct {
+	Bitrate       string `codec:"b,suffix=k,omitempty"`
+	MinRate       string `codec:"minrate,suffix=k,omitempty"`
+	MaxRate       string `codec:"maxrate,suffix=k,omitempty"`
+	BufSize       string `codec:"bufsize,suffix=k,omitempty"`
+	UndershootPct string `codec:"undershoot-pct,omitempty"`
+	OvershootPct  string `codec:"overshoot-pct,omitempty"`
+	MaxIntraRate  string `codec:"max-intra-rate,omitempty"`
+	DropThreshold string `codec:"drop-threshold,omitempty"`
+}
+
+func (o *VPXRateControl) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(o, f)
+}
+
+func (o *VPXRateControl) UpdateBitrate(bps int64) error {
+	kbps := bps / 1000
+
+	// NOTE: libvpx HAS NO VBV; CBR IS minrate == maxrate == b, WITH THE BUFFER DEFINING HOW FAR IT MAY DRIFT
+	o.Bitrate = strconv.FormatInt(kbps, 10)
+	o.MinRate = strconv.FormatInt(kbps, 10)
+	o.MaxRate = strconv.FormatInt(kbps, 10)
+	o.BufSize = strconv.FormatInt(max(kbps/2, 100), 10) // 0.5 seconds, at least 100 kb
+
+	return nil
+}
+
+func (o *VPXRateControl) GetCurrentBitrate() (int64, error) {
+	kbps, err := strconv.ParseInt(o.Bitrate, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return kbps * 1000, nil
+}
+
+func (o *VPXRateControl) rateControl() (RateControlConfig, error) {
+	if o == nil {
+		return RateControlConfig{}, ErrorCodecNoSetting
+	}
+	return rateControlFromKbps(o.Bitrate, o.MaxRate, o.BufSize)
+}
+
+type VP8Settings struct {
+	*VPXRateControl
+	Deadline         string `codec:"deadline,omitempty"`          // realtime, good or best
+	CPUUsed          string `codec:"cpu-used,omitempty"`          // -16 to 16; higher is faster
+	ErrorResilient   string `codec:"error-resilient,omitempty"`   // default or partitions
+	LagInFrames      string `codec:"lag-in-frames,omitempty"`     // 0 for no lookahead
+	AutoAltRef       string `codec:"auto-alt-ref,omitempty"`      // needs lag-in-frames
+	KeyIntMax        string `codec:"g,omitempty"`                 // keyframe interval
+	KeyIntMin        string `codec:"keyint_min,omitempty"`        // minimum keyframe interval
+	QMin             string `codec:"qmin,omitempty"`              // 0 to 63
+	QMax             string `codec:"qmax,omitempty"`              // 0 to 63
+	StaticThresh     string `codec:"static-thresh,omitempty"`     // skip static blocks
+	NoiseSensitivity string `codec:"noise-sensitivity,omitempty"` // temporal denoiser
+	Threads          string `codec:"threads,omitempty"`           // 0 for auto
+}
+
+func (s *VP8Settings) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(s, f)
+}
+
+func (s *VP8Settings) UpdateBitrate(bps int64) error {
+	return s.VPXRateControl.UpdateBitrate(bps)
+}
+
+type VP9Settings struct {
+	*VPXRateControl
+	Deadline       string `codec:"deadline,omitempty"`        // realtime, good or best
+	CPUUsed        string `codec:"cpu-used,omitempty"`        // -8 to 8; 5 and above are realtime speeds
+	ErrorResilient string `codec:"error-resilient,omitempty"` // default
+	LagInFrames    string `codec:"lag-in-frames,omitempty"`   // 0 for no lookahead
+	AutoAltRef     string `codec:"auto-alt-ref,omitempty"`    // needs lag-in-frames
+	KeyIntMax      string `codec:"g,omitempty"`               // keyframe interval
+	KeyIntMin      string `codec:"keyint_min,omitempty"`      // minimum keyframe interval
+	QMin           string `codec:"qmin,omitempty"`            // 0 to 63
+	QMax           string `codec:"qmax,omitempty"`            // 0 to 63
+	RowMT          string `codec:"row-mt,omitempty"`          // row based multithreading
+	TileColumns    string `codec:"tile-columns,omitempty"`    // log2 of the tile columns
+	FrameParallel  string `codec:"frame-parallel,omitempty"`  // frame parallel decodability
+	AQMode         string `codec:"aq-mode,omitempty"`         // 3 is cyclic refresh
+	TuneContent    string `codec:"tune-content,omitempty"`    // default, screen or film
+	Threads        string `codec:"threads,omitempty"`         // 0 for auto
+}
+
+func (s *VP9Settings) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(s, f)
+}
+
+func (s *VP9Settings) UpdateBitrate(bps int64) error {
+	return s.VPXRateControl.UpdateBitrate(bps)
+}
+
+var RealtimeVP8Settings = VP8Settings{
+	VPXRateControl: &VPXRateControl{
+		Bitrate:       "800",
+		MinRate:       "800",
+		MaxRate:       "800",
+		BufSize:       "400",
+		UndershootPct: "95",
+		OvershootPct:  "15",
+		MaxIntraRate:  "300",
+		DropThreshold: "0",
+	},
+	Deadline:         "realtime",
+	CPUUsed:          "8",
+	ErrorResilient:   "partitions",
+	LagInFrames:      "0",
+	AutoAltRef:       "0",
+	KeyIntMax:        "60",
+	KeyIntMin:        "30",
+	QMin:             "4",
+	QMax:             "56",
+	StaticThresh:     "0",
+	NoiseSensitivity: "0",
+	Threads:          "0",
+}
+
+var RealtimeVP9Settings = VP9Settings{
+	VPXRateControl: &VPXRateControl{
+		Bitrate:       "800",
+		MinRate:       "800",
+		MaxRate:       "800",
+		BufSize:       "400",
+		UndershootPct: "95",
+		OvershootPct:  "15",
+		MaxIntraRate:  "300",
+		DropThreshold: "0",
+	},
+	Deadline:       "realtime",
+	CPUUsed:        "8",
+	ErrorResilient: "default",
+	LagInFrames:    "0",
+	AutoAltRef:     "0",
+	KeyIntMax:      "60",
+	KeyIntMin:      "30",
+	QMin:           "4",
+	QMax:           "56",
+	RowMT:          "1",
+	TileColumns:    "2",
+	FrameParallel:  "0",
+	AQMode:         "3",
+	TuneContent:    "default",
+	Threads:        "0",
+}
@@ -1,59 +1,35 @@
 package transcode
 
 import (
-	"reflect"
 	"strconv"
-	"strings"
 )
 
 type X264AdvancedOptions struct {
 	// PRIMARY OPTIONS
-	Bitrate          string `x264-opts:"bitrate"`
-	VBVMaxBitrate    string `x264-opts:"vbv-maxrate"`
-	VBVBuffer        string `x264-opts:"vbv-bufsize"`
-	RateTolerance    string `x264-opts:"ratetol"`
-	MaxGOP           string `x264-opts:"keyint"`
-	MinGOP           string `x264-opts:"min-keyint"`
-	MaxQP            string `x264-opts:"qpmax"`
-	MinQP            string `x264-opts:"qpmin"`
-	MaxQPStep        string `x264-opts:"qpstep"`
-	IntraRefresh     string `x264-opts:"intra-refresh"`
-	ConstrainedIntra string `x264-opts:"constrained-intra"`
+	Bitrate          string `codec:"bitrate,opts=x264opts,omitempty"`
+	VBVMaxBitrate    string `codec:"vbv-maxrate,opts=x264opts,omitempty"`
+	VBVBuffer        string `codec:"vbv-bufsize,opts=x264opts,omitempty"`
+	RateTolerance    string `codec:"ratetol,opts=x264opts,omitempty"`
+	MaxGOP           string `codec:"keyint,opts=x264opts,omitempty"`
+	MinGOP           string `codec:"min-keyint,opts=x264opts,omitempty"`
+	MaxQP            string `codec:"qpmax,opts=x264opts,omitempty"`
+	MinQP            string `codec:"qpmin,opts=x264opts,omitempty"`
+	MaxQPStep        string `codec:"qpstep,opts=x264opts,omitempty"`
+	IntraRefresh     string `codec:"intra-refresh,opts=x264opts,omitempty"`
+	ConstrainedIntra string `codec:"constrained-intra,opts=x264opts,omitempty"`
 
 	// SECONDARY OPTIONS; SOME OF THEM ARE ALREADY SET BY PRESET, PROFILE AND TUNE
-	SceneCut    string `x264-opts:"scenecut"`
-	BFrames     string `x264-opts:"bframes"`
-	BAdapt      string `x264-opts:"b-adapt"`
-	Refs        string `x264-opts:"ref"`
-	RCLookAhead string `x264-opts:"rc-lookahead"`
-	AQMode      string `x264-opts:"aq-mode"`
-	NalHrd      string `x264-opts:"nal-hrd"`
+	SceneCut    string `codec:"scenecut,opts=x264opts,omitempty"`
+	BFrames     string `codec:"bframes,opts=x264opts,omitempty"`
+	BAdapt      string `codec:"b-adapt,opts=x264opts,omitempty"`
+	Refs        string `codec:"ref,opts=x264opts,omitempty"`
+	RCLookAhead string `codec:"rc-lookahead,opts=x264opts,omitempty"`
+	AQMode      string `codec:"aq-mode,opts=x264opts,omitempty"`
+	NalHrd      string `codec:"nal-hrd,opts=x264opts,omitempty"`
 }
 
 func (o *X264AdvancedOptions) ForEach(f func(key, value string) error) error {
-	t := reflect.TypeOf(*o)
-	v := reflect.ValueOf(*o)
-
-	// Build a single x264opts string
-	var optParts []string
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("x264-opts")
-		if tag != "" && v.Field(i).String() != "" {
-			optParts = append(optParts, tag+"="+v.Field(i).String())
-		}
-	}
-
-	// Join all options with colons
-	if len(optParts) > 0 {
-		x264optsValue := strings.Join(optParts, ":")
-		if err := f("x264opts", x264optsValue); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return forEachCodecOption(o, f)
 }
 
 func (o *X264AdvancedOptions) UpdateBitrate(bps int64) error {
@@ -83,35 +59,20 @@ func (o *X264AdvancedOptions) GetCurrentBitrate() (int64, error) {
 type X264Options struct {
 	*X264AdvancedOptions
 	// PRECOMPILED OPTIONS
-	Profile string `x264:"profile"`
-	Level   string `x264:"level"`
-	Preset  string `x264:"preset"`
-	Tune    string `x264:"tune"`
+	Profile string `codec:"profile,omitempty"`
+	Level   string `codec:"level,omitempty"`
+	Preset  string `codec:"preset,omitempty"`
+	Tune    string `codec:"tune,omitempty"`
 }
 
 func (o *X264Options) ForEach(f func(key, value string) error) error {
-	t := reflect.TypeOf(*o)
-	v := reflect.ValueOf(*o)
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("x264")
-		if tag != "" {
-			if err := f(tag, v.Field(i).String()); err != nil {
-				return err
-			}
-		}
-	}
-
-	return o.X264AdvancedOptions.ForEach(f)
+	return forEachCodecOption(o, f)
 }
 
 func (o *X264Options) UpdateBitrate(bps int64) error {
 	return o.X264AdvancedOptions.UpdateBitrate(bps)
 }
 
-// TODO: WARN: MAKING THIS A POINTER VARIABLE WILL MAKE ALL TRACKS WHICH USE THIS SETTINGS TO SHARE BITRATE
-
 var LowLatencyBitrateControlled = &X264Options{
 	Profile: "baseline",
 	Level:   "3.1",
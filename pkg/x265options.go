@@ -0,0 +1,105 @@
+package transcode
+
+import (
+	"strconv"
+)
+
+type X265AdvancedOptions struct {
+	// PRIMARY OPTIONS
+	Bitrate        string `codec:"bitrate,opts=x265-params,omitempty"`
+	VBVMaxBitrate  string `codec:"vbv-maxrate,opts=x265-params,omitempty"`
+	VBVBuffer      string `codec:"vbv-bufsize,opts=x265-params,omitempty"`
+	StrictCBR      string `codec:"strict-cbr,opts=x265-params,omitempty"`
+	MaxGOP         string `codec:"keyint,opts=x265-params,omitempty"`
+	MinGOP         string `codec:"min-keyint,opts=x265-params,omitempty"`
+	MaxQP          string `codec:"qpmax,opts=x265-params,omitempty"`
+	MinQP          string `codec:"qpmin,opts=x265-params,omitempty"`
+	IntraRefresh   string `codec:"intra-refresh,opts=x265-params,omitempty"`
+	RepeatHeaders  string `codec:"repeat-headers,opts=x265-params,omitempty"`
+	Level          string `codec:"level-idc,opts=x265-params,omitempty"`
+	ParallelFrames string `codec:"frame-threads,opts=x265-params,omitempty"`
+
+	// SECONDARY OPTIONS; SOME OF THEM ARE ALREADY SET BY PRESET, PROFILE AND TUNE
+	SceneCut    string `codec:"scenecut,opts=x265-params,omitempty"`
+	BFrames     string `codec:"bframes,opts=x265-params,omitempty"`
+	BAdapt      string `codec:"b-adapt,opts=x265-params,omitempty"`
+	Refs        string `codec:"ref,opts=x265-params,omitempty"`
+	RCLookAhead string `codec:"rc-lookahead,opts=x265-params,omitempty"`
+	AQMode      string `codec:"aq-mode,opts=x265-params,omitempty"`
+	HRD         string `codec:"hrd,opts=x265-params,omitempty"`
+}
+
+func (o *X265AdvancedOptions) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(o, f)
+}
+
+func (o *X265AdvancedOptions) UpdateBitrate(bps int64) error {
+	kbps := bps / 1000
+
+	// Core bitrate settings (strict CBR, like the x264 options)
+	o.Bitrate = strconv.FormatInt(kbps, 10)
+	o.VBVMaxBitrate = strconv.FormatInt(kbps, 10)
+
+	// VBV buffer: 0.5 seconds for low latency
+	bufferKb := max(kbps/2, 100)
+	o.VBVBuffer = strconv.FormatInt(bufferKb, 10)
+
+	return nil
+}
+
+func (o *X265AdvancedOptions) GetCurrentBitrate() (int64, error) {
+	kbps, err := strconv.ParseInt(o.Bitrate, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return kbps * 1000, nil // Convert kbps to bps
+}
+
+func (o *X265AdvancedOptions) rateControl() (RateControlConfig, error) {
+	if o == nil {
+		return RateControlConfig{}, ErrorCodecNoSetting
+	}
+	return rateControlFromKbps(o.Bitrate, o.VBVMaxBitrate, o.VBVBuffer)
+}
+
+type X265Options struct {
+	*X265AdvancedOptions
+	// PRECOMPILED OPTIONS
+	Profile string `codec:"profile,omitempty"`
+	Preset  string `codec:"preset,omitempty"`
+	Tune    string `codec:"tune,omitempty"`
+}
+
+func (o *X265Options) ForEach(f func(key, value string) error) error {
+	return forEachCodecOption(o, f)
+}
+
+func (o *X265Options) UpdateBitrate(bps int64) error {
+	return o.X265AdvancedOptions.UpdateBitrate(bps)
+}
+
+var LowLatencyBitrateControlledX265 = &X265Options{
+	Profile: "main",
+	Preset:  "ultrafast",
+	Tune:    "zerolatency",
+
+	X265AdvancedOptions: &X265AdvancedOptions{
+		Bitrate:        "500",
+		VBVMaxBitrate:  "500",
+		VBVBuffer:      "250",
+		StrictCBR:      "1",
+		MaxGOP:         "25",
+		MinGOP:         "13",
+		IntraRefresh:   "0",
+		RepeatHeaders:  "0",
+		Level:          "3.1",
+		ParallelFrames: "1", // NOTE: EVERY EXTRA FRAME THREAD IS A FRAME OF LATENCY
+		SceneCut:       "0",
+		BFrames:        "0",
+		BAdapt:         "0",
+		Refs:           "1",
+		RCLookAhead:    "0",
+		AQMode:         "1",
+		HRD:            "1",
+	},
+}